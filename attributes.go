@@ -0,0 +1,105 @@
+package r2sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// objectAttributes are the headers, user metadata, tags and ACL of an object. A server-side copy
+// between providers or a copy with a replaced metadata directive doesn't carry them over.
+type objectAttributes struct {
+	head    *s3.HeadObjectOutput
+	tagging string                 // url encoded tag set, s3:// only
+	acl     *s3.GetObjectAclOutput // s3:// only
+}
+
+// readAttributes fetches the attributes of key. Tags and ACLs are read from s3:// buckets only,
+// R2 supports neither.
+func (r *R2Client) readAttributes(ctx context.Context, key string) (*objectAttributes, error) {
+	head, err := r.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	attrs := &objectAttributes{head: head}
+	if r.scheme != "s3" {
+		return attrs, nil
+	}
+	tagging, err := r.client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags: %v", err)
+	}
+	tags := url.Values{}
+	for _, tag := range tagging.TagSet {
+		tags.Add(aws.ToString(tag.Key), aws.ToString(tag.Value))
+	}
+	attrs.tagging = tags.Encode()
+	if attrs.acl, err = r.client.GetObjectAcl(ctx, &s3.GetObjectAclInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read ACL: %v", err)
+	}
+	return attrs, nil
+}
+
+// CopyObjectWithAttributes copies an object server-side and reapplies the user metadata, content
+// headers, tags and ACL read from the source, instead of relying on the copy to carry them over
+func (r *R2Client) CopyObjectWithAttributes(ctx context.Context, source *R2Client, sourceKey, remotePath string, dryRun bool) error {
+	sourceURL := source.RemotePath(sourceKey)
+	if dryRun {
+		log.Printf("(dryrun) copy with attributes: %s -> %s\n", sourceURL, r.RemotePath(remotePath))
+		return nil
+	}
+	attrs, err := source.readAttributes(ctx, sourceKey)
+	if err != nil {
+		return err
+	}
+	input := &s3.CopyObjectInput{
+		Bucket:             aws.String(r.bucket),
+		Key:                aws.String(remotePath),
+		CopySource:         aws.String(copySource(source.bucket, sourceKey)),
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		Metadata:           attrs.head.Metadata,
+		ContentType:        attrs.head.ContentType,
+		CacheControl:       attrs.head.CacheControl,
+		ContentDisposition: attrs.head.ContentDisposition,
+		ContentEncoding:    attrs.head.ContentEncoding,
+		ContentLanguage:    attrs.head.ContentLanguage,
+		Expires:            attrs.head.Expires,
+	}
+	if r.scheme == "s3" && source.scheme == "s3" {
+		input.TaggingDirective = types.TaggingDirectiveReplace
+		input.Tagging = aws.String(attrs.tagging)
+		input.WebsiteRedirectLocation = attrs.head.WebsiteRedirectLocation
+	}
+	if err := r.copyObject(ctx, input, source.bucket, sourceKey, aws.ToInt64(attrs.head.ContentLength)); err != nil {
+		return err
+	}
+	if attrs.acl != nil && r.scheme == "s3" {
+		_, err := r.client.PutObjectAcl(ctx, &s3.PutObjectAclInput{
+			Bucket: aws.String(r.bucket),
+			Key:    aws.String(remotePath),
+			AccessControlPolicy: &types.AccessControlPolicy{
+				Grants: attrs.acl.Grants,
+				Owner:  attrs.acl.Owner,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("copied but failed to apply ACL: %v", err)
+		}
+	}
+	log.Printf("copy: %s -> %s (with attributes)\n", sourceURL, r.RemotePath(remotePath))
+	return nil
+}
@@ -0,0 +1,232 @@
+package r2sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// auditStats counts the outcome of an audit
+type auditStats struct {
+	mu         sync.Mutex
+	Objects    int // objects verified
+	Mismatches int // objects whose remote bytes differ
+	Skipped    int // sampled objects that could not be verified
+}
+
+func (s *auditStats) add(verified, mismatch bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case mismatch:
+		s.Objects++
+		s.Mismatches++
+	case verified:
+		s.Objects++
+	default:
+		s.Skipped++
+	}
+}
+
+// parseSample parses a sample size given as a percentage like 1% or an object count
+func parseSample(sample string, total int) (int, error) {
+	if percent, ok := strings.CutSuffix(sample, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return 0, fmt.Errorf("invalid sample %q", sample)
+		}
+		return min(total, int(math.Ceil(float64(total)*p/100))), nil
+	}
+	n, err := strconv.Atoi(sample)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid sample %q", sample)
+	}
+	return min(total, n), nil
+}
+
+// Audit verifies a random sample of the objects under prefix. With localDir a random byte range of
+// every sampled object is compared against the same range of its local file, reading chunked
+// files through their manifest. Without localDir sampled objects are read in full and compared
+// against their MD5 ETag, which covers chunk objects and every object uploaded in a single PUT.
+func (r *R2Client) Audit(ctx context.Context, prefix, localDir, sample string, rangeSize int64, concurrency int) (*auditStats, error) {
+	remoteFiles, err := r.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(remoteFiles))
+	for key := range remoteFiles {
+		// chunks and bundles have no local counterpart of their own
+		if localDir != "" && (isChunkKey(key) || isPackKey(prefix, key)) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	total := len(keys)
+	n, err := parseSample(sample, total)
+	if err != nil {
+		return nil, err
+	}
+	rand.Shuffle(total, func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	keys = keys[:n]
+	log.Printf("Auditing %d of %d objects: %s ...\n", n, total, r.RemotePath(prefix))
+
+	stats := &auditStats{}
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for _, key := range keys {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(object FileInfo) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			var verified, mismatch bool
+			var err error
+			if localDir != "" {
+				verified, mismatch, err = r.auditRange(ctx, object, prefix, localDir, rangeSize)
+			} else {
+				verified, mismatch, err = r.auditETag(ctx, object)
+			}
+			if err != nil {
+				log.Printf("audit failed %s: %s\n", r.RemotePath(object.Path), ErrorDetail(err))
+			}
+			stats.add(verified, mismatch)
+		}(remoteFiles[key])
+	}
+	wg.Wait()
+	return stats, nil
+}
+
+// auditETag reads an object in full and compares its MD5 with the ETag
+func (r *R2Client) auditETag(ctx context.Context, object FileInfo) (verified, mismatch bool, err error) {
+	etag := strings.Trim(object.ETag, `"`)
+	multipart := multipartParts(object.ETag) > 0
+	if len(etag) != 32 && !multipart {
+		log.Printf("skipped %s: no MD5 ETag\n", r.RemotePath(object.Path))
+		return false, false, nil
+	}
+	resp, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(object.Path),
+	})
+	if err != nil {
+		return false, false, err
+	}
+	defer resp.Body.Close()
+	if multipart {
+		// multipart ETags are the MD5 of the part MD5s, the part size is guessed
+		matched, err := multipartETagMatches(resp.Body, object.Size, object.ETag, 0)
+		if err != nil {
+			return false, false, err
+		}
+		if !matched {
+			log.Printf("skipped %s: no known part size matches the multipart ETag %s\n", r.RemotePath(object.Path), etag)
+		}
+		return matched, false, nil
+	}
+	sum := md5.New()
+	if _, err := io.Copy(sum, resp.Body); err != nil {
+		return false, false, err
+	}
+	if hex.EncodeToString(sum.Sum(nil)) != etag {
+		log.Printf("corrupt: %s, MD5 differs from ETag %s\n", r.RemotePath(object.Path), etag)
+		return true, true, nil
+	}
+	return true, false, nil
+}
+
+// auditRange compares a random byte range of an object with its local file
+func (r *R2Client) auditRange(ctx context.Context, object FileInfo, prefix, localDir string, rangeSize int64) (verified, mismatch bool, err error) {
+	head, err := r.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(object.Path),
+	})
+	if err != nil {
+		return false, false, err
+	}
+	if head.Metadata[metaCompression] != "" {
+		log.Printf("skipped %s: compressed contents\n", r.RemotePath(object.Path))
+		return false, false, nil
+	}
+	var manifest *chunkManifest
+	size := object.Size
+	if head.Metadata[chunkMetadata] != "" {
+		if manifest, err = r.loadChunkManifest(ctx, object.Path); err != nil || manifest == nil {
+			return false, false, err
+		}
+		size = manifest.Size
+	}
+
+	localPath, err := localPathFor(localDir, RelativeKey(object.Path, prefix), r.StrictKeys)
+	if err != nil {
+		return false, false, err
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		log.Printf("skipped %s: %v\n", r.RemotePath(object.Path), err)
+		return false, false, nil
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, false, err
+	}
+	if info.Size() != size {
+		log.Printf("skipped %s: local file changed since the sync\n", r.RemotePath(object.Path))
+		return false, false, nil
+	}
+	if size == 0 {
+		return true, false, nil
+	}
+
+	offset := rand.Int64N(max(size-rangeSize, 0) + 1)
+	length := min(rangeSize, size-offset)
+	key, keyOffset := object.Path, offset
+	if manifest != nil {
+		// stay within the chunk holding the offset
+		index := offset / manifest.ChunkSize
+		if index >= int64(len(manifest.Chunks)) {
+			return false, false, fmt.Errorf("manifest lists %d chunks, offset %d needs chunk %d", len(manifest.Chunks), offset, index)
+		}
+		key = chunkPrefix(object.Path) + manifest.Chunks[index]
+		keyOffset = offset - index*manifest.ChunkSize
+		length = min(length, manifest.ChunkSize-keyOffset)
+	}
+
+	local := make([]byte, length)
+	if _, err := file.ReadAt(local, offset); err != nil {
+		return false, false, err
+	}
+	resp, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", keyOffset, keyOffset+length-1)),
+	})
+	if err != nil {
+		return false, false, err
+	}
+	defer resp.Body.Close()
+	remote, err := io.ReadAll(io.LimitReader(resp.Body, length+1))
+	if err != nil {
+		return false, false, err
+	}
+	if !bytes.Equal(local, remote) {
+		log.Printf("corrupt: %s, bytes %d-%d differ from %s\n", r.RemotePath(object.Path), offset, offset+length-1, localPath)
+		return true, true, nil
+	}
+	return true, false, nil
+}
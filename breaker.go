@@ -0,0 +1,136 @@
+package r2sync
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	breakerWindow     = 50 // recent requests considered
+	breakerMinSamples = 20 // requests needed before the breaker can trip
+	breakerProbeDelay = 30 * time.Second
+)
+
+// circuitBreaker stops scheduling transfers when a large fraction of recent requests failed, and
+// probes the backend until it recovers instead of burning through requests that are bound to fail
+type circuitBreaker struct {
+	mu        sync.Mutex
+	ctx       context.Context // stops probing
+	threshold float64
+	probe     func(ctx context.Context) error
+	results   []ErrorClass // ring of recent results, "" is a success
+	next      int
+	open      chan struct{} // non-nil while open, closed when the backend recovered
+}
+
+// newCircuitBreaker returns a breaker that probes until ctx is cancelled, a threshold of 0 disables it
+func newCircuitBreaker(ctx context.Context, threshold float64, probe func(ctx context.Context) error) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{ctx: ctx, threshold: threshold, probe: probe}
+}
+
+// Record adds the result of a request and trips the breaker when the failure ratio reaches the threshold
+func (b *circuitBreaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := ErrorClass("")
+	if err != nil {
+		result = classifyError(err)
+	}
+	if len(b.results) < breakerWindow {
+		b.results = append(b.results, result)
+	} else {
+		b.results[b.next] = result
+		b.next = (b.next + 1) % breakerWindow
+	}
+	if b.open != nil || len(b.results) < breakerMinSamples {
+		return
+	}
+
+	failures := 0
+	counts := make(map[ErrorClass]int)
+	for _, class := range b.results {
+		if class != "" {
+			failures++
+			counts[class]++
+		}
+	}
+	if float64(failures)/float64(len(b.results)) < b.threshold {
+		return
+	}
+	var dominant ErrorClass
+	for _, c := range errorClasses {
+		if counts[c.class] > counts[dominant] {
+			dominant = c.class
+		}
+	}
+	diagnosis := string(dominant)
+	for _, c := range errorClasses {
+		if c.class == dominant && c.hint != "" {
+			diagnosis += ": " + c.hint
+		}
+	}
+	log.Printf("Circuit breaker open, %d of the last %d requests failed (mostly %s). Pausing transfers, probing every %s.\n",
+		failures, len(b.results), diagnosis, breakerProbeDelay)
+	b.open = make(chan struct{})
+	go b.recover(b.open)
+}
+
+// recover probes the backend until it succeeds and closes the breaker
+func (b *circuitBreaker) recover(open chan struct{}) {
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-time.After(breakerProbeDelay):
+		}
+		err := b.probe(b.ctx)
+		if err == nil {
+			break
+		}
+		log.Printf("circuit breaker probe failed: %v\n", err)
+	}
+	b.mu.Lock()
+	b.results = b.results[:0]
+	b.next = 0
+	b.open = nil
+	b.mu.Unlock()
+	close(open)
+	log.Println("Circuit breaker closed, resuming transfers.")
+}
+
+// Wait blocks while the breaker is open until it closes, ctx is cancelled or drain is closed
+func (b *circuitBreaker) Wait(ctx context.Context, drain <-chan struct{}) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	open := b.open
+	b.mu.Unlock()
+	if open == nil {
+		return
+	}
+	select {
+	case <-open:
+	case <-ctx.Done():
+	case <-drain:
+	}
+}
+
+// Probe checks that the bucket is reachable with the current credentials
+func (r *R2Client) Probe(ctx context.Context) error {
+	_, err := r.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(r.bucket),
+	})
+	return err
+}
@@ -0,0 +1,111 @@
+package r2sync
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ChaosOptions are the probabilities per request of each injected fault
+type ChaosOptions struct {
+	Errors   float64 // fail with a connection reset before the request is sent
+	Latency  float64 // delay the request by up to MaxDelay
+	Throttle float64 // answer with 503 SlowDown without sending the request
+	MaxDelay time.Duration
+}
+
+// ParseChaos parses a probability applied to every fault, like 0.05, or per fault probabilities
+// like errors=0.02,latency=0.1,throttle=0.05,delay=3s
+func ParseChaos(spec string) (*ChaosOptions, error) {
+	opts := &ChaosOptions{MaxDelay: 2 * time.Second}
+	if p, err := strconv.ParseFloat(spec, 64); err == nil {
+		opts.Errors, opts.Latency, opts.Throttle = p, p, p
+	} else {
+		for _, field := range strings.Split(spec, ",") {
+			key, value, _ := strings.Cut(field, "=")
+			if key == "delay" {
+				if opts.MaxDelay, err = time.ParseDuration(value); err != nil || opts.MaxDelay <= 0 {
+					return nil, fmt.Errorf("invalid chaos delay %q", value)
+				}
+				continue
+			}
+			p, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid chaos probability %q", field)
+			}
+			switch key {
+			case "errors":
+				opts.Errors = p
+			case "latency":
+				opts.Latency = p
+			case "throttle":
+				opts.Throttle = p
+			default:
+				return nil, fmt.Errorf("invalid chaos fault %q (valid faults: errors, latency, throttle, delay)", key)
+			}
+		}
+	}
+	for _, p := range []float64{opts.Errors, opts.Latency, opts.Throttle} {
+		if p < 0 || p > 1 {
+			return nil, fmt.Errorf("invalid chaos probability %v, must be between 0 and 1", p)
+		}
+	}
+	return opts, nil
+}
+
+// chaosHTTPClient injects faults into backend calls to exercise retries, resume and alerting
+type chaosHTTPClient struct {
+	next s3.HTTPClient
+	opts *ChaosOptions
+}
+
+func (c chaosHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if rand.Float64() < c.opts.Latency {
+		delay := rand.N(c.opts.MaxDelay)
+		log.Printf("chaos: delaying %s %s by %s\n", req.Method, req.URL.Path, delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if rand.Float64() < c.opts.Errors {
+		log.Printf("chaos: resetting %s %s\n", req.Method, req.URL.Path)
+		return nil, fmt.Errorf("chaos: %w", syscall.ECONNRESET)
+	}
+	if rand.Float64() < c.opts.Throttle {
+		log.Printf("chaos: throttling %s %s\n", req.Method, req.URL.Path)
+		body := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error><Code>SlowDown</Code><Message>Please reduce your request rate. (injected by --chaos)</Message></Error>"
+		return &http.Response{
+			Status:        "503 Slow Down",
+			StatusCode:    http.StatusServiceUnavailable,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": []string{"application/xml"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return c.next.Do(req)
+}
+
+// WithChaos wraps the http client of an s3 client with fault injection
+func WithChaos(opts *ChaosOptions) S3Option {
+	return func(o *s3.Options) {
+		next := o.HTTPClient
+		if next == nil {
+			next = http.DefaultClient
+		}
+		o.HTTPClient = chaosHTTPClient{next: next, opts: opts}
+	}
+}
@@ -0,0 +1,96 @@
+package r2sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrMaxRuntime is returned by Sync when it stopped early because the --max-runtime budget was used up
+var ErrMaxRuntime = errors.New("max runtime exceeded")
+
+// checkpointEntry identifies a local file version that was confirmed in sync with the target
+type checkpointEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// checkpoint records the files a stopped sync already confirmed, so the next run can skip hashing them
+type checkpoint struct {
+	mu     sync.Mutex
+	path   string
+	Source string                     `json:"source"`
+	Target string                     `json:"target"`
+	Files  map[string]checkpointEntry `json:"files"`
+}
+
+// DefaultCheckpointPath returns a per source and target checkpoint file in the user cache directory
+func DefaultCheckpointPath(source, target string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	sum := sha256.Sum256([]byte(source + "\n" + target))
+	return filepath.Join(dir, "r2sync", "checkpoint-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// loadCheckpoint reads the checkpoint at path, a missing or foreign checkpoint starts empty
+func loadCheckpoint(path, source, target string) *checkpoint {
+	c := &checkpoint{path: path, Source: source, Target: target, Files: make(map[string]checkpointEntry)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c
+	}
+	var saved checkpoint
+	if json.Unmarshal(data, &saved) == nil && saved.Source == source && saved.Target == target && saved.Files != nil {
+		c.Files = saved.Files
+	}
+	return c
+}
+
+// Done reports whether the file was confirmed in sync by a previous run and is unchanged since
+func (c *checkpoint) Done(relPath string, info os.FileInfo) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.Files[relPath]
+	return ok && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime())
+}
+
+// Record marks the file as confirmed in sync
+func (c *checkpoint) Record(relPath string, info os.FileInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.Files[relPath] = checkpointEntry{Size: info.Size(), ModTime: info.ModTime()}
+	c.mu.Unlock()
+}
+
+func (c *checkpoint) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0644)
+}
+
+// Remove deletes the checkpoint after a complete run
+func (c *checkpoint) Remove() error {
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
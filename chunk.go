@@ -0,0 +1,220 @@
+package r2sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	chunkSuffix   = ".r2sync-chunks"
+	chunkMetadata = "r2sync-chunked" // set on manifest objects
+)
+
+// chunkManifest is stored at the key of a chunked file and lists its chunk objects in order
+type chunkManifest struct {
+	Version   int       `json:"version"`
+	Size      int64     `json:"size"`
+	ChunkSize int64     `json:"chunk_size"`
+	ModTime   time.Time `json:"mtime"`
+	MD5       string    `json:"md5"`    // of the whole file
+	Chunks    []string  `json:"chunks"` // names under the chunk prefix
+}
+
+// chunkPrefix is the prefix of the chunk objects of the file at key
+func chunkPrefix(key string) string {
+	return key + chunkSuffix + "/"
+}
+
+// isChunkKey reports whether key is a chunk object of a chunked file
+func isChunkKey(key string) bool {
+	return strings.Contains(key, chunkSuffix+"/")
+}
+
+// loadChunkManifest returns the manifest at key, nil if the object is missing or not a manifest
+func (r *R2Client) loadChunkManifest(ctx context.Context, key string) (*chunkManifest, error) {
+	resp, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.Metadata[chunkMetadata] == "" {
+		return nil, nil
+	}
+	return decodeChunkManifest(key, resp.Body)
+}
+
+func decodeChunkManifest(key string, body io.Reader) (*chunkManifest, error) {
+	manifest := &chunkManifest{}
+	if err := json.NewDecoder(body).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("invalid chunk manifest %s: %v", key, err)
+	}
+	return manifest, nil
+}
+
+// ChunkedChanged reports whether the chunked copy at key differs from the local file. etag is the
+// md5 of the local file, empty to compare sizes only.
+func (r *R2Client) ChunkedChanged(ctx context.Context, key string, info os.FileInfo, etag string) (bool, error) {
+	manifest, err := r.loadChunkManifest(ctx, key)
+	if err != nil || manifest == nil {
+		return true, err
+	}
+	if manifest.Size != info.Size() {
+		return true, nil
+	}
+	return etag != "" && strings.Trim(etag, `"`) != manifest.MD5, nil
+}
+
+// UploadChunked uploads localPath as chunk objects of chunkSize bytes under key.r2sync-chunks/ and
+// then a manifest at key. Chunks already uploaded with the same content are skipped, so an
+// interrupted upload resumes where it stopped. Returns the keys written.
+func (r *R2Client) UploadChunked(ctx context.Context, localPath, key string, chunkSize int64, dryRun bool) ([]string, error) {
+	if dryRun {
+		log.Printf("(dryrun) upload: %s -> %s (chunked)\n", localPath, r.RemotePath(key))
+		return nil, nil
+	}
+
+	file, err := r.IOLimit.open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	existing, err := r.ListObjects(ctx, chunkPrefix(key))
+	if err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+	var transfer *meterTransfer
+	uploaded := false
+	if r.Meter != nil {
+		transfer = r.Meter.start(localPath, info.Size())
+		defer func() { transfer.finish(info.Size(), uploaded) }()
+	}
+	manifest := &chunkManifest{Version: 1, Size: info.Size(), ChunkSize: chunkSize, ModTime: info.ModTime()}
+	var written []string
+	whole := md5.New()
+	for offset, index := int64(0), 0; offset < info.Size() || index == 0; offset, index = offset+chunkSize, index+1 {
+		size := min(chunkSize, info.Size()-offset)
+		section := io.NewSectionReader(file, offset, size)
+		sum := md5.New()
+		if _, err := io.Copy(io.MultiWriter(sum, whole), section); err != nil {
+			return written, err
+		}
+		etag := `"` + hex.EncodeToString(sum.Sum(nil)) + `"`
+		name := fmt.Sprintf("%06d", index)
+		manifest.Chunks = append(manifest.Chunks, name)
+		chunk := chunkPrefix(key) + name
+		old, exists := existing[chunk]
+		delete(existing, chunk)
+		if exists && old.Size == size && old.ETag == etag {
+			if transfer != nil {
+				transfer.set(offset + size)
+			}
+			continue
+		}
+		if _, err := section.Seek(0, io.SeekStart); err != nil {
+			return written, err
+		}
+		var body io.ReadSeeker = section
+		if transfer != nil {
+			body = transfer.body(section, offset)
+		}
+		input := &s3.PutObjectInput{
+			Bucket:        aws.String(r.bucket),
+			Key:           aws.String(chunk),
+			Body:          body,
+			ContentLength: aws.Int64(size),
+			ContentType:   aws.String("application/octet-stream"),
+		}
+		r.applyObjectLock(input)
+		if _, err := r.client.PutObject(ctx, input); err != nil {
+			return written, fmt.Errorf("chunk %d: %v", index, err)
+		}
+		written = append(written, chunk)
+	}
+	manifest.MD5 = hex.EncodeToString(whole.Sum(nil))
+
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return written, err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(r.bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(body),
+		ContentLength: aws.Int64(int64(len(body))),
+		ContentType:   aws.String("application/json"),
+		Metadata:      map[string]string{chunkMetadata: "1"},
+	}
+	if r.RunID != "" {
+		input.Metadata[metaRun] = r.RunID
+	}
+	// the manifest stands in for the file at its key
+	r.applyHeaderRules(input)
+	r.applyObjectLock(input)
+	if _, err := r.client.PutObject(ctx, input); err != nil {
+		return written, err
+	}
+	written = append(written, key)
+	uploaded = true
+
+	// chunks beyond the end of a file that shrank, removed once the manifest no longer references them
+	for stale := range existing {
+		if err := r.DeleteObject(ctx, stale, false); err != nil {
+			log.Printf("delete failed %s: %s\n", r.RemotePath(stale), ErrorDetail(err))
+		}
+	}
+
+	elapsedTime := time.Since(startTime).Seconds()
+	log.Printf("upload: %s -> %s, size: %s, %d chunks, average speed: %s\n", localPath, r.RemotePath(key), formatSize(info.Size()), len(manifest.Chunks), formatSpeed(float64(info.Size())/elapsedTime))
+	return written, nil
+}
+
+// writeChunks streams the chunks listed in the manifest at key to w in order
+func (r *R2Client) writeChunks(ctx context.Context, key string, manifest *chunkManifest, w io.Writer) (int64, error) {
+	var written int64
+	for _, name := range manifest.Chunks {
+		resp, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(r.bucket),
+			Key:    aws.String(chunkPrefix(key) + name),
+		})
+		if err != nil {
+			return written, fmt.Errorf("chunk %s: %v", name, err)
+		}
+		n, err := io.Copy(w, resp.Body)
+		resp.Body.Close()
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	if written != manifest.Size {
+		return written, fmt.Errorf("reassembled %s has %d bytes, manifest says %d", r.RemotePath(key), written, manifest.Size)
+	}
+	return written, nil
+}
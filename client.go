@@ -0,0 +1,439 @@
+package r2sync
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+type R2Client struct {
+	client *s3.Client
+	bucket string
+	scheme string
+	retry  *RetryPolicy // of WithRetryPolicy, nil uses the sdk defaults
+
+	// directory of partial downloads, empty writes them next to their destination
+	tempDir string
+
+	// Object Lock settings applied to uploaded objects
+	RetentionMode    types.ObjectLockMode
+	RetentionPeriod  time.Duration
+	LegalHoldStatus  types.ObjectLockLegalHoldStatus
+	BypassGovernance bool
+
+	// files up to this size are read into memory and sent without Expect: 100-continue
+	SmallObjectThreshold int64
+
+	// compression applied to uploaded contents: zstd, gzip or empty
+	Compression string
+
+	// tracks the bytes of running uploads and downloads for progress bars, nil disables it
+	Meter *TransferMeter
+
+	// store the md5 and modification time of uploaded files in the metadata keys rclone reads
+	RcloneMetadata bool
+
+	// id of the current run recorded in the metadata of uploaded objects, empty records none
+	RunID string
+
+	// bounds the local files read at the same time, each from open to close, nil doesn't limit
+	IOLimit *IOLimiter
+
+	// downloads refuse keys with .. segments or absolute-looking keys instead of sanitizing them
+	StrictKeys bool
+
+	// transfer logs without timings, so identical runs log identical lines
+	DeterministicLogs bool
+
+	// called whenever a sync, event or replication loop makes progress, e.g. to ping a watchdog
+	Heartbeat func()
+
+	// content headers set on uploaded objects by key pattern, the last matching rule decides
+	HeaderRules []HeaderRule
+}
+
+type FileInfo struct {
+	Path         string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+func (r *R2Client) RemotePath(path string) string {
+	return fmt.Sprintf("%s://%s/%s", r.scheme, r.bucket, path)
+}
+
+// List remote files
+func (r *R2Client) ListObjects(ctx context.Context, prefix string) (map[string]FileInfo, error) {
+	result := make(map[string]FileInfo)
+	var continuationToken *string
+
+	for {
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(r.bucket),
+			Prefix: aws.String(prefix),
+		}
+		if continuationToken != nil {
+			input.ContinuationToken = continuationToken
+		}
+
+		resp, err := r.client.ListObjectsV2(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range resp.Contents {
+			result[*obj.Key] = FileInfo{
+				Path:         *obj.Key,
+				Size:         *obj.Size,
+				LastModified: *obj.LastModified,
+				ETag:         *obj.ETag,
+			}
+		}
+
+		if !*resp.IsTruncated {
+			break
+		}
+		continuationToken = resp.NextContinuationToken
+	}
+
+	return result, nil
+}
+
+// format speed display
+func formatSpeed(bytesPerSecond float64) string {
+	units := []string{"B/s", "KB/s", "MB/s", "GB/s", "TB/s"}
+	unit := 0
+	speed := bytesPerSecond
+
+	for speed >= 1024 && unit < len(units)-1 {
+		speed /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.2f %s", speed, units[unit])
+}
+
+func formatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	unit := 0
+	bytes := float64(size)
+
+	for bytes >= 1024 && unit < len(units)-1 {
+		bytes /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.2f %s", bytes, units[unit])
+}
+
+// UploadFile uploads a local file to the key remotePath
+func (r *R2Client) UploadFile(ctx context.Context, localPath, remotePath string, dryRun bool) error {
+	if dryRun {
+		log.Printf("(dryrun) upload: %s -> %s\n", localPath, r.RemotePath(remotePath))
+		return nil
+	}
+
+	file, err := r.IOLimit.open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+
+	// Guess MIME type based on file extension
+	contentType := mimeByExtension(localPath)
+
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(r.bucket),
+		Key:           aws.String(remotePath),
+		Body:          file,
+		ContentLength: aws.Int64(fileInfo.Size()),
+		ContentType:   aws.String(contentType),
+	}
+	bodySize := fileInfo.Size()
+	if r.Compression != "" {
+		compressed, sum, err := compressFile(file, r.Compression)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(compressed.Name())
+		defer compressed.Close()
+		compressedInfo, err := compressed.Stat()
+		if err != nil {
+			return err
+		}
+		bodySize = compressedInfo.Size()
+		input.Body = compressed
+		input.ContentLength = aws.Int64(bodySize)
+		input.Metadata = map[string]string{
+			metaCompression: r.Compression,
+			metaSize:        strconv.FormatInt(fileInfo.Size(), 10),
+			metaMD5:         sum,
+		}
+	}
+	if r.RcloneMetadata {
+		// the md5 of the original file doesn't match the contents of a compressed object
+		var sum []byte
+		if r.Compression == "" {
+			if sum, err = fileMD5(file); err != nil {
+				return err
+			}
+		}
+		if input.Metadata == nil {
+			input.Metadata = make(map[string]string)
+		}
+		maps.Copy(input.Metadata, rcloneMetadata(fileInfo, sum))
+	}
+	if r.RunID != "" {
+		if input.Metadata == nil {
+			input.Metadata = make(map[string]string)
+		}
+		input.Metadata[metaRun] = r.RunID
+	}
+	var putOpts []func(*s3.Options)
+	if bodySize <= r.SmallObjectThreshold {
+		body, release, err := readSmallObject(input.Body, bodySize)
+		if err != nil {
+			return err
+		}
+		defer release()
+		input.Body = body
+		putOpts = append(putOpts, withoutContinue)
+	}
+	r.applyObjectLock(input)
+	r.applyHeaderRules(input)
+	var transfer *meterTransfer
+	if r.Meter != nil {
+		transfer = r.Meter.start(localPath, bodySize)
+		input.Body = transfer.body(input.Body.(io.ReadSeeker), 0)
+	}
+
+	_, err = r.client.PutObject(ctx, input, putOpts...)
+	if transfer != nil {
+		transfer.finish(fileInfo.Size(), err == nil)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	elapsedTime := time.Since(startTime).Seconds()
+	bytesPerSecond := float64(fileInfo.Size()) / elapsedTime
+	speedStr := formatSpeed(bytesPerSecond)
+	sizeStr := formatSize(fileInfo.Size())
+	if r.Compression != "" {
+		sizeStr += fmt.Sprintf(" (%s %s)", r.Compression, formatSize(bodySize))
+	}
+	if r.DeterministicLogs {
+		log.Printf("upload: %s -> %s, size: %s\n", localPath, r.RemotePath(remotePath), sizeStr)
+		return nil
+	}
+	log.Printf("upload: %s -> %s, size: %s, average speed: %s\n", localPath, r.RemotePath(remotePath), sizeStr, speedStr)
+
+	return nil
+}
+
+func (r *R2Client) DeleteObject(ctx context.Context, remotePath string, dryRun bool) error {
+	if dryRun {
+		log.Printf("(dryrun) delete: %s\n", r.RemotePath(remotePath))
+		return nil
+	}
+
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(remotePath),
+	}
+	if r.BypassGovernance {
+		input.BypassGovernanceRetention = aws.Bool(true)
+	}
+	if _, err := r.client.DeleteObject(ctx, input); err != nil {
+		return r.retentionError(ctx, remotePath, err)
+	}
+	log.Printf("delete: %s\n", r.RemotePath(remotePath))
+	return nil
+}
+
+// maxDeleteBatch is the most keys one DeleteObjects request accepts
+const maxDeleteBatch = 1000
+
+// DeleteObjects deletes keys with one DeleteObjects request per 1000 keys and returns the errors of
+// the keys that weren't deleted. Keys protected by Object Lock fail with a RetentionProtectedError.
+// The error is set when a whole request failed, its keys are also in the returned map.
+func (r *R2Client) DeleteObjects(ctx context.Context, keys []string, dryRun bool) (map[string]error, error) {
+	failed := make(map[string]error)
+	if dryRun {
+		for _, key := range keys {
+			log.Printf("(dryrun) delete: %s\n", r.RemotePath(key))
+		}
+		return failed, nil
+	}
+	var requestErr error
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		batch := keys[start:min(start+maxDeleteBatch, len(keys))]
+		objects := make([]types.ObjectIdentifier, len(batch))
+		for i, key := range batch {
+			objects[i] = types.ObjectIdentifier{Key: aws.String(key)}
+		}
+		input := &s3.DeleteObjectsInput{
+			Bucket: aws.String(r.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		}
+		if r.BypassGovernance {
+			input.BypassGovernanceRetention = aws.Bool(true)
+		}
+		resp, err := r.client.DeleteObjects(ctx, input)
+		if err != nil {
+			requestErr = err
+			for _, key := range batch {
+				failed[key] = err
+			}
+			continue
+		}
+		for _, keyErr := range resp.Errors {
+			key := aws.ToString(keyErr.Key)
+			err := error(&smithy.GenericAPIError{Code: aws.ToString(keyErr.Code), Message: aws.ToString(keyErr.Message)})
+			if keyErr.Code != nil && *keyErr.Code == "AccessDenied" {
+				err = r.retentionError(ctx, key, err)
+			}
+			failed[key] = err
+		}
+		for _, key := range batch {
+			if _, ok := failed[key]; !ok {
+				log.Printf("delete: %s\n", r.RemotePath(key))
+			}
+		}
+	}
+	return failed, requestErr
+}
+
+func NormalizePath(path string) string {
+	return strings.ReplaceAll(path, "\\", "/")
+}
+
+// parse remote url like r2://bucket/path into scheme, bucket and key prefix, client options given
+// as query parameters are validated here and returned by RemoteURLOptions. The key is taken
+// verbatim, so spaces, #, % and non-ASCII characters are not url-decoded or cut off.
+func ParseRemoteURL(remoteURL string) (scheme, bucket, key string, err error) {
+	location, query, err := splitURLOptions(NormalizePath(remoteURL))
+	if err != nil {
+		return
+	}
+	scheme, rest, ok := strings.Cut(location, "://")
+	bucket, key, _ = strings.Cut(rest, "/")
+	if !ok || scheme == "" || bucket == "" {
+		err = fmt.Errorf("%s is not a remote url like r2://bucket/path", remoteURL)
+		return
+	}
+	if err = validateURLOptions(query); err != nil {
+		return
+	}
+	return scheme, bucket, key, nil
+}
+
+func shouldExclude(fullpath string, excludePatterns []string) bool {
+	for _, pattern := range excludePatterns {
+		if matchesPattern(fullpath, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPattern reports whether pattern matches the full path or any part of it
+func matchesPattern(fullpath, pattern string) bool {
+	matched, err := path.Match(pattern, fullpath)
+	if err == nil && matched {
+		return true
+	}
+	// check any part of the path
+	parts := strings.Split(fullpath, "/")
+	for _, part := range parts {
+		matched, err := path.Match(pattern, part)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter is an --exclude or --include pattern. Filters are evaluated in the order given and the
+// last one matching a path decides, like aws s3 sync, paths matching none are synced.
+type Filter struct {
+	Pattern string
+	Include bool
+}
+
+// filteredOut reports whether the last filter matching fullpath excludes it. An excluded directory
+// is still descended into when a later include filter may match files below it.
+func filteredOut(fullpath string, dir bool, filters []Filter) bool {
+	for i := len(filters) - 1; i >= 0; i-- {
+		if !matchesPattern(fullpath, filters[i].Pattern) {
+			continue
+		}
+		if filters[i].Include {
+			return false
+		}
+		if dir {
+			for _, later := range filters[i+1:] {
+				if later.Include {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ParseHidden parses the --hidden policy and reports whether dotfiles are excluded
+func ParseHidden(policy string) (bool, error) {
+	switch policy {
+	case "include":
+		return false, nil
+	case "exclude":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid hidden policy: %s (valid values: include, exclude)", policy)
+	}
+}
+
+// isHidden reports whether a file or directory below localPath is a dotfile or dot-directory,
+// localPath itself is never hidden
+func isHidden(localPath, fullpath string) bool {
+	return fullpath != NormalizePath(localPath) && strings.HasPrefix(path.Base(fullpath), ".")
+}
+
+func calcETag(limit *IOLimiter, path string) (etag string, err error) {
+	file, err := limit.open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	hash := md5.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return
+	}
+	etag = "\"" + hex.EncodeToString(hash.Sum(nil)) + "\""
+	return etag, nil
+}
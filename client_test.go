@@ -0,0 +1,55 @@
+package r2sync
+
+import "testing"
+
+func TestParseRemoteURL(t *testing.T) {
+	tests := []struct {
+		url    string
+		scheme string
+		bucket string
+		key    string
+	}{
+		{"r2://bucket", "r2", "bucket", ""},
+		{"r2://bucket/", "r2", "bucket", ""},
+		{"s3://bucket/path/to/file", "s3", "bucket", "path/to/file"},
+		{"r2://bucket/my file.txt", "r2", "bucket", "my file.txt"},
+		{"r2://bucket/dir with spaces/", "r2", "bucket", "dir with spaces/"},
+		{"r2://bucket/notes#1.txt", "r2", "bucket", "notes#1.txt"},
+		{"r2://bucket/#top", "r2", "bucket", "#top"},
+		{"r2://bucket/100%.txt", "r2", "bucket", "100%.txt"},
+		{"r2://bucket/a%20b", "r2", "bucket", "a%20b"},
+		{"r2://bucket/why?.txt", "r2", "bucket", "why?.txt"},
+		{"r2://bucket/report?a=1", "r2", "bucket", "report?a=1"},
+		{"r2://bucket/照片/旅行 2024.jpg", "r2", "bucket", "照片/旅行 2024.jpg"},
+		{"r2://bucket/path?region=auto", "r2", "bucket", "path"},
+		{"r2://bucket/report?a=1?region=auto", "r2", "bucket", "report?a=1"},
+		{`r2://bucket\dir\file`, "r2", "bucket", "dir/file"},
+	}
+	for _, test := range tests {
+		scheme, bucket, key, err := ParseRemoteURL(test.url)
+		if err != nil {
+			t.Errorf("ParseRemoteURL(%q) failed: %v", test.url, err)
+			continue
+		}
+		if scheme != test.scheme || bucket != test.bucket || key != test.key {
+			t.Errorf("ParseRemoteURL(%q) = %q, %q, %q, want %q, %q, %q", test.url, scheme, bucket, key, test.scheme, test.bucket, test.key)
+		}
+	}
+}
+
+func TestParseRemoteURLInvalid(t *testing.T) {
+	for _, remoteURL := range []string{
+		"",
+		"bucket/path",
+		"r2://",
+		"://bucket/path",
+		"r2:///path",
+		"r2://bucket/path?region=",
+		"r2://bucket/path?region=a&region=b",
+		"r2://bucket/path?endpoint=example.com",
+	} {
+		if _, _, _, err := ParseRemoteURL(remoteURL); err == nil {
+			t.Errorf("ParseRemoteURL(%q) succeeded, want an error", remoteURL)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofika/r2sync"
+	"gopkg.in/yaml.v3"
+)
+
+func isYAMLFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// readConfigFile decodes a JSON or YAML (by .yaml/.yml extension) file into v.
+// Field names follow the aws cli JSON format, e.g. {"Rules": [{"ID": ...}]}
+func readConfigFile(name string, v any) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if isYAMLFile(name) {
+		var doc any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+	return nil
+}
+
+// pruneNulls drops null values and empty objects so sdk types print without unset fields
+func pruneNulls(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			value = pruneNulls(value)
+			if m, ok := value.(map[string]any); value == nil || (ok && len(m) == 0) {
+				delete(v, key)
+				continue
+			}
+			v[key] = value
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = pruneNulls(value)
+		}
+	}
+	return v
+}
+
+// writeConfigFile encodes v as JSON, or YAML when name has a .yaml/.yml extension.
+// An empty name or "-" writes JSON to stdout.
+func writeConfigFile(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	doc = pruneNulls(doc)
+
+	if isYAMLFile(name) {
+		data, err = yaml.Marshal(doc)
+	} else {
+		data, err = json.MarshalIndent(doc, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	if name == "" || name == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+// bucketConfigCommand implements get|put subcommands that manage a bucket level configuration document
+type bucketConfigCommand struct {
+	name  string
+	usage func()
+	get   func(ctx context.Context, client *r2sync.R2Client) (any, error)
+	put   func(ctx context.Context, client *r2sync.R2Client, name string, dryRun bool) error
+}
+
+func (c *bucketConfigCommand) run(args []string) {
+	if len(args) == 0 {
+		c.usage()
+		os.Exit(1)
+	}
+	action := args[0]
+	flags := flag.NewFlagSet(c.name, flag.ExitOnError)
+	flags.Usage = c.usage
+	dryRun := flags.Bool("dryrun", false, "Only display the operations to be performed, without actually executing them")
+	flags.Parse(args[1:])
+
+	if flags.NArg() < 1 {
+		c.usage()
+		os.Exit(1)
+	}
+	_, _, _, err := r2sync.ParseRemoteURL(flags.Arg(0))
+	if err != nil {
+		fmt.Println("Invalid bucket path: ", err)
+		fmt.Println()
+		c.usage()
+		os.Exit(1)
+	}
+	ctx := context.Background()
+	client, err := r2sync.NewClient(ctx, r2sync.WithR2Env(), r2sync.WithRemoteURL(flags.Arg(0)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch action {
+	case "get":
+		config, err := c.get(ctx, client)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeConfigFile(flags.Arg(1), config); err != nil {
+			log.Fatal(err)
+		}
+	case "put":
+		if flags.NArg() != 2 {
+			c.usage()
+			os.Exit(1)
+		}
+		if err := c.put(ctx, client, flags.Arg(1), *dryRun); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		c.usage()
+		os.Exit(1)
+	}
+}
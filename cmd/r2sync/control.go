@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofika/r2sync"
+)
+
+// RunResult summarizes a sync run
+type RunResult struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+	Uploads  int       `json:"uploads"`
+	Deletes  int       `json:"deletes"`
+	Failures int       `json:"failures"`
+	Bytes    int64     `json:"bytes"`
+	PlanHash string    `json:"plan_hash,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Errors   []string  `json:"errors,omitempty"` // failed transfers, at most maxRunErrors
+}
+
+const (
+	maxRunHistory     = 50
+	maxRunErrors      = 100
+	throughputBucket  = 10 * time.Second
+	throughputSamples = 360
+)
+
+// throughputSample is the number of bytes uploaded in a throughputBucket starting at Time
+type throughputSample struct {
+	Time  time.Time `json:"time"`
+	Bytes int64     `json:"bytes"`
+}
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// daemonState tracks the daemon loop from progress events for the control API
+type daemonState struct {
+	mu       sync.Mutex
+	pause    *r2sync.PauseGate
+	trigger  chan struct{}
+	nextRun  time.Time
+	inflight map[string]r2sync.ProgressEvent
+	current  *RunResult
+	last     *RunResult
+	history  []*RunResult // completed runs, newest first
+	samples  []throughputSample
+	cancel   context.CancelFunc // cancels the running sync
+
+	subscribers map[chan r2sync.ProgressEvent]struct{}
+}
+
+func newDaemonState(pause *r2sync.PauseGate) *daemonState {
+	return &daemonState{
+		pause:    pause,
+		trigger:  make(chan struct{}, 1),
+		inflight: make(map[string]r2sync.ProgressEvent),
+
+		subscribers: make(map[chan r2sync.ProgressEvent]struct{}),
+	}
+}
+
+// Run runs fn with a context that Cancel aborts and records its result
+func (s *daemonState) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+
+	err := fn(ctx)
+	s.mu.Lock()
+	s.cancel = nil
+	s.mu.Unlock()
+	s.Finish(err)
+	return err
+}
+
+// Cancel aborts the running sync including in-flight transfers, it reports whether a sync was running
+func (s *daemonState) Cancel() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel == nil {
+		return false
+	}
+	s.cancel()
+	return true
+}
+
+// Subscribe returns a channel receiving progress events until unsubscribe is called.
+// Events are dropped for subscribers that fall behind.
+func (s *daemonState) Subscribe() (events <-chan r2sync.ProgressEvent, unsubscribe func()) {
+	queue := make(chan r2sync.ProgressEvent, 256)
+	s.mu.Lock()
+	s.subscribers[queue] = struct{}{}
+	s.mu.Unlock()
+	return queue, func() {
+		s.mu.Lock()
+		delete(s.subscribers, queue)
+		s.mu.Unlock()
+	}
+}
+
+// Track updates the state from a progress event
+func (s *daemonState) Track(event r2sync.ProgressEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for queue := range s.subscribers {
+		select {
+		case queue <- event:
+		default:
+		}
+	}
+	switch event.Type {
+	case "sync_start":
+		s.current = &RunResult{Started: event.Time}
+		s.inflight = make(map[string]r2sync.ProgressEvent)
+	case "upload_start":
+		s.inflight[event.Target] = event
+	case "upload_done":
+		delete(s.inflight, event.Target)
+		if s.current != nil {
+			s.current.Uploads++
+			s.current.Bytes += event.Size
+		}
+		s.addThroughput(event.Time, event.Size)
+	case "upload_failed", "delete_failed":
+		delete(s.inflight, event.Target)
+		if s.current != nil {
+			s.current.Failures++
+			if len(s.current.Errors) < maxRunErrors {
+				s.current.Errors = append(s.current.Errors, event.Target+": "+event.Error)
+			}
+		}
+	case "delete_done":
+		if s.current != nil {
+			s.current.Deletes++
+		}
+	case "sync_done":
+		if s.current != nil {
+			s.current.PlanHash = event.PlanHash
+		}
+	}
+}
+
+// Finish records the result of the current run
+func (s *daemonState) Finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		s.current = &RunResult{Started: time.Now()}
+	}
+	s.current.Finished = time.Now()
+	if err != nil {
+		s.current.Error = err.Error()
+	}
+	s.last = s.current
+	s.history = append([]*RunResult{s.current}, s.history...)
+	if len(s.history) > maxRunHistory {
+		s.history = s.history[:maxRunHistory]
+	}
+	s.current = nil
+	s.inflight = make(map[string]r2sync.ProgressEvent)
+}
+
+// addThroughput adds uploaded bytes to the sample of their bucket
+func (s *daemonState) addThroughput(at time.Time, size int64) {
+	at = at.Truncate(throughputBucket)
+	if n := len(s.samples); n > 0 && s.samples[n-1].Time.Equal(at) {
+		s.samples[n-1].Bytes += size
+		return
+	}
+	s.samples = append(s.samples, throughputSample{Time: at, Bytes: size})
+	if len(s.samples) > throughputSamples {
+		s.samples = s.samples[len(s.samples)-throughputSamples:]
+	}
+}
+
+// Trigger receives sync requests, a nil state never triggers
+func (s *daemonState) Trigger() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.trigger
+}
+
+// SetNextRun records when the scheduler runs next
+func (s *daemonState) SetNextRun(next time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.nextRun = next
+	s.mu.Unlock()
+}
+
+func (s *daemonState) status() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := "idle"
+	if s.current != nil {
+		state = "running"
+	}
+	if s.pause.Paused() {
+		state = "paused"
+	}
+	inflight := make([]r2sync.ProgressEvent, 0, len(s.inflight))
+	for _, event := range s.inflight {
+		inflight = append(inflight, event)
+	}
+	sort.Slice(inflight, func(i, j int) bool { return inflight[i].Time.Before(inflight[j].Time) })
+	// the current run keeps changing after the lock is released
+	var current *RunResult
+	if s.current != nil {
+		run := *s.current
+		current = &run
+	}
+	return map[string]any{
+		"state":    state,
+		"next_run": s.nextRun,
+		"inflight": inflight,
+		"current":  current,
+		"last":     s.last,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Handler serves the control API and the dashboard:
+//
+//	GET  /           web dashboard
+//	GET  /status     state, next run, in-flight transfers, current and last run
+//	GET  /runs       completed runs, newest first
+//	GET  /runs/last  result of the last completed run
+//	GET  /throughput uploaded bytes per 10s over the last hour
+//	POST /sync       trigger a sync now
+//	POST /cancel     abort the running sync including in-flight transfers
+//	POST /pause      stop scheduling new transfers
+//	POST /resume     resume scheduling transfers
+func (s *daemonState) Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.status())
+	})
+	mux.HandleFunc("GET /runs", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		history := append([]*RunResult{}, s.history...)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, history)
+	})
+	mux.HandleFunc("GET /throughput", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		samples := append([]throughputSample{}, s.samples...)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, samples)
+	})
+	mux.HandleFunc("GET /runs/last", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		last := s.last
+		s.mu.Unlock()
+		if last == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no completed run"})
+			return
+		}
+		writeJSON(w, http.StatusOK, last)
+	})
+	mux.HandleFunc("POST /sync", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case s.trigger <- struct{}{}:
+		default:
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "triggered"})
+	})
+	mux.HandleFunc("POST /cancel", func(w http.ResponseWriter, r *http.Request) {
+		if !s.Cancel() {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "no sync running"})
+			return
+		}
+		log.Println("Sync cancelled via control API.")
+		writeJSON(w, http.StatusOK, map[string]string{"status": "cancelled"})
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		s.pause.Pause()
+		log.Println("Paused via control API.")
+		writeJSON(w, http.StatusOK, map[string]string{"status": "paused"})
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		s.pause.Resume()
+		log.Println("Resumed via control API.")
+		writeJSON(w, http.StatusOK, map[string]string{"status": "resumed"})
+	})
+
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the dashboard page holds no data, it reads the token from the url fragment
+		if r.URL.Path != "/" && r.Header.Get("Authorization") != "Bearer "+token {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// serveControl starts the control API on addr in the background
+func serveControl(addr, token string, state *daemonState) {
+	server := &http.Server{Addr: addr, Handler: state.Handler(token), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("Control API listening on %s\n", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("control api failed: %v\n", err)
+		}
+	}()
+}
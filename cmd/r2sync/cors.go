@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gofika/r2sync"
+)
+
+func corsUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: r2sync cors get <bucket url> [output file]
+       r2sync cors put [--dryrun] <bucket url> <config file>
+
+Config files use the aws cli JSON format, or YAML when the file ends with .yaml/.yml.
+Putting a config without rules removes the bucket CORS configuration.
+
+Options:
+  --dryrun (boolean)
+    	Only display the operations to be performed, without actually executing them
+
+Examples:
+    r2sync cors get r2://bucket
+    r2sync cors put r2://bucket cors.json`)
+}
+
+func runCORS(args []string) {
+	command := &bucketConfigCommand{
+		name:  "cors",
+		usage: corsUsage,
+		get: func(ctx context.Context, client *r2sync.R2Client) (any, error) {
+			return client.GetCORS(ctx)
+		},
+		put: func(ctx context.Context, client *r2sync.R2Client, name string, dryRun bool) error {
+			var cors types.CORSConfiguration
+			if err := readConfigFile(name, &cors); err != nil {
+				return err
+			}
+			return client.PutCORS(ctx, &cors, dryRun)
+		},
+	}
+	command.run(args)
+}
@@ -0,0 +1,7 @@
+package main
+
+import "log"
+
+func setDeterministic() {
+	log.SetFlags(0)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/gofika/r2sync"
+)
+
+func eventsUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: r2sync events (--queue-id ID | --sqs-queue-url URL) [options] <source url> <local path>
+
+Consumes object event notifications and pull-syncs only the changed keys into the local
+directory, for near-real-time mirroring without full listings. R2 event notifications are
+read from a Cloudflare Queue with an HTTP pull consumer (token in CLOUDFLARE_API_TOKEN),
+S3 event notifications are read from an SQS queue.
+
+Options:
+  --account-id (string)
+    	Cloudflare account id of the queue, defaults to the CLOUDFLARE_ACCOUNT_ID environment variable
+  --concurrency (number)
+    	Number of concurrent download/delete operations, default is 5
+  --delete (boolean)
+    	Delete local files when their remote objects are deleted
+  --dryrun (boolean)
+    	Only display the operations to be performed, without actually executing them
+  --exclude (pattern)
+    	Local file or directory patterns that are neither pulled nor deleted, can be used multiple times,
+    	see --include
+  --hidden (include|exclude)
+    	Whether dotfiles and dot-directories are pulled and deleted, default is include
+  --include (pattern)
+    	Pull files matching this pattern even if an earlier --exclude matches them, evaluated in order
+    	with --exclude like in a sync
+  --max-delete (number)
+    	Delete at most this many local files per run, further deletes fail and are redelivered, 0 is
+    	unlimited
+  --poll-interval (duration)
+    	Wait time between polls of an empty queue, default is 5s
+  --queue-id (string)
+    	Cloudflare Queue id receiving R2 event notifications
+  --request-payer (requester)
+    	Accept the request charges of requester-pays source buckets
+  --shutdown-grace (duration)
+    	Time in-flight transfers may take to finish after SIGTERM before they are aborted, default is 25s
+  --sqs-queue-url (string)
+    	SQS queue url receiving S3 event notifications
+  --strict-keys (boolean)
+    	Fail on keys with .. segments or absolute-looking keys instead of writing them to a sanitized
+    	path inside the local directory
+  --temp-dir (path)
+    	Directory for partial downloads, renamed into place when complete, default is next to the
+    	destination file
+
+Examples:
+    r2sync events --queue-id 0123456789abcdef --delete r2://bucket/path/ /local/dir
+    r2sync events --sqs-queue-url https://sqs.us-east-1.amazonaws.com/123456789012/events s3://bucket/path/ /local/dir`)
+}
+
+func runEvents(args []string) {
+	flags := flag.NewFlagSet("events", flag.ExitOnError)
+	flags.Usage = eventsUsage
+	accountID := flags.String("account-id", os.Getenv("CLOUDFLARE_ACCOUNT_ID"), "Cloudflare account id of the queue")
+	queueID := flags.String("queue-id", "", "Cloudflare Queue id receiving R2 event notifications")
+	sqsQueueURL := flags.String("sqs-queue-url", "", "SQS queue url receiving S3 event notifications")
+	deleteSync := flags.Bool("delete", false, "Delete local files when their remote objects are deleted")
+	strictKeys := flags.Bool("strict-keys", false, "Fail on keys with .. segments or absolute-looking keys instead of sanitizing them")
+	tempDir := flags.String("temp-dir", "", "Directory for partial downloads, default is next to the destination file")
+	maxDelete := flags.Int("max-delete", 0, "Delete at most this many local files per run, 0 is unlimited")
+	var filters []r2sync.Filter
+	flags.Var(filterFlag{filters: &filters}, "exclude", "Local file or directory patterns that are neither pulled nor deleted, can be used multiple times")
+	flags.Var(filterFlag{filters: &filters, include: true}, "include", "Pull files matching this pattern that an earlier --exclude skips, can be used multiple times")
+	hidden := flags.String("hidden", "include", "Pull dotfiles and dot-directories (include) or skip them (exclude)")
+	dryRun := flags.Bool("dryrun", false, "Only display the operations to be performed, without actually executing them")
+	concurrency := flags.Int("concurrency", 5, "Number of concurrent download/delete operations")
+	shutdownGrace := flags.Duration("shutdown-grace", 25*time.Second, "Time in-flight transfers may take to finish after SIGTERM before they are aborted")
+	pollInterval := flags.Duration("poll-interval", 5*time.Second, "Wait time between polls of an empty queue")
+	payer := flags.String("request-payer", "", "Set to requester to read from requester-pays source buckets")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 || (*queueID == "") == (*sqsQueueURL == "") {
+		eventsUsage()
+		os.Exit(1)
+	}
+	_, _, remotePath, err := r2sync.ParseRemoteURL(flags.Arg(0))
+	if err != nil {
+		fmt.Println("Invalid source path: ", err)
+		fmt.Println()
+		eventsUsage()
+		os.Exit(1)
+	}
+
+	excludeHidden, err := r2sync.ParseHidden(*hidden)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var deletes *r2sync.DeleteBudget
+	if *maxDelete > 0 {
+		deletes = r2sync.NewDeleteBudget(int64(*maxDelete))
+	}
+
+	payerOpt, err := r2sync.RequestPayer(*payer)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var queue r2sync.EventQueue
+	if *queueID != "" {
+		token := os.Getenv("CLOUDFLARE_API_TOKEN")
+		if *accountID == "" || token == "" {
+			fmt.Println("--account-id (or CLOUDFLARE_ACCOUNT_ID) and CLOUDFLARE_API_TOKEN are required")
+			os.Exit(1)
+		}
+		queue = r2sync.NewCloudflareQueue(r2sync.NewCloudflareClient(*accountID, token), *queueID)
+	} else {
+		cfg, err := config.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			log.Fatal(err)
+		}
+		queue = r2sync.NewSQSQueue(sqs.NewFromConfig(cfg), *sqsQueueURL)
+	}
+
+	ctx, drain := handleInterrupts(*shutdownGrace)
+	client, err := r2sync.NewClient(ctx, r2sync.WithR2Env(), payerOpt, r2sync.WithRemoteURL(flags.Arg(0)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := client.SetDownloadTempDir(*tempDir); err != nil {
+		fmt.Println("Invalid --temp-dir: ", err)
+		os.Exit(1)
+	}
+	client.StrictKeys = *strictKeys
+	client.Heartbeat = heartbeat
+	startSystemd()
+	opts := r2sync.SyncOptions{
+		Delete:        *deleteSync,
+		DryRun:        *dryRun,
+		Concurrency:   *concurrency,
+		Filters:       filters,
+		ExcludeHidden: excludeHidden,
+		Drain:         drain,
+	}
+	err = client.SyncEvents(ctx, queue, remotePath, flags.Arg(1), opts, deletes, *pollInterval)
+	if err != nil && !errors.Is(err, r2sync.ErrInterrupted) && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+}
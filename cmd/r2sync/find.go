@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gofika/r2sync"
+)
+
+func findUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: r2sync find [filters] [--long] [--delete | --copy-to TARGET] [--dryrun] <source url>
+
+Lists the objects under the source prefix that match every filter, one url per line on stdout.
+With --delete or --copy-to the matches are deleted or copied server-side instead.
+
+Options:
+  --concurrency (number)
+    	Number of concurrent tag, metadata, delete and copy requests, default is 5
+  --copy-to (target url)
+    	Copy matching objects server-side to this prefix in the same account, keeping their path
+    	relative to the source prefix
+  --delete (boolean)
+    	Delete matching objects
+  --dryrun (boolean)
+    	Only display the operations to be performed, without actually executing them
+  --larger-than (size)
+    	Only objects larger than this, e.g. 1G
+  --long (boolean)
+    	Print size and last modified time before every url
+  --metadata (key=value)
+    	Only objects with this user metadata value, fetched with one HEAD request per object, can be
+    	used multiple times
+  --name (pattern)
+    	Only objects whose last path segment matches this glob, e.g. '*.log'
+  --newer-than (duration)
+    	Only objects modified less than this long ago, e.g. 24h
+  --older-than (duration)
+    	Only objects modified longer ago than this, e.g. 90d
+  --smaller-than (size)
+    	Only objects smaller than this, e.g. 1K
+  --tag (key=value)
+    	Only objects with this tag value, fetched with one request per object, can be used multiple times
+
+Examples:
+    r2sync find --name '*.log' --larger-than 1G --older-than 90d r2://bucket/logs/
+    r2sync find --older-than 30d --delete --dryrun r2://bucket/tmp/
+    r2sync find --tag build=1234 --delete s3://bucket/artifacts/
+    r2sync find --name '*.tar' --copy-to r2://archive-bucket/tars/ r2://bucket/backup/`)
+}
+
+func runFind(args []string) {
+	flags := flag.NewFlagSet("find", flag.ExitOnError)
+	flags.Usage = findUsage
+	name := flags.String("name", "", "Only objects whose last path segment matches this glob")
+	largerThan := flags.String("larger-than", "", "Only objects larger than this size")
+	smallerThan := flags.String("smaller-than", "", "Only objects smaller than this size")
+	olderThan := flags.String("older-than", "", "Only objects modified longer ago than this")
+	newerThan := flags.String("newer-than", "", "Only objects modified less than this long ago")
+	long := flags.Bool("long", false, "Print size and last modified time before every url")
+	deleteMatches := flags.Bool("delete", false, "Delete matching objects")
+	copyTo := flags.String("copy-to", "", "Copy matching objects server-side to this prefix")
+	dryRun := flags.Bool("dryrun", false, "Only display the operations to be performed, without actually executing them")
+	concurrency := flags.Int("concurrency", 5, "Number of concurrent tag, metadata, delete and copy requests")
+	var tags, metadata stringSliceFlag
+	flags.Var(&tags, "tag", "Only objects with this tag value (key=value), can be used multiple times")
+	flags.Var(&metadata, "metadata", "Only objects with this user metadata value (key=value), can be used multiple times")
+	positional := parseFlags(flags, args)
+
+	if len(positional) != 1 || *concurrency < 1 || (*deleteMatches && *copyTo != "") {
+		findUsage()
+		os.Exit(1)
+	}
+	filter := r2sync.FindFilter{Name: *name}
+	if _, err := path.Match(filter.Name, ""); err != nil {
+		fmt.Println("Invalid --name: ", err)
+		os.Exit(1)
+	}
+	var err error
+	if filter.Tags, err = r2sync.ParseKeyValues(tags); err != nil {
+		fmt.Println("Invalid --tag: ", err)
+		os.Exit(1)
+	}
+	// user metadata keys are returned lowercased
+	for i, value := range metadata {
+		if key, rest, ok := strings.Cut(value, "="); ok {
+			metadata[i] = strings.ToLower(key) + "=" + rest
+		}
+	}
+	if filter.Metadata, err = r2sync.ParseKeyValues(metadata); err != nil {
+		fmt.Println("Invalid --metadata: ", err)
+		os.Exit(1)
+	}
+	if *largerThan != "" {
+		if filter.LargerThan, err = r2sync.ParseSize(*largerThan); err != nil {
+			fmt.Println("Invalid --larger-than: ", err)
+			os.Exit(1)
+		}
+	}
+	if *smallerThan != "" {
+		if filter.SmallerThan, err = r2sync.ParseSize(*smallerThan); err != nil {
+			fmt.Println("Invalid --smaller-than: ", err)
+			os.Exit(1)
+		}
+	}
+	if *olderThan != "" {
+		if filter.OlderThan, err = r2sync.ParseDuration(*olderThan); err != nil {
+			fmt.Println("Invalid --older-than: ", err)
+			os.Exit(1)
+		}
+	}
+	if *newerThan != "" {
+		if filter.NewerThan, err = r2sync.ParseDuration(*newerThan); err != nil {
+			fmt.Println("Invalid --newer-than: ", err)
+			os.Exit(1)
+		}
+	}
+	scheme, bucket, prefix, err := r2sync.ParseRemoteURL(positional[0])
+	if err != nil {
+		fmt.Println("Invalid source path: ", err)
+		fmt.Println()
+		findUsage()
+		os.Exit(1)
+	}
+	var target *r2sync.R2Client
+	targetPrefix := ""
+	if *copyTo != "" {
+		var targetScheme string
+		targetScheme, _, targetPrefix, err = r2sync.ParseRemoteURL(*copyTo)
+		if err != nil || targetScheme != scheme {
+			fmt.Println("Invalid --copy-to: must be a url with the scheme of the source")
+			os.Exit(1)
+		}
+		if target, err = r2sync.NewClient(context.Background(), r2sync.WithR2Env(), r2sync.WithRemoteURL(*copyTo)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	client, err := r2sync.NewClient(ctx, r2sync.WithR2Env(), r2sync.WithRemoteURL(positional[0]))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if target != nil {
+		if err := target.CheckCopySource(client); err != nil {
+			log.Fatal(err)
+		}
+	}
+	matches, err := client.Find(ctx, prefix, filter, *concurrency)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*deleteMatches && target == nil {
+		for _, object := range matches {
+			if *long {
+				fmt.Printf("%12d  %s  %s\n", object.Size, object.LastModified.Local().Format("2006-01-02 15:04:05"), client.RemotePath(object.Path))
+			} else {
+				fmt.Println(client.RemotePath(object.Path))
+			}
+		}
+		return
+	}
+
+	if *deleteMatches {
+		// deleted with one request per 1000 keys
+		keys := make([]string, len(matches))
+		for i, object := range matches {
+			keys[i] = object.Path
+		}
+		failedKeys, _ := client.DeleteObjects(ctx, keys, *dryRun)
+		for _, key := range keys {
+			if err := failedKeys[key]; err != nil {
+				log.Printf("failed %s: %s\n", client.RemotePath(key), r2sync.ErrorDetail(err))
+			}
+		}
+		log.Printf("%d of %d matching objects deleted.\n", len(matches)-len(failedKeys), len(matches))
+		if len(failedKeys) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	semaphore := make(chan struct{}, *concurrency)
+	for _, object := range matches {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(key string, size int64) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			err := target.CopyObject(ctx, bucket, key, path.Join(targetPrefix, r2sync.RelativeKey(key, prefix)), size, *dryRun)
+			if err != nil {
+				log.Printf("failed %s: %s\n", client.RemotePath(key), r2sync.ErrorDetail(err))
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}(object.Path, object.Size)
+	}
+	wg.Wait()
+	log.Printf("%d of %d matching objects copied.\n", len(matches)-failed, len(matches))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+
+	"github.com/gofika/r2sync"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// grpcControl implements the r2sync.v1.Control service described in control.proto
+type grpcControl struct {
+	state *daemonState
+	token string
+}
+
+func (c *grpcControl) authorize(ctx context.Context) error {
+	if c.token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if value == "Bearer "+c.token {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+func (c *grpcControl) Start(ctx context.Context) (proto.Message, error) {
+	select {
+	case c.state.trigger <- struct{}{}:
+	default:
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (c *grpcControl) Cancel(ctx context.Context) (proto.Message, error) {
+	running := c.state.Cancel()
+	if running {
+		log.Println("Sync cancelled via control API.")
+	}
+	return wrapperspb.Bool(running), nil
+}
+
+func (c *grpcControl) WatchProgress(stream grpc.ServerStream) error {
+	if err := c.authorize(stream.Context()); err != nil {
+		return err
+	}
+	if err := stream.RecvMsg(&emptypb.Empty{}); err != nil {
+		return err
+	}
+	events, unsubscribe := c.state.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events:
+			message, err := progressStruct(event)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.SendMsg(message); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// progressStruct converts an event to a Struct with its JSON field names
+func progressStruct(event r2sync.ProgressEvent) (*structpb.Struct, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(fields)
+}
+
+// unaryMethod adapts a method taking google.protobuf.Empty to a grpc method handler
+func unaryMethod(name string, call func(c *grpcControl, ctx context.Context) (proto.Message, error)) grpc.MethodDesc {
+	handler := func(ctx context.Context, srv any) (any, error) {
+		c := srv.(*grpcControl)
+		if err := c.authorize(ctx); err != nil {
+			return nil, err
+		}
+		return call(c, ctx)
+	}
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			in := &emptypb.Empty{}
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return handler(ctx, srv)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/r2sync.v1.Control/" + name}
+			return interceptor(ctx, in, info, func(ctx context.Context, _ any) (any, error) {
+				return handler(ctx, srv)
+			})
+		},
+	}
+}
+
+var controlServiceDesc = grpc.ServiceDesc{
+	ServiceName: "r2sync.v1.Control",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("Start", (*grpcControl).Start),
+		unaryMethod("Cancel", (*grpcControl).Cancel),
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName: "WatchProgress",
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			return srv.(*grpcControl).WatchProgress(stream)
+		},
+		ServerStreams: true,
+	}},
+	Metadata: "control.proto",
+}
+
+// serveGRPC starts the gRPC control service on addr in the background
+func serveGRPC(addr, token string, state *daemonState) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&controlServiceDesc, &grpcControl{state: state, token: token})
+	go func() {
+		log.Printf("gRPC control service listening on %s\n", addr)
+		if err := server.Serve(listener); err != nil {
+			log.Printf("grpc control service failed: %v\n", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gofika/r2sync"
+)
+
+func lifecycleUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: r2sync lifecycle get <bucket url> [output file]
+       r2sync lifecycle put [--dryrun] <bucket url> <config file>
+
+Config files use the aws cli JSON format, or YAML when the file ends with .yaml/.yml.
+Putting a config without rules removes the bucket lifecycle configuration.
+
+Options:
+  --dryrun (boolean)
+    	Only display the operations to be performed, without actually executing them
+
+Examples:
+    r2sync lifecycle get r2://bucket
+    r2sync lifecycle get r2://bucket lifecycle.yaml
+    r2sync lifecycle put r2://bucket lifecycle.json`)
+}
+
+func runLifecycle(args []string) {
+	command := &bucketConfigCommand{
+		name:  "lifecycle",
+		usage: lifecycleUsage,
+		get: func(ctx context.Context, client *r2sync.R2Client) (any, error) {
+			return client.GetLifecycle(ctx)
+		},
+		put: func(ctx context.Context, client *r2sync.R2Client, name string, dryRun bool) error {
+			var lifecycle types.BucketLifecycleConfiguration
+			if err := readConfigFile(name, &lifecycle); err != nil {
+				return err
+			}
+			return client.PutLifecycle(ctx, &lifecycle, dryRun)
+		},
+	}
+	command.run(args)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/gofika/r2sync"
+)
+
+func lsUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: r2sync ls [--long] <url>
+       r2sync ls [--long] (--last-run | --run ID) [url]
+
+Lists the objects under the url, one url per line on stdout. With --last-run or --run, lists what a
+run of r2sync on this machine uploaded, deleted or downloaded instead, from the run record saved
+when it finished. Every run logs its id, which uploads also record in their r2sync-run metadata.
+
+Options:
+  --last-run (boolean)
+    	List the changes of the newest run, of the newest run from or to the url when one is given
+  --long (boolean)
+    	Print size and last modified time before every url, or the size of every upload and download
+  --run (id)
+    	List the changes of this run, e.g. 20261016T170307.482Z-3fa9c1
+
+Examples:
+    r2sync ls r2://bucket/site/
+    r2sync ls --last-run r2://bucket/site/
+    r2sync ls --run 20261016T170307.482Z-3fa9c1
+    r2sync find --metadata r2sync-run=20261016T170307.482Z-3fa9c1 r2://bucket/site/`)
+}
+
+func runLs(args []string) {
+	flags := flag.NewFlagSet("ls", flag.ExitOnError)
+	flags.Usage = lsUsage
+	long := flags.Bool("long", false, "Print size and last modified time before every url")
+	lastRun := flags.Bool("last-run", false, "List the changes of the newest run")
+	runID := flags.String("run", "", "List the changes of this run")
+	positional := parseFlags(flags, args)
+
+	if len(positional) > 1 || (*lastRun && *runID != "") {
+		lsUsage()
+		os.Exit(1)
+	}
+	url := ""
+	if len(positional) == 1 {
+		url = positional[0]
+	}
+	if *lastRun || *runID != "" {
+		var record *r2sync.RunRecord
+		var err error
+		if *lastRun {
+			record, err = r2sync.LastRunRecord(r2sync.DefaultRunDir(), url)
+		} else {
+			record, err = r2sync.LoadRunRecord(r2sync.DefaultRunDir(), *runID)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		printRun(record, *long)
+		return
+	}
+
+	if url == "" {
+		lsUsage()
+		os.Exit(1)
+	}
+	_, _, prefix, err := r2sync.ParseRemoteURL(url)
+	if err != nil {
+		fmt.Println("Invalid url: ", err)
+		fmt.Println()
+		lsUsage()
+		os.Exit(1)
+	}
+	client, err := r2sync.NewClient(context.Background(), r2sync.WithR2Env(), r2sync.WithRemoteURL(url))
+	if err != nil {
+		log.Fatal(err)
+	}
+	objects, err := client.ListObjects(context.Background(), prefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if *long {
+			object := objects[key]
+			fmt.Printf("%12d  %s  %s\n", object.Size, object.LastModified.Local().Format("2006-01-02 15:04:05"), client.RemotePath(key))
+		} else {
+			fmt.Println(client.RemotePath(key))
+		}
+	}
+}
+
+// printRun prints the changes of a run sorted by the object or file they changed, and a summary on
+// stderr
+func printRun(record *r2sync.RunRecord, long bool) {
+	log.Printf("Run %s: %s -> %s, %s to %s, %d changes\n", record.ID, record.Source, record.Target,
+		record.Start.Local().Format("2006-01-02 15:04:05"), record.End.Local().Format("15:04:05"), len(record.Changes))
+	changed := func(change r2sync.RunChange) string {
+		if change.Target != "" {
+			return change.Target
+		}
+		return change.Path
+	}
+	sort.SliceStable(record.Changes, func(i, j int) bool { return changed(record.Changes[i]) < changed(record.Changes[j]) })
+	for _, change := range record.Changes {
+		line := fmt.Sprintf("%-8s  %s", change.Type, changed(change))
+		if change.Type != "delete" {
+			line = fmt.Sprintf("%-8s  %s -> %s", change.Type, change.Path, change.Target)
+		}
+		if long {
+			size := ""
+			if change.Type != "delete" {
+				size = fmt.Sprint(change.Size)
+			}
+			line = fmt.Sprintf("%12s  %s", size, line)
+		}
+		fmt.Println(line)
+	}
+}
@@ -2,374 +2,527 @@ package main
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"mime"
 	"net/url"
 	"os"
 	"path"
-	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/gofika/fikamime"
+	"github.com/gofika/r2sync"
 )
 
-type R2Client struct {
-	client *s3.Client
-	bucket string
-	scheme string
-}
-
-type FileInfo struct {
-	Path         string
-	Size         int64
-	LastModified time.Time
-	ETag         string
-}
-
-func NewR2Client(bucket, scheme string) *R2Client {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		log.Fatal(err)
+// rsyncTarget returns the target path a source directory is synced to with rsync semantics: without
+// a trailing slash the directory itself is created under the target path, with one only its
+// contents are synced
+func rsyncTarget(sourcePath, targetPath string) string {
+	name := path.Base(sourcePath)
+	if strings.HasSuffix(sourcePath, "/") || name == "." || name == ".." || name == "/" {
+		return targetPath
 	}
-
-	return &R2Client{
-		client: s3.NewFromConfig(cfg),
-		bucket: bucket,
-		scheme: scheme,
+	if info, err := os.Stat(sourcePath); err != nil || !info.IsDir() {
+		return targetPath
 	}
-}
-
-func (r *R2Client) RemotePath(path string) string {
-	return fmt.Sprintf("%s://%s/%s", r.scheme, r.bucket, path)
-}
-
-// List remote files
-func (r *R2Client) ListObjects(prefix string) (map[string]FileInfo, error) {
-	result := make(map[string]FileInfo)
-	var continuationToken *string
-
-	for {
-		input := &s3.ListObjectsV2Input{
-			Bucket: aws.String(r.bucket),
-			Prefix: aws.String(prefix),
-		}
-		if continuationToken != nil {
-			input.ContinuationToken = continuationToken
-		}
-
-		resp, err := r.client.ListObjectsV2(context.TODO(), input)
-		if err != nil {
-			return nil, err
-		}
-
-		for _, obj := range resp.Contents {
-			result[*obj.Key] = FileInfo{
-				Path:         *obj.Key,
-				Size:         *obj.Size,
-				LastModified: *obj.LastModified,
-				ETag:         *obj.ETag,
-			}
-		}
-
-		if !*resp.IsTruncated {
-			break
-		}
-		continuationToken = resp.NextContinuationToken
+	if targetPath == "" || strings.HasSuffix(targetPath, "/") {
+		return targetPath + name + "/"
 	}
-
-	return result, nil
+	return targetPath + "/" + name + "/"
 }
 
-// format speed display
-func formatSpeed(bytesPerSecond float64) string {
-	units := []string{"B/s", "KB/s", "MB/s", "GB/s", "TB/s"}
-	unit := 0
-	speed := bytesPerSecond
-
-	for speed >= 1024 && unit < len(units)-1 {
-		speed /= 1024
-		unit++
-	}
+type stringSliceFlag []string
 
-	return fmt.Sprintf("%.2f %s", speed, units[unit])
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
 }
 
-func formatSize(size int64) string {
-	units := []string{"B", "KB", "MB", "GB", "TB"}
-	unit := 0
-	bytes := float64(size)
-
-	for bytes >= 1024 && unit < len(units)-1 {
-		bytes /= 1024
-		unit++
-	}
-
-	return fmt.Sprintf("%.2f %s", bytes, units[unit])
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
-func (r *R2Client) UploadFile(localPath, remotePath string, dryRun bool) error {
-	if dryRun {
-		log.Printf("(dryrun) upload: %s -> %s\n", localPath, r.RemotePath(remotePath))
-		return nil
-	}
-
-	file, err := os.Open(localPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return err
-	}
-
-	startTime := time.Now()
-
-	// Guess MIME type based on file extension
-	ext := path.Ext(localPath)
-	contentType := mime.TypeByExtension(ext)
-	if contentType == "" {
-		contentType = fikamime.TypeByExtension(ext)
-		if contentType == "" {
-			contentType = "application/octet-stream" // Default type
-		}
-	}
-
-	_, err = r.client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:        aws.String(r.bucket),
-		Key:           aws.String(remotePath),
-		Body:          file,
-		ContentLength: aws.Int64(fileInfo.Size()),
-		ContentType:   aws.String(contentType),
-	})
-
-	if err != nil {
-		return err
-	}
-
-	elapsedTime := time.Since(startTime).Seconds()
-	bytesPerSecond := float64(fileInfo.Size()) / elapsedTime
-	speedStr := formatSpeed(bytesPerSecond)
-	sizeStr := formatSize(fileInfo.Size())
-	log.Printf("upload: %s -> %s, size: %s, average speed: %s\n", localPath, r.RemotePath(remotePath), sizeStr, speedStr)
-
-	return nil
+// filterFlag appends --exclude or --include patterns to a shared list so their order is kept
+type filterFlag struct {
+	filters *[]r2sync.Filter
+	include bool
 }
 
-func (r *R2Client) DeleteObject(remotePath string, dryRun bool) error {
-	if dryRun {
-		log.Printf("(dryrun) delete: %s\n", r.RemotePath(remotePath))
-		return nil
-	}
+func (f filterFlag) String() string {
+	return ""
+}
 
-	_, err := r.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(r.bucket),
-		Key:    aws.String(remotePath),
-	})
-	if err != nil {
-		return err
-	}
-	log.Printf("delete: %s\n", r.RemotePath(remotePath))
+func (f filterFlag) Set(value string) error {
+	*f.filters = append(*f.filters, r2sync.Filter{Pattern: r2sync.NormalizePath(value), Include: f.include})
 	return nil
 }
 
-func normalizePath(path string) string {
-	return strings.ReplaceAll(path, "\\", "/")
+// headerRuleFlag appends pattern=value rules of a content header to a shared list so their order is
+// kept
+type headerRuleFlag struct {
+	rules  *[]r2sync.HeaderRule
+	header string
 }
 
-func shouldExclude(fullpath string, excludePatterns []string) bool {
-	for _, pattern := range excludePatterns {
-		matched, err := path.Match(pattern, fullpath)
-		if err == nil && matched {
-			return true
-		}
-		// check any part of the path
-		parts := strings.Split(fullpath, "/")
-		for _, part := range parts {
-			matched, err := path.Match(pattern, part)
-			if err == nil && matched {
-				return true
-			}
-		}
-	}
-	return false
+func (f headerRuleFlag) String() string {
+	return ""
 }
 
-func calcETag(path string) (etag string, err error) {
-	file, err := os.Open(path)
+func (f headerRuleFlag) Set(value string) error {
+	rule, err := r2sync.ParseHeaderRule(f.header, value)
 	if err != nil {
-		return
-	}
-	defer file.Close()
-	hash := md5.New()
-	if _, err = io.Copy(hash, file); err != nil {
-		return
+		return err
 	}
-	etag = "\"" + hex.EncodeToString(hash.Sum(nil)) + "\""
-	return etag, nil
+	*f.rules = append(*f.rules, rule)
+	return nil
 }
 
-func (r *R2Client) Sync(localPath, remotePath string, deleteSync bool, dryRun bool, recursive bool, concurrency int, sizeOnly bool, excludePatterns stringSliceFlag) error {
-	log.Printf("Getting remote file list: %s ...\n", remotePath)
-	remoteFiles, err := r.ListObjects(remotePath)
-	if err != nil {
-		return fmt.Errorf("failed to get remote file list: %v", err)
-	}
-
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, concurrency)
-	uploadCount := 0
-	err = filepath.Walk(localPath, func(fullpath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		fullpath = normalizePath(fullpath)
-		if shouldExclude(fullpath, excludePatterns) {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		if !recursive && path.Dir(fullpath) != localPath {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-		if info.IsDir() {
-			return nil
-		}
-
-		relPath, _ := filepath.Rel(localPath, fullpath)
-		relPath = normalizePath(relPath)
-		remoteKey := path.Join(remotePath, relPath)
-
-		needUpload := false
-		if remoteInfo, exists := remoteFiles[remoteKey]; !exists {
-			needUpload = true
-		} else {
-			if sizeOnly {
-				needUpload = info.Size() != remoteInfo.Size
-			} else {
-				etag, err := calcETag(fullpath)
-				if err != nil {
-					return err
-				}
-				needUpload = info.Size() != remoteInfo.Size || etag != remoteInfo.ETag
-			}
-		}
-		if needUpload {
-			wg.Add(1)
-			uploadCount++
-
-			semaphore <- struct{}{}
-			go func(localPath, remoteKey string) {
-				defer wg.Done()
-				defer func() { <-semaphore }()
-
-				fullKey := r.RemotePath(remoteKey)
-				log.Printf("uploading %s -> %s ...\n", localPath, fullKey)
-				if err := r.UploadFile(localPath, remoteKey, dryRun); err != nil {
-					log.Printf("upload failed %s: %v\n", fullKey, err)
-				}
-			}(fullpath, remoteKey)
-		}
-
-		delete(remoteFiles, remoteKey)
-
-		return nil
-	})
-
-	if err != nil {
-		return fmt.Errorf("upload failed: %v", err)
-	}
-
-	wg.Wait()
-	log.Printf("%d files uploaded.\n", uploadCount)
-
-	if deleteSync && len(remoteFiles) > 0 {
-		log.Printf("Starting file deletion...\n")
-		deleteCount := 0
-
-		for remoteKey := range remoteFiles {
-			wg.Add(1)
-			deleteCount++
-			semaphore <- struct{}{}
-
-			go func(key string) {
-				defer wg.Done()
-				defer func() { <-semaphore }()
-				fullKey := r.RemotePath(key)
-				log.Printf("deleting %s ...\n", fullKey)
-				if err := r.DeleteObject(key, dryRun); err != nil {
-					log.Printf("delete failed %s: %v\n", fullKey, err)
-				}
-			}(remoteKey)
+// parseFlags parses flags that may appear before or after the positional arguments
+func parseFlags(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
 		}
-
-		wg.Wait()
-		log.Printf("%d files deleted.\n", deleteCount)
+		positional = append(positional, args[0])
+		args = args[1:]
 	}
-
-	log.Println("Sync completed.")
-	return nil
-}
-
-type stringSliceFlag []string
-
-func (s *stringSliceFlag) String() string {
-	return strings.Join(*s, ",")
-}
-
-func (s *stringSliceFlag) Set(value string) error {
-	*s = append(*s, value)
-	return nil
 }
 
 func usage() {
-	fmt.Fprintln(os.Stderr, `Usage: r2sync [--dryrun] [--delete] [--recursive] [--concurrency N] [[--exclude PATTERN] ...] [--size-only] <source path> <target path>
+	fmt.Fprintln(os.Stderr, `Usage: r2sync [--dryrun] [--delete] [--recursive] [--concurrency N] [[--exclude PATTERN] ...] [--size-only] [--retention-mode MODE --retention-period DURATION] [--legal-hold on|off] [--schedule CRON] [[--also TARGET] ...] [[--replicate-to TARGET] ...] [--max-runtime DURATION] <source path> <target path>
+       r2sync replicate [options] <source url> <target url>
+       r2sync lifecycle get|put [options] <bucket url> [config file]
+       r2sync cors get|put [options] <bucket url> [config file]
+       r2sync policy get|put [options] <bucket url> [policy file]
+       r2sync public get|on|off [options] <bucket url>
+       r2sync events (--queue-id ID | --sqs-queue-url URL) [options] <source url> <local path>
+       r2sync abort-multipart [--older-than DURATION] [--dryrun] <target url>
+       r2sync unpack [--dryrun] <source url> <local path>
+       r2sync find [filters] [--delete | --copy-to TARGET] [--dryrun] <source url>
+       r2sync audit [--sample N|P%] [--range-size SIZE] <source url> [local path]
+       r2sync usage [--depth N] [--compare FILE] [--save FILE] <bucket url>
+       r2sync ls [--long] [--last-run | --run ID] <url>
+
 Options:
+  --abort-multipart (duration)
+    	Abort incomplete multipart uploads under the target prefix initiated longer ago than this before
+    	syncing, so crashed runs never leave junk behind, e.g. 24h
+  --accelerate (boolean)
+    	Use the S3 Transfer Acceleration endpoint for s3:// paths, the bucket must have acceleration enabled
+  --account-id (string)
+    	Cloudflare account id, r2:// paths use the R2 endpoint of the account instead of AWS_ENDPOINT_URL,
+    	defaults to the R2_ACCOUNT_ID environment variable
+  --allow-empty-source (boolean)
+    	Allow --delete when the source has no files, which otherwise is refused because it usually means
+    	a wrong path or an unmounted volume
+  --allow-root (boolean)
+    	Allow --delete when a target path has no key prefix, which deletes from the whole bucket and
+    	otherwise has to be confirmed on the terminal or is refused
+  --also (target path)
+    	Additional target path that receives the same uploads concurrently, can be used multiple times
+  --append-only (boolean)
+    	For sources whose files are only ever added, like camera rolls and log archives: skip listing
+    	the target and only upload files modified after the newest file of the last run, see --high-water
+  --breaker-threshold (ratio)
+    	Failure ratio of the last 50 requests that trips a circuit breaker, which pauses transfers and
+    	probes the bucket every 30s until it recovers, 0 disables, default is 0.5
+  --bypass-governance (boolean)
+    	Delete objects under governance-mode retention (requires s3:BypassGovernanceRetention)
+  --cache-control (pattern=value)
+    	Cache-Control of uploaded objects whose key, or its end after any slash, matches the pattern,
+    	e.g. '*.html=no-cache', can be used multiple times and the last matching rule decides, an
+    	empty value sets none. Also applies to server-side copies, unchanged objects keep their old
+    	headers
+  --checkpoint (file)
+    	Checkpoint file written when the sync stops early, defaults to a file in the user cache
+    	directory. With --delete, deletes are journaled to this path plus .deletes, so a run resumed
+    	after a crash doesn't delete keys re-created in between
+  --chunk-size (size)
+    	Upload files larger than this as chunk objects of this size plus a manifest at the file key, for
+    	files beyond the object size limit and resumable uploads, downloads reassemble them, e.g. 1G
+  --clock-skew (duration)
+    	With --update, how much newer a file must be than the other side to be transferred, for local
+    	clocks that run ahead of the backend, default is 0
+  --compress (zstd|gzip)
+    	Compress file contents before upload, recording the original size and md5 in object metadata,
+    	downloads decompress transparently. Unchanged files are detected with one HEAD request each
   --concurrency (number)
-    	Number of concurrent upload/delete operations, default is 5
+    	Number of concurrent upload/delete operations, by default derived from the CPU count and the
+    	file sizes, between 2 and 64
+  --content-disposition (pattern=value)
+    	Content-Disposition of uploaded objects whose key matches the pattern, like --cache-control,
+    	e.g. 'downloads/*=attachment'
+  --content-language (pattern=value)
+    	Content-Language of uploaded objects whose key matches the pattern, like --cache-control,
+    	e.g. 'de/*=de-DE'
+  --control-token (string)
+    	Bearer token required by the control API and gRPC service, defaults to the R2SYNC_CONTROL_TOKEN
+    	environment variable
+  --credentials-cmd (command)
+    	Shell command printing temporary credentials as credential_process JSON on stdout, run again when
+    	they expire, e.g. for vaults and secret managers
+  --debug-http (boolean)
+    	Log method, url and headers of every signed request and status and headers of its response, with
+    	credentials and signatures redacted
   --delete (boolean)
     	Delete files that exist in the target location but not in the source location
+  --deterministic (boolean)
+    	Run transfers one at a time in sorted order and log without timestamps and timings, so two
+    	identical runs produce byte-identical logs for diff-based review
+  --dir-manifests (boolean)
+    	Store a rollup of every directory under .r2sync-dirs/ after a successful sync and skip listing and
+    	comparing subtrees whose rollup is unchanged, the target must only be written by r2sync
+  --dns (address)
+    	DNS resolver used for endpoints instead of the system resolver, e.g. 1.1.1.1
+  --dns-cache-ttl (duration)
+    	How long resolved endpoint addresses are reused, stale addresses are used when a lookup fails,
+    	0 disables caching, default is 10m
   --dryrun (boolean)
     	Only display the operations to be performed, without actually executing them
+  --dual-stack (boolean)
+    	Use the dual-stack IPv4/IPv6 endpoint for s3:// paths
+  --endpoint-url (url)
+    	S3 API endpoint of every path, e.g. https://<account id>.r2.cloudflarestorage.com or a MinIO
+    	server, defaults to the R2SYNC_ENDPOINT_URL environment variable, else AWS_ENDPOINT_URL
   --exclude (pattern)
-    	Exclude file or directory patterns, can be used multiple times
+    	Exclude file or directory patterns, can be used multiple times, see --include
+  --exclude-mime (pattern)
+    	Skip files whose MIME type, detected from their contents and else their extension, matches
+    	this pattern, e.g. 'video/*', can be used multiple times
+  --existing (boolean)
+    	Only update files that already exist on the target, skip files that would be added
+  --expect-plan (hash|empty)
+    	Exit with status 4 when the hash of the planned operations differs from this value, "empty"
+    	expects no operations. Without --dryrun a dry run checks the plan first, so nothing changes
+    	when it drifted
+  --force-path-style (boolean)
+    	Address buckets in the path instead of the host name, as MinIO and other self-hosted backends
+    	require, defaults to the R2SYNC_FORCE_PATH_STYLE environment variable
+  --grpc-addr (address)
+    	Serve the gRPC control service (control.proto) on this address in --schedule mode, e.g. 127.0.0.1:9090
+  --hidden (include|exclude)
+    	Whether dotfiles and dot-directories like .git, .DS_Store and .cache are synced, default is include
+  --high-water (file)
+    	File recording the newest synced file for --append-only, defaults to a file in the user cache
+    	directory, delete it to upload every file again
+  --http-addr (address)
+    	Serve the HTTP control API and web dashboard on this address in --schedule mode, e.g. 127.0.0.1:8080
+  --ignore-existing (boolean)
+    	Only add files missing on the target, skip files that exist there even if they differ
+  --include (pattern)
+    	Sync files matching this pattern even if an earlier --exclude matches them. --exclude and
+    	--include are evaluated in the order given and the last matching one wins, e.g.
+    	--exclude '*' --include '*.html' only syncs HTML files
+  --include-mime (pattern)
+    	Only sync files whose MIME type, detected from their contents and else their extension, matches
+    	this pattern, e.g. 'image/*', can be used multiple times
+  --inventory (remote path)
+    	Read the remote state from an S3 Inventory manifest.json or a CSV listing object in the Bucket,
+    	Key, Size, LastModifiedDate, ETag schema instead of listing the target, e.g.
+    	s3://inventory/bucket/daily/2024-01-01T01-00Z/manifest.json
+  --io-concurrency (number)
+    	Number of concurrent local file reads for hashing and uploads, independent of --concurrency, for
+    	spinning disks and network mounts that thrash under parallel reads, 0 doesn't limit, default is 0
+  --ip-version (4|6)
+    	Only connect over IPv4 or IPv6, e.g. 6 on IPv6-only hosts
+  --jurisdiction (eu|fedramp)
+    	With --account-id, use the endpoint of buckets created with a data residency jurisdiction
+  --legal-hold (on|off)
+    	Place or clear an Object Lock legal hold on uploaded objects
+  --listing-cache-ttl (duration)
+    	Reuse the remote file list of the last run for this long instead of listing the target again,
+    	for repeated syncs in a dev loop. A run that uploads or deletes anything drops it, changes by
+    	other writers are missed until it expires, 0 disables, default is 0
+  --max-delete (number)
+    	With --delete, delete nothing and exit with status 1 when more than this many objects, or local
+    	files of a pull, would be deleted, 0 is unlimited
+  --max-requests-per-second (number)
+    	Limit requests to the backend, including lists, heads, deletes and retries, across all targets,
+    	0 disables
+  --max-runtime (duration)
+    	Stop scheduling new transfers after this time budget, finish in-flight ones, save a checkpoint and
+    	exit with status 3, e.g. 6h
+  --mfa-serial (serial)
+    	MFA device serial number or ARN. Profiles with role_arn assume the role with it, other
+    	profiles get temporary session credentials
+  --mfa-token (code)
+    	MFA token code, prompted for on stdin when required and not given
+  --no-progress (boolean)
+    	Don't draw progress bars with the total bytes, speed and ETA and the running transfers, which
+    	are shown when stderr is an interactive terminal, e.g. in CI logs captured through a pty
+  --profile (name)
+    	Shared config profile used for credentials, including SSO profiles after aws sso login
+  --pack (boolean)
+    	Bundle files up to --pack-threshold into tar objects under .r2sync-pack/ with an index, instead
+    	of uploading them one by one, use r2sync unpack to restore them
+  --pack-bundle-size (size)
+    	Size at which a bundle is closed and a new one started, default is 64M
+  --pack-format (tar|tar.zst)
+    	Bundle format, tar.zst compresses bundles with zstd, default is tar
+  --pack-threshold (size)
+    	Files up to this size are packed with --pack, default is 256K
+  --part-size (size)
+    	Part size of multipart uploads by other tools, whose ETags are the MD5 of the part MD5s, tried
+    	before the defaults of common tools (8M, 5M, 16M, 15M, 64M and 100M) when comparing, e.g. 32M
+  --prewarm (number)
+    	Open this many TLS connections to every target before the first transfer, so a burst of small
+    	uploads doesn't wait behind handshakes, usually the --concurrency value
+  --prefix-map (path)
+    	File of "local/dir/ -> bucket/prefix/" lines routing subdirectories of the source to their own
+    	target in the same run, targets without a scheme use the scheme of the target path
+  --pricing (rates)
+    	Rates of the cost estimate printed by --dryrun: a model, r2 or s3, and overrides of class-a and
+    	class-b (USD per million requests), egress (per GB) and storage (per GB-month), e.g.
+    	r2,storage=0.02, defaults to the model of the target scheme
+  --probe-bandwidth (boolean)
+    	Without --concurrency, upload and delete an 8 MB probe object first and use fewer concurrent
+    	transfers on slow links
+  --progress-socket (path)
+    	Stream JSON progress events to clients connected to a unix socket at this path
+  --rclone-metadata (boolean)
+    	Store the md5 and modification time of uploaded files in the md5chksum and mtime metadata keys
+    	rclone reads, so rclone doesn't touch objects uploaded by r2sync. The md5chksum of rclone
+    	multipart uploads is always compared instead of their ETag
   --recursive (boolean)
     	Recursively synchronize subdirectories
+  --refresh-remote (boolean)
+    	List the target even when --listing-cache-ttl has a fresh remote file list, and cache the result
+  --region (region)
+    	Region requests are signed for, defaults to the R2SYNC_REGION environment variable, else
+    	AWS_REGION, and auto with --endpoint-url or --account-id
+  --replicate-to (target path)
+    	Secondary target path in the same account that receives server-side copies of uploads and deletes
+    	from background workers, can be used multiple times
+  --retention-mode (governance|compliance)
+    	Object Lock retention mode applied to uploaded objects
+  --retention-period (duration)
+    	Object Lock retention period applied to uploaded objects, e.g. 30d or 72h
+  --retry-base-delay (duration)
+    	Delay before the first retry, doubled on every further retry with full jitter, default is 1s
+  --retry-max-attempts (number)
+    	Attempts per request including the first, 1 disables retries, default is 3. Uploads failing
+    	every attempt with an error class of --retry-on run once more after all other transfers
+  --retry-max-delay (duration)
+    	Upper bound of the delay between retries, default is 20s
+  --retry-on (classes)
+    	Comma separated error classes that are retried: throttling, access-denied, network, checksum,
+    	not-found, server, other, default is throttling,network,server
+  --rsync-paths (boolean)
+    	Like rsync, a source directory without a trailing slash is synced into a directory of the same
+    	name under the target path, with a trailing slash only its contents are synced
+  --schedule (cron expression)
+    	Keep running and sync on a cron schedule, e.g. '*/15 * * * *'
+  --schedule-jitter (duration)
+    	Random delay added to each scheduled sync, e.g. 30s
+  --shards (number)
+    	Collect the uploads first, split them into this many contiguous key ranges and interleave the
+    	ranges across workers, so thousands of PUTs don't hit one narrow key range, e.g. 16
+  --shutdown-grace (duration)
+    	Time in-flight transfers may take to finish after SIGTERM before they are aborted, default is 25s
   --size-only (boolean)
     	Only use file size to determine if files are the same
+  --small-object-threshold (size)
+    	Files up to this size are read into pooled memory buffers and sent without Expect: 100-continue,
+    	since per-request overhead dominates for tiny files, 0 disables, default is 1M
+  --stall-timeout (duration)
+    	Abort a transfer that moved no bytes for this long and re-queue it with backoff, up to 3 times,
+    	0 disables, default is 1m
+  --strip-components (number)
+    	Remove this many leading directories from relative paths before they become keys, files nested
+    	less deeply are skipped
+  --temp-dir (path)
+    	Directory for partial downloads when the source is remote, renamed into place when complete,
+    	default is next to the destination file
+  --transfer-window (HH:MM-HH:MM)
+    	Only start transfers within these hours of local time, pausing at the end of the window and
+    	resuming at its start, may wrap around midnight, e.g. 01:00-06:00
+  --transform (s/regex/replacement/[g])
+    	Rename relative paths with a sed-style substitution before they become keys, applied after
+    	--strip-components, can be used multiple times and applied in order
+  --tree-manifest (boolean)
+    	Compare against a Merkle tree manifest of the target stored as one object instead of listing it,
+    	only changed directories are compared, the target must only be written by r2sync
+  --update (boolean)
+    	Compare modification times instead of sizes and checksums, like rsync --update: a file is only
+    	uploaded when it is newer than the LastModified of its object, and pulls only download objects
+    	newer than the local file, so no file is hashed. Files restored with an old modification time
+    	are not transferred
+  --verbose (boolean)
+    	Log why each file was skipped: excluded, hidden, excluded by MIME type, size or md5 match or
+    	directory unchanged
+  --verify-listing (boolean)
+    	Re-list the target after the uploads and fail before the delete phase when an uploaded key is
+    	missing or has an unexpected size
+  --watch (boolean)
+    	Keep running after the sync and push changes of the local tree as they happen: changed files
+    	are uploaded, created directories synced and, with --delete, the objects of removed files
+    	deleted. Stops on Ctrl-C
+  --watch-debounce (duration)
+    	Time without further changes before they are pushed with --watch, a file changing continuously
+    	is pushed after at most ten times this (default 2s)
 
 Examples:
     r2sync /local/dir r2://bucket/path/
     r2sync --delete --dryrun /local/dir r2://bucket/path/
     r2sync --recursive --delete --dryrun /local/dir r2://bucket/path/
     r2sync --recursive --delete --dryrun --concurrency 10 /local/dir r2://bucket/path/
-    r2sync --exclude '*.tmp' --exclude '/local/dir/exclude1' --recursive --delete --dryrun /local/dir r2://bucket/path/`)
+    r2sync --exclude '*.tmp' --exclude '/local/dir/exclude1' --recursive --delete --dryrun /local/dir r2://bucket/path/
+    r2sync --recursive --also r2://backup-bucket/path/ /local/dir r2://bucket/path/
+    r2sync --schedule '*/15 * * * *' --schedule-jitter 30s --recursive /local/dir r2://bucket/path/
+    r2sync --recursive --delete r2://bucket/path/ /local/dir
+    r2sync --delete r2://staging-bucket/site/ r2://bucket/site/`)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replicate":
+			runReplicate(os.Args[2:])
+			return
+		case "lifecycle":
+			runLifecycle(os.Args[2:])
+			return
+		case "cors":
+			runCORS(os.Args[2:])
+			return
+		case "policy":
+			runPolicy(os.Args[2:])
+			return
+		case "public":
+			runPublic(os.Args[2:])
+			return
+		case "events":
+			runEvents(os.Args[2:])
+			return
+		case "find":
+			runFind(os.Args[2:])
+			return
+		case "audit":
+			runAudit(os.Args[2:])
+			return
+		case "unpack":
+			runUnpack(os.Args[2:])
+			return
+		case "abort-multipart":
+			runAbortMultipart(os.Args[2:])
+			return
+		case "usage":
+			runUsage(os.Args[2:])
+			return
+		case "ls":
+			runLs(os.Args[2:])
+			return
+		}
+	}
+
 	dryRun := flag.Bool("dryrun", false, "Only display the operations to be performed, without actually executing them")
 	delete := flag.Bool("delete", false, "Delete files that exist in the target location but not in the source location")
 	recursive := flag.Bool("recursive", false, "Recursively synchronize subdirectories")
-	concurrency := flag.Int("concurrency", 5, "Number of concurrent upload/delete operations")
+	verbose := flag.Bool("verbose", false, "Log why each file was skipped")
+	noProgress := flag.Bool("no-progress", false, "Don't draw progress bars on an interactive terminal")
+	rsyncPaths := flag.Bool("rsync-paths", false, "Sync a source directory without a trailing slash into a directory of the same name under the target path")
+	concurrency := flag.Int("concurrency", 0, "Number of concurrent upload/delete operations, 0 derives it from the CPU count and file sizes")
 	sizeOnly := flag.Bool("size-only", false, "Only use file size to determine if files are the same")
-	var excludePatterns stringSliceFlag
-	flag.Var(&excludePatterns, "exclude", "Exclude file or directory patterns, can be used multiple times")
+	update := flag.Bool("update", false, "Only transfer files newer than the other side, compared by modification time")
+	clockSkew := flag.Duration("clock-skew", 0, "With --update, how much newer a file must be than the other side")
+	ignoreExisting := flag.Bool("ignore-existing", false, "Only add files missing on the target, never update existing ones")
+	existing := flag.Bool("existing", false, "Only update files that exist on the target, never add new ones")
+	retentionMode := flag.String("retention-mode", "", "Object Lock retention mode applied to uploaded objects (governance or compliance)")
+	retentionPeriod := flag.String("retention-period", "", "Object Lock retention period applied to uploaded objects, e.g. 30d or 72h")
+	legalHold := flag.String("legal-hold", "", "Place or clear an Object Lock legal hold on uploaded objects (on or off)")
+	tempDir := flag.String("temp-dir", "", "Directory for partial downloads when the source is remote, default is next to the destination file")
+	stallTimeout := flag.Duration("stall-timeout", time.Minute, "Abort a transfer that moved no bytes for this long and re-queue it, 0 disables")
+	maxRequestsPerSecond := flag.Float64("max-requests-per-second", 0, "Limit requests to the backend across all targets, 0 disables")
+	maxRuntime := flag.Duration("max-runtime", 0, "Stop scheduling new transfers after this time budget, e.g. 6h")
+	checkpointFile := flag.String("checkpoint", "", "Checkpoint file written when the sync stops early")
+	shutdownGrace := flag.Duration("shutdown-grace", 25*time.Second, "Time in-flight transfers may take to finish after SIGTERM before they are aborted")
+	abortMultipart := flag.String("abort-multipart", "", "Abort incomplete multipart uploads under the target older than this before syncing, e.g. 24h")
+	progressSocket := flag.String("progress-socket", "", "Stream JSON progress events to clients of a unix socket at this path")
+	schedule := flag.String("schedule", "", "Keep running and sync on a cron schedule, e.g. '*/15 * * * *'")
+	windowSpec := flag.String("transfer-window", "", "Only start transfers within these hours of local time, e.g. 01:00-06:00")
+	scheduleJitter := flag.Duration("schedule-jitter", 0, "Random delay added to each scheduled sync")
+	httpAddr := flag.String("http-addr", "", "Serve the control API on this address in --schedule mode, e.g. 127.0.0.1:8080")
+	grpcAddr := flag.String("grpc-addr", "", "Serve the gRPC control service on this address in --schedule mode, e.g. 127.0.0.1:9090")
+	controlToken := flag.String("control-token", os.Getenv("R2SYNC_CONTROL_TOKEN"), "Bearer token required by the control API and gRPC service")
+	retryMaxAttempts := flag.Int("retry-max-attempts", 3, "Attempts per request including the first, 1 disables retries")
+	retryBaseDelay := flag.Duration("retry-base-delay", time.Second, "Delay before the first retry, doubled on every further retry")
+	retryMaxDelay := flag.Duration("retry-max-delay", 20*time.Second, "Upper bound of the delay between retries")
+	retryOn := flag.String("retry-on", "throttling,network,server", "Comma separated error classes that are retried")
+	breakerThreshold := flag.Float64("breaker-threshold", 0.5, "Failure ratio of the last 50 requests that pauses transfers until the backend recovers, 0 disables")
+	accountID := flag.String("account-id", "", "Cloudflare account id, selects the R2 endpoint of r2:// paths")
+	jurisdiction := flag.String("jurisdiction", "", "R2 jurisdiction of the buckets with --account-id: eu or fedramp")
+	dualStack := flag.Bool("dual-stack", false, "Use the dual-stack IPv4/IPv6 endpoint for s3:// paths")
+	endpointURL := flag.String("endpoint-url", os.Getenv("R2SYNC_ENDPOINT_URL"), "S3 API endpoint of every path, e.g. a MinIO server")
+	region := flag.String("region", os.Getenv("R2SYNC_REGION"), "Region requests are signed for")
+	pathStyleEnv, _ := strconv.ParseBool(os.Getenv("R2SYNC_FORCE_PATH_STYLE"))
+	forcePathStyle := flag.Bool("force-path-style", pathStyleEnv, "Address buckets in the path instead of the host name")
+	smallObjectThreshold := flag.String("small-object-threshold", "1M", "Files up to this size are read into memory and sent without Expect: 100-continue")
+	compress := flag.String("compress", "", "Compress file contents before upload: zstd or gzip")
+	chunkSize := flag.String("chunk-size", "0", "Upload files larger than this as chunk objects plus a manifest, 0 disables")
+	rcloneMetadata := flag.Bool("rclone-metadata", false, "Store the md5 and modification time of uploaded files in the metadata keys rclone reads")
+	partSize := flag.String("part-size", "0", "Part size of multipart uploads by other tools, tried first when comparing multipart ETags")
+	treeManifest := flag.Bool("tree-manifest", false, "Compare against a Merkle tree manifest stored as one object instead of listing the target")
+	dirManifests := flag.Bool("dir-manifests", false, "Skip listing and comparing subtrees whose stored directory rollup is unchanged")
+	inventory := flag.String("inventory", "", "S3 Inventory manifest.json or CSV listing object read as the remote state instead of listing the target")
+	pack := flag.Bool("pack", false, "Bundle small files into tar objects with an index instead of uploading them one by one")
+	packThreshold := flag.String("pack-threshold", "256K", "Files up to this size are packed with --pack")
+	packBundleSize := flag.String("pack-bundle-size", "64M", "Size at which a bundle is closed and a new one started")
+	packFormat := flag.String("pack-format", "tar", "Bundle format: tar or tar.zst")
+	expectPlan := flag.String("expect-plan", "", "Exit with status 4 when the plan hash differs, empty expects no operations")
+	pricingSpec := flag.String("pricing", "", "Rates of the --dryrun cost estimate, e.g. s3 or r2,storage=0.02")
+	allowRoot := flag.Bool("allow-root", false, "Allow --delete when a target path has no key prefix")
+	allowEmptySource := flag.Bool("allow-empty-source", false, "Allow --delete when the source has no files")
+	maxDelete := flag.Int("max-delete", 0, "With --delete, delete nothing when more than this many files would be deleted, 0 is unlimited")
+	appendOnly := flag.Bool("append-only", false, "Skip listing the target and only upload files modified after the newest file of the last run")
+	highWaterFile := flag.String("high-water", "", "File recording the newest synced file for --append-only")
+	verifyListing := flag.Bool("verify-listing", false, "Re-list the target after the uploads and fail before deleting when an uploaded key is missing or has an unexpected size")
+	watch := flag.Bool("watch", false, "Keep running after the sync and push local changes as they happen")
+	watchDebounce := flag.Duration("watch-debounce", 2*time.Second, "Time without further changes before they are pushed with --watch")
+	deterministic := flag.Bool("deterministic", false, "Run transfers one at a time in sorted order and log without timestamps and timings")
+	shards := flag.Int("shards", 0, "Split the uploads into this many key ranges and interleave them across workers")
+	probeBandwidth := flag.Bool("probe-bandwidth", false, "Measure the upload rate before deriving the default concurrency")
+	prewarm := flag.Int("prewarm", 0, "Open this many connections to every target before the first transfer")
+	dnsServer := flag.String("dns", "", "DNS resolver used for endpoints instead of the system resolver, e.g. 1.1.1.1")
+	ioConcurrency := flag.Int("io-concurrency", 0, "Number of concurrent local file reads, 0 doesn't limit")
+	listingCacheTTL := flag.Duration("listing-cache-ttl", 0, "Reuse the remote file list of the last run for this long, 0 disables")
+	refreshRemote := flag.Bool("refresh-remote", false, "List the target even when --listing-cache-ttl has a fresh remote file list")
+	dnsCacheTTL := flag.Duration("dns-cache-ttl", 10*time.Minute, "How long resolved endpoint addresses are reused, 0 disables caching")
+	ipVersion := flag.String("ip-version", "", "Only connect over IPv4 (4) or IPv6 (6)")
+	accelerate := flag.Bool("accelerate", false, "Use the S3 Transfer Acceleration endpoint for s3:// paths")
+	profile := flag.String("profile", "", "Shared config profile used for credentials, including SSO and role profiles")
+	credentialsCmd := flag.String("credentials-cmd", "", "Command printing temporary credentials in the credential_process JSON format")
+	mfaSerial := flag.String("mfa-serial", "", "MFA device serial number or ARN")
+	mfaToken := flag.String("mfa-token", "", "MFA token code, prompted for when required and not given")
+	// not listed in the usage, for testing retry, resume and alerting setups
+	chaos := flag.String("chaos", "", "Inject faults into backend calls, e.g. 0.05 or errors=0.02,latency=0.1,throttle=0.05,delay=3s")
+	debugHTTPFlag := flag.Bool("debug-http", false, "Log every signed request and response with secrets redacted")
+	bypassGovernance := flag.Bool("bypass-governance", false, "Delete objects under governance-mode retention")
+	var filters []r2sync.Filter
+	flag.Var(filterFlag{filters: &filters}, "exclude", "Exclude file or directory patterns, can be used multiple times")
+	flag.Var(filterFlag{filters: &filters, include: true}, "include", "Sync files matching this pattern that an earlier --exclude skips, can be used multiple times")
+	hidden := flag.String("hidden", "include", "Sync dotfiles and dot-directories (include) or skip them (exclude)")
+	var includeMIME, excludeMIME stringSliceFlag
+	flag.Var(&includeMIME, "include-mime", "Only sync files whose detected MIME type matches this pattern, can be used multiple times")
+	flag.Var(&excludeMIME, "exclude-mime", "Skip files whose detected MIME type matches this pattern, can be used multiple times")
+	var headerRules []r2sync.HeaderRule
+	flag.Var(headerRuleFlag{rules: &headerRules, header: r2sync.HeaderCacheControl}, "cache-control", "Cache-Control of uploaded keys matching a pattern, as pattern=value, can be used multiple times")
+	flag.Var(headerRuleFlag{rules: &headerRules, header: r2sync.HeaderContentDisposition}, "content-disposition", "Content-Disposition of uploaded keys matching a pattern, as pattern=value, can be used multiple times")
+	flag.Var(headerRuleFlag{rules: &headerRules, header: r2sync.HeaderContentLanguage}, "content-language", "Content-Language of uploaded keys matching a pattern, as pattern=value, can be used multiple times")
+	var transformRules stringSliceFlag
+	flag.Var(&transformRules, "transform", "Rename relative paths with a sed-style s/regex/replacement/ before they become keys, can be used multiple times")
+	stripComponents := flag.Int("strip-components", 0, "Remove this many leading directories from relative paths before they become keys")
+	prefixMap := flag.String("prefix-map", "", "File of \"local/dir/ -> bucket/prefix/\" lines routing subdirectories of the source to their own target")
+	var alsoTargets stringSliceFlag
+	flag.Var(&alsoTargets, "also", "Additional target path uploaded to concurrently, can be used multiple times")
+	var replicateTargets stringSliceFlag
+	flag.Var(&replicateTargets, "replicate-to", "Secondary target path receiving server-side copies of uploads and deletes, can be used multiple times")
 	flag.Parse()
 
 	args := flag.Args()
@@ -378,22 +531,625 @@ func main() {
 		os.Exit(1)
 	}
 
-	sourcePath := normalizePath(args[0])
-	u, err := url.Parse(normalizePath(args[1]))
+	// a remote source pulls into the local target, sourcePath is the local and targetPath the
+	// remote path in both directions
+	pull := pullSource(args[0], args[1])
+	// with two remote urls objects are copied server-side from the source to the target prefix
+	remoteCopy := strings.Contains(args[0], "://") && strings.Contains(args[1], "://")
+	localArg, remoteArg := args[0], args[1]
+	if pull {
+		localArg, remoteArg = args[1], args[0]
+	}
+	sourcePath := r2sync.NormalizePath(localArg)
+	scheme, bucket, targetPath, err := r2sync.ParseRemoteURL(remoteArg)
 	if err != nil {
 		fmt.Println("Invalid target path: ", err)
 		fmt.Println()
 		usage()
 		os.Exit(1)
 	}
-	targetPath := strings.TrimPrefix(u.Path, "/")
-	bucket := u.Host
-	for i, pattern := range excludePatterns {
-		excludePatterns[i] = normalizePath(pattern)
+	targetDir := func(targetPath string) string {
+		if *rsyncPaths && !pull && !remoteCopy {
+			return rsyncTarget(sourcePath, targetPath)
+		}
+		return targetPath
+	}
+	targetPath = targetDir(targetPath)
+	sourcePath = path.Clean(sourcePath)
+	excludeHidden, err := r2sync.ParseHidden(*hidden)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := r2sync.ValidateMIMEPatterns(append(includeMIME, excludeMIME...)); err != nil {
+		fmt.Println("Invalid --include-mime/--exclude-mime: ", err)
+		os.Exit(1)
 	}
 
-	client := NewR2Client(bucket, u.Scheme)
-	err = client.Sync(sourcePath, targetPath, *delete, *dryRun, *recursive, *concurrency, *sizeOnly, excludePatterns)
+	lockMode, err := r2sync.ParseRetentionMode(*retentionMode)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var lockPeriod time.Duration
+	if lockMode != "" {
+		if *retentionPeriod == "" {
+			fmt.Println("--retention-period is required with --retention-mode")
+			os.Exit(1)
+		}
+		lockPeriod, err = r2sync.ParseDuration(*retentionPeriod)
+		if err != nil || lockPeriod <= 0 {
+			fmt.Println("Invalid retention period: ", *retentionPeriod)
+			os.Exit(1)
+		}
+	}
+	holdStatus, err := r2sync.ParseLegalHold(*legalHold)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var abortMultipartAge time.Duration
+	if *abortMultipart != "" {
+		if abortMultipartAge, err = r2sync.ParseDuration(*abortMultipart); err != nil || abortMultipartAge <= 0 {
+			fmt.Println("Invalid --abort-multipart: ", *abortMultipart)
+			os.Exit(1)
+		}
+	}
+	retryable, err := r2sync.ParseErrorClasses(*retryOn)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *retryMaxAttempts < 1 {
+		fmt.Println("--retry-max-attempts must be at least 1")
+		os.Exit(1)
+	}
+	retries := r2sync.RetryPolicy{
+		MaxAttempts: *retryMaxAttempts,
+		BaseDelay:   *retryBaseDelay,
+		MaxDelay:    *retryMaxDelay,
+		Retryable:   retryable,
+	}
+	if *ioConcurrency < 0 {
+		fmt.Println("Invalid --io-concurrency: ", *ioConcurrency)
+		os.Exit(1)
+	}
+	ioLimit := r2sync.NewIOLimiter(*ioConcurrency)
+	if *deterministic {
+		setDeterministic()
+	}
+	ipVer, err := r2sync.ParseIPVersion(*ipVersion)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	network := &r2sync.NetworkOptions{IPVersion: ipVer, DNS: *dnsServer, CacheTTL: *dnsCacheTTL, IdleConns: max(*prewarm, *concurrency)}
+	if *concurrency == 0 {
+		network.IdleConns = max(*prewarm, r2sync.MaxAutoConcurrency)
+	}
+	optFns := []func(*s3.Options){network.Apply}
+	if *profile != "" || *mfaSerial != "" || *mfaToken != "" || *credentialsCmd != "" {
+		credentials, err := r2sync.LoadCredentials(context.Background(), r2sync.CredentialOptions{
+			Profile:   *profile,
+			MFASerial: *mfaSerial,
+			MFAToken:  *mfaToken,
+			Command:   *credentialsCmd,
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		optFns = append(optFns, credentials)
+	}
+	if *stallTimeout > 0 {
+		optFns = append(optFns, r2sync.WithStallTimeout(*stallTimeout))
+	}
+	if *maxRequestsPerSecond < 0 {
+		fmt.Println("Invalid --max-requests-per-second: must not be negative")
+		os.Exit(1)
+	}
+	if *maxRequestsPerSecond > 0 {
+		optFns = append(optFns, r2sync.WithRequestLimit(r2sync.NewRequestLimiter(*maxRequestsPerSecond)))
+	}
+	if *chaos != "" {
+		chaosOpts, err := r2sync.ParseChaos(*chaos)
+		if err != nil {
+			fmt.Println("Invalid --chaos: ", err)
+			os.Exit(1)
+		}
+		log.Printf("Chaos mode: injecting errors %.0f%%, latency %.0f%%, throttling %.0f%% of requests.\n", chaosOpts.Errors*100, chaosOpts.Latency*100, chaosOpts.Throttle*100)
+		optFns = append(optFns, r2sync.WithChaos(chaosOpts))
+	}
+	if *debugHTTPFlag {
+		optFns = append(optFns, r2sync.WithDebugHTTP())
+	}
+	if *region != "" {
+		optFns = append(optFns, r2sync.WithRegion(*region))
+	}
+	if *endpointURL != "" {
+		if *accountID != "" {
+			fmt.Println("--endpoint-url can't be combined with --account-id")
+			os.Exit(1)
+		}
+		if u, err := url.Parse(*endpointURL); err != nil || u.Scheme == "" || u.Host == "" {
+			fmt.Println("Invalid --endpoint-url: must be a url like https://host:port")
+			os.Exit(1)
+		}
+		optFns = append(optFns, r2sync.WithEndpoint(*endpointURL))
+	}
+	if *forcePathStyle {
+		optFns = append(optFns, r2sync.WithPathStyle())
+	}
+	// R2_ACCOUNT_ID selects the endpoint like --account-id, unless --endpoint-url is given
+	if *accountID == "" && *endpointURL == "" {
+		*accountID = os.Getenv("R2_ACCOUNT_ID")
+	}
+	var r2Opts []func(*s3.Options)
+	if *accountID != "" {
+		endpoint, err := r2sync.R2Endpoint(*accountID, *jurisdiction)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		r2Opts = append(r2Opts, r2sync.WithEndpoint(endpoint))
+	} else if *jurisdiction != "" {
+		fmt.Println("--jurisdiction requires --account-id or R2_ACCOUNT_ID")
+		os.Exit(1)
+	}
+	var awsOpts []func(*s3.Options)
+	if *accelerate {
+		awsOpts = append(awsOpts, r2sync.WithAccelerate())
+	}
+	if *dualStack {
+		awsOpts = append(awsOpts, r2sync.WithDualStack())
+	}
+	smallObjectSize, err := r2sync.ParseSize(*smallObjectThreshold)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	compression, err := r2sync.ParseCompression(*compress)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	chunkBytes, err := r2sync.ParseSize(*chunkSize)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	partBytes, err := r2sync.ParseSize(*partSize)
+	if err != nil {
+		fmt.Println("Invalid --part-size: ", err)
+		os.Exit(1)
+	}
+	var packOpts *r2sync.PackOptions
+	if *pack {
+		packOpts = &r2sync.PackOptions{}
+		if packOpts.Threshold, err = r2sync.ParseSize(*packThreshold); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if packOpts.BundleSize, err = r2sync.ParseSize(*packBundleSize); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if packOpts.Format, err = r2sync.ParsePackFormat(*packFormat); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if *dirManifests && packOpts != nil {
+		fmt.Println("--dir-manifests can't be combined with --pack")
+		os.Exit(1)
+	}
+	transform, err := r2sync.ParseKeyTransform(transformRules, *stripComponents)
+	if err != nil {
+		fmt.Println("Invalid --transform/--strip-components: ", err)
+		os.Exit(1)
+	}
+	if transform != nil && (*dirManifests || *treeManifest || packOpts != nil) {
+		fmt.Println("--transform and --strip-components can't be combined with --dir-manifests, --tree-manifest or --pack")
+		os.Exit(1)
+	}
+	if *treeManifest && (*dirManifests || packOpts != nil || chunkBytes > 0) {
+		fmt.Println("--tree-manifest can't be combined with --dir-manifests, --pack or --chunk-size")
+		os.Exit(1)
+	}
+	expectedPlan := ""
+	if *expectPlan != "" {
+		if expectedPlan, err = r2sync.ParseExpectPlan(*expectPlan); err != nil {
+			fmt.Println("Invalid --expect-plan: ", err)
+			os.Exit(1)
+		}
+	}
+	var pricing *r2sync.Pricing
+	if *pricingSpec != "" {
+		base := r2sync.R2Pricing
+		if scheme == "s3" {
+			base = r2sync.S3Pricing
+		}
+		parsed, err := r2sync.ParsePricing(*pricingSpec, base)
+		if err != nil {
+			fmt.Println("Invalid --pricing: ", err)
+			os.Exit(1)
+		}
+		pricing = &parsed
+	}
+	var window *r2sync.TransferWindow
+	if *windowSpec != "" {
+		if window, err = r2sync.ParseTransferWindow(*windowSpec); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	var cron *cronSchedule
+	if *schedule != "" {
+		if cron, err = parseCron(*schedule); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if (*httpAddr != "" || *grpcAddr != "") && cron == nil {
+		fmt.Println("--http-addr and --grpc-addr require --schedule")
+		os.Exit(1)
+	}
+
+	// progress bars replace the scrolling log on a terminal, a dry run transfers nothing and a
+	// schedule runs unattended
+	var meter *r2sync.TransferMeter
+	if !*noProgress && !*dryRun && !*deterministic && cron == nil && interactiveStderr() {
+		meter = r2sync.NewTransferMeter()
+	}
+	var clients []*r2sync.R2Client
+	newClient := func(scheme, remoteURL string) *r2sync.R2Client {
+		clientOpts := optFns
+		switch scheme {
+		case "r2":
+			clientOpts = append(slices.Clone(optFns), r2Opts...)
+		case "s3":
+			clientOpts = append(slices.Clone(optFns), awsOpts...)
+		}
+		client, err := r2sync.NewClient(context.Background(), r2sync.WithR2Env(), r2sync.WithRetryPolicy(retries), r2sync.WithS3Options(clientOpts...), r2sync.WithRemoteURL(remoteURL))
+		if err != nil {
+			log.Fatal(err)
+		}
+		client.RetentionMode = lockMode
+		client.Meter = meter
+		client.RetentionPeriod = lockPeriod
+		client.LegalHoldStatus = holdStatus
+		client.BypassGovernance = *bypassGovernance
+		client.SmallObjectThreshold = smallObjectSize
+		client.Compression = compression
+		client.RcloneMetadata = *rcloneMetadata
+		client.IOLimit = ioLimit
+		client.DeterministicLogs = *deterministic
+		client.Heartbeat = heartbeat
+		if err := client.SetDownloadTempDir(*tempDir); err != nil {
+			fmt.Println("Invalid --temp-dir: ", err)
+			os.Exit(1)
+		}
+		client.HeaderRules = headerRules
+		clients = append(clients, client)
+		return client
+	}
+	opts := r2sync.SyncOptions{
+		Delete:         *delete,
+		DryRun:         *dryRun,
+		Recursive:      *recursive,
+		Concurrency:    *concurrency,
+		SizeOnly:       *sizeOnly,
+		Update:         *update,
+		ClockSkew:      *clockSkew,
+		IgnoreExisting: *ignoreExisting,
+		Existing:       *existing,
+		Filters:        filters,
+		ExcludeHidden:  excludeHidden,
+		IncludeMIME:    includeMIME,
+		ExcludeMIME:    excludeMIME,
+		MaxRuntime:     *maxRuntime,
+		CheckpointFile: *checkpointFile,
+
+		AbortMultipartOlderThan: abortMultipartAge,
+		BreakerThreshold:        *breakerThreshold,
+		Prewarm:                 *prewarm,
+		Pack:                    packOpts,
+		ChunkSize:               chunkBytes,
+		PartSize:                partBytes,
+		DirManifests:            *dirManifests,
+		TreeManifest:            *treeManifest,
+		ProbeBandwidth:          *probeBandwidth,
+		Window:                  window,
+		Shards:                  *shards,
+		Deterministic:           *deterministic,
+		AllowEmptySource:        *allowEmptySource,
+		MaxDelete:               *maxDelete,
+		VerifyListing:           *verifyListing,
+		ExpectPlan:              expectedPlan,
+		Pricing:                 pricing,
+		Transform:               transform,
+		Verbose:                 *verbose,
+	}
+	if opts.CheckpointFile == "" {
+		opts.CheckpointFile = r2sync.DefaultCheckpointPath(sourcePath, remoteArg)
+	}
+	if *listingCacheTTL > 0 {
+		opts.ListingCache = &r2sync.ListingCache{Dir: r2sync.DefaultListingCacheDir(), TTL: *listingCacheTTL, Refresh: *refreshRemote}
+	}
+	if *appendOnly {
+		opts.HighWaterFile = *highWaterFile
+		if opts.HighWaterFile == "" {
+			opts.HighWaterFile = r2sync.DefaultHighWaterPath(sourcePath, remoteArg)
+		}
+	}
+	// targets without a key prefix, where --delete removes objects from the whole bucket
+	var roots []string
+	if !pull && targetPath == "" {
+		roots = append(roots, remoteArg)
+	}
+	for _, also := range alsoTargets {
+		alsoScheme, _, alsoPath, err := r2sync.ParseRemoteURL(also)
+		if err != nil {
+			fmt.Println("Invalid target path: ", err)
+			fmt.Println()
+			usage()
+			os.Exit(1)
+		}
+		opts.Mirrors = append(opts.Mirrors, r2sync.Mirror{Client: newClient(alsoScheme, also), RemotePath: targetDir(alsoPath)})
+		if targetDir(alsoPath) == "" {
+			roots = append(roots, also)
+		}
+	}
+	for _, replicateTo := range replicateTargets {
+		replicateScheme, _, replicatePath, err := r2sync.ParseRemoteURL(replicateTo)
+		if err != nil {
+			fmt.Println("Invalid target path: ", err)
+			fmt.Println()
+			usage()
+			os.Exit(1)
+		}
+		opts.ReplicateTo = append(opts.ReplicateTo, r2sync.Mirror{Client: newClient(replicateScheme, replicateTo), RemotePath: targetDir(replicatePath)})
+		if targetDir(replicatePath) == "" {
+			roots = append(roots, replicateTo)
+		}
+	}
+	if *inventory != "" {
+		inventoryScheme, _, inventoryKey, err := r2sync.ParseRemoteURL(*inventory)
+		if err == nil && inventoryKey == "" {
+			err = fmt.Errorf("no object key in %s", *inventory)
+		}
+		if err != nil {
+			fmt.Println("Invalid --inventory: ", err)
+			os.Exit(1)
+		}
+		opts.Inventory = &r2sync.Inventory{Client: newClient(inventoryScheme, *inventory), Key: inventoryKey}
+	}
+
+	var progress *progressServer
+	if *progressSocket != "" {
+		if progress, err = listenProgress(*progressSocket); err != nil {
+			log.Fatal(err)
+		}
+		opts.Progress = progress.Emit
+	}
+	// every run gets an id, recorded in the metadata of its uploads and with its changes in a run
+	// record listed by r2sync ls --last-run
+	var run *r2sync.RunRecord
+	if !opts.DryRun {
+		emit := opts.Progress
+		opts.Progress = func(event r2sync.ProgressEvent) {
+			run.Track(event)
+			if emit != nil {
+				emit(event)
+			}
+		}
+	}
+	opts.Pause = &r2sync.PauseGate{}
+	handlePauseSignals(opts.Pause)
+	var control *daemonState
+	if *httpAddr != "" || *grpcAddr != "" {
+		control = newDaemonState(opts.Pause)
+		emit := opts.Progress
+		opts.Progress = func(event r2sync.ProgressEvent) {
+			control.Track(event)
+			if emit != nil {
+				emit(event)
+			}
+		}
+		if *httpAddr != "" {
+			serveControl(*httpAddr, *controlToken, control)
+		}
+		if *grpcAddr != "" {
+			if err := serveGRPC(*grpcAddr, *controlToken, control); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	ctx, drain := handleInterrupts(*shutdownGrace)
+	opts.Drain = drain
+	client := newClient(scheme, remoteArg)
+	var copySource *r2sync.R2Client
+	var copyPrefix string
+	if remoteCopy {
+		sourceScheme, _, sourcePrefix, err := r2sync.ParseRemoteURL(args[0])
+		if err != nil {
+			fmt.Println("Invalid source path: ", err)
+			fmt.Println()
+			usage()
+			os.Exit(1)
+		}
+		copySource, copyPrefix = newClient(sourceScheme, args[0]), sourcePrefix
+	}
+	syncs := []func(context.Context) error{func(ctx context.Context) error {
+		switch {
+		case remoteCopy:
+			_, err := client.Replicate(ctx, copySource, copyPrefix, targetPath, true, false, false, opts)
+			return err
+		case pull:
+			return client.Pull(ctx, targetPath, sourcePath, opts)
+		}
+		return client.Sync(ctx, sourcePath, targetPath, opts)
+	}}
+	if (pull || remoteCopy) && (len(opts.Mirrors) > 0 || len(opts.ReplicateTo) > 0 || *prefixMap != "" || opts.Pack != nil || opts.DirManifests || opts.TreeManifest || opts.Transform != nil) {
+		fmt.Println("--also, --replicate-to, --prefix-map, --pack, --dir-manifests, --tree-manifest, --transform and --strip-components can't be used with a remote source")
+		os.Exit(1)
+	}
+	// objects are copied server-side, their content is never read or listed again
+	if remoteCopy && (opts.VerifyListing || len(opts.IncludeMIME) > 0 || len(opts.ExcludeMIME) > 0) {
+		fmt.Println("--verify-listing, --include-mime and --exclude-mime can't be combined with two remote urls")
+		os.Exit(1)
+	}
+	if (opts.IgnoreExisting || opts.Existing) && (remoteCopy || opts.Pack != nil) {
+		fmt.Println("--ignore-existing and --existing can't be combined with two remote urls or --pack")
+		os.Exit(1)
+	}
+	if opts.Inventory != nil && (remoteCopy || opts.DirManifests || opts.TreeManifest) {
+		fmt.Println("--inventory can't be combined with two remote urls, --dir-manifests or --tree-manifest")
+		os.Exit(1)
+	}
+	if opts.Update && (opts.SizeOnly || remoteCopy || opts.Pack != nil) {
+		fmt.Println("--update can't be combined with --size-only, two remote urls or --pack")
+		os.Exit(1)
+	}
+	// nothing is listed that could be deleted or compared
+	if *appendOnly && (pull || remoteCopy || opts.Delete || opts.IgnoreExisting || opts.Existing || opts.Pack != nil || opts.DirManifests || opts.TreeManifest || opts.Inventory != nil) {
+		fmt.Println("--append-only can't be combined with a remote source, --delete, --ignore-existing, --existing, --pack, --dir-manifests, --tree-manifest or --inventory")
+		os.Exit(1)
+	}
+	// a change is synced like a tree of its own, which the state of a whole tree doesn't fit
+	if *watch && (pull || remoteCopy || cron != nil || *prefixMap != "" || *appendOnly || opts.Pack != nil || opts.DirManifests || opts.TreeManifest || opts.Inventory != nil || opts.Transform != nil || opts.ExpectPlan != "" || opts.MaxRuntime > 0) {
+		fmt.Println("--watch can't be combined with a remote source, --schedule, --prefix-map, --append-only, --pack, --dir-manifests, --tree-manifest, --inventory, --transform, --strip-components, --expect-plan or --max-runtime")
+		os.Exit(1)
+	}
+	if *watch && *watchDebounce <= 0 {
+		fmt.Println("Invalid --watch-debounce: must be positive")
+		os.Exit(1)
+	}
+	if *prefixMap != "" {
+		mappings, err := loadPrefixMap(*prefixMap, scheme)
+		if err != nil {
+			fmt.Println("Invalid --prefix-map: ", err)
+			os.Exit(1)
+		}
+		if opts.ExpectPlan != "" {
+			fmt.Println("--expect-plan can't be combined with --prefix-map")
+			os.Exit(1)
+		}
+		for i, mapping := range mappings {
+			mappedScheme, mappedBucket, mappedPath, _ := r2sync.ParseRemoteURL(mapping.target)
+			if opts.Delete && mappedScheme == scheme && mappedBucket == bucket && prefixesOverlap(mappedPath, targetPath) {
+				fmt.Printf("--delete can't be used when the target of %s overlaps the target path\n", mapping.localDir)
+				os.Exit(1)
+			}
+			for _, other := range mappings[:i] {
+				otherScheme, otherBucket, otherPath, _ := r2sync.ParseRemoteURL(other.target)
+				if opts.Delete && mappedScheme == otherScheme && mappedBucket == otherBucket && prefixesOverlap(mappedPath, otherPath) {
+					fmt.Printf("--delete can't be used when the targets of %s and %s overlap\n", other.localDir, mapping.localDir)
+					os.Exit(1)
+				}
+			}
+			if mappedPath == "" {
+				roots = append(roots, mapping.target)
+			}
+			localDir := path.Join(sourcePath, mapping.localDir)
+			// the mapped subdirectory is synced to its own target only
+			opts.ExcludePatterns = append(opts.ExcludePatterns, escapeGlob(localDir))
+			mappedOpts := opts
+			mappedOpts.Mirrors, mappedOpts.ReplicateTo = nil, nil
+			// the inventory lists the target bucket only, mapped targets are listed
+			mappedOpts.Inventory = nil
+			mappedOpts.CheckpointFile = r2sync.DefaultCheckpointPath(localDir, mapping.target)
+			if *checkpointFile != "" {
+				mappedOpts.CheckpointFile = fmt.Sprintf("%s.%d", *checkpointFile, i+1)
+			}
+			if *appendOnly {
+				mappedOpts.HighWaterFile = r2sync.DefaultHighWaterPath(localDir, mapping.target)
+				if *highWaterFile != "" {
+					mappedOpts.HighWaterFile = fmt.Sprintf("%s.%d", *highWaterFile, i+1)
+				}
+			}
+			mappedClient := newClient(mappedScheme, mapping.target)
+			syncs = append(syncs, func(ctx context.Context) error {
+				return mappedClient.Sync(ctx, localDir, mappedPath, mappedOpts)
+			})
+		}
+	}
+	if opts.Delete && !opts.DryRun && len(roots) > 0 && !*allowRoot && !confirmRootDelete(roots) {
+		fmt.Printf("--delete into a target without a key prefix deletes from the whole bucket of %s, use --allow-root if this is intended\n", strings.Join(roots, ", "))
+		os.Exit(1)
+	}
+	// mapped subdirectories are synced one after another, a failed one doesn't stop the others
+	syncAll := func(ctx context.Context) error {
+		if !opts.DryRun {
+			run = r2sync.NewRunRecord(args[0], args[1])
+			for _, client := range clients {
+				client.RunID = run.ID
+			}
+			if !*deterministic {
+				log.Printf("Run id: %s\n", run.ID)
+			}
+			defer func() {
+				if err := run.Save(r2sync.DefaultRunDir()); err != nil {
+					log.Printf("failed to save run record: %v\n", err)
+				}
+			}()
+		}
+		var errs []error
+		for _, sync := range syncs {
+			err := sync(ctx)
+			if errors.Is(err, r2sync.ErrMaxRuntime) || errors.Is(err, r2sync.ErrInterrupted) || errors.Is(err, context.Canceled) {
+				return err
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if *watch {
+			if err := errors.Join(errs...); err != nil {
+				log.Printf("sync failed: %v\n", err)
+			}
+			return client.Watch(ctx, sourcePath, targetPath, opts, *watchDebounce)
+		}
+		return errors.Join(errs...)
+	}
+	if cron != nil {
+		runSchedule(drain, cron, *scheduleJitter, control, func() error {
+			if control == nil {
+				return syncAll(ctx)
+			}
+			return control.Run(ctx, syncAll)
+		})
+		if progress != nil {
+			progress.Close()
+		}
+		return
+	}
+	var bars *progressBars
+	if meter != nil {
+		bars = startProgressBars(meter)
+	}
+	err = syncAll(ctx)
+	if bars != nil {
+		bars.Close()
+	}
+	if progress != nil {
+		progress.Close()
+	}
+	if errors.Is(err, r2sync.ErrMaxRuntime) {
+		os.Exit(3)
+	}
+	if errors.Is(err, r2sync.ErrPlanDrift) {
+		log.Println(err)
+		os.Exit(4)
+	}
+	if errors.Is(err, r2sync.ErrInterrupted) || errors.Is(err, context.Canceled) {
+		os.Exit(int(interruptExitCode.Load()))
+	}
+	if errors.Is(err, r2sync.ErrPartialFailure) {
+		log.Println(err)
+		os.Exit(5)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gofika/r2sync"
+)
+
+func abortMultipartUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: r2sync abort-multipart [--older-than DURATION] [--dryrun] <target url>
+
+Lists and aborts incomplete multipart uploads under the target prefix, which otherwise
+silently accumulate storage charges after crashed runs.
+
+Options:
+  --dryrun (boolean)
+    	Only display the operations to be performed, without actually executing them
+  --older-than (duration)
+    	Only abort uploads initiated longer ago than this, e.g. 24h or 7d, default is 24h
+
+Examples:
+    r2sync abort-multipart r2://bucket/path/ --older-than 24h
+    r2sync abort-multipart --dryrun r2://bucket`)
+}
+
+func runAbortMultipart(args []string) {
+	flags := flag.NewFlagSet("abort-multipart", flag.ExitOnError)
+	flags.Usage = abortMultipartUsage
+	olderThan := flags.String("older-than", "24h", "Only abort uploads initiated longer ago than this")
+	dryRun := flags.Bool("dryrun", false, "Only display the operations to be performed, without actually executing them")
+	positional := parseFlags(flags, args)
+
+	if len(positional) != 1 {
+		abortMultipartUsage()
+		os.Exit(1)
+	}
+	age, err := r2sync.ParseDuration(*olderThan)
+	if err != nil {
+		fmt.Println("Invalid --older-than: ", err)
+		os.Exit(1)
+	}
+	_, _, prefix, err := r2sync.ParseRemoteURL(positional[0])
+	if err != nil {
+		fmt.Println("Invalid target path: ", err)
+		fmt.Println()
+		abortMultipartUsage()
+		os.Exit(1)
+	}
+
+	client, err := r2sync.NewClient(context.Background(), r2sync.WithR2Env(), r2sync.WithRemoteURL(positional[0]))
+	if err != nil {
+		log.Fatal(err)
+	}
+	abortCount, err := client.AbortStaleMultipartUploads(context.Background(), prefix, age, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d incomplete multipart uploads aborted.\n", abortCount)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gofika/r2sync"
+)
+
+func unpackUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: r2sync unpack [--dryrun] [--strict-keys] [--temp-dir DIR] <source url> <local path>
+
+Extracts the small files a sync with --pack bundled into tar objects under the source prefix
+into the local directory. Every bundle is downloaded once, files whose local copy already has
+the packed size and modification time are skipped.
+
+Options:
+  --dryrun (boolean)
+    	Only display the operations to be performed, without actually executing them
+  --strict-keys (boolean)
+    	Fail on paths with .. segments or absolute paths instead of writing them to a sanitized path
+    	inside the local directory
+  --temp-dir (path)
+    	Directory for partial files, renamed into place when complete, default is next to the
+    	destination file
+
+Examples:
+    r2sync unpack r2://bucket/backup/ /restore/dir`)
+}
+
+func runUnpack(args []string) {
+	flags := flag.NewFlagSet("unpack", flag.ExitOnError)
+	flags.Usage = unpackUsage
+	dryRun := flags.Bool("dryrun", false, "Only display the operations to be performed, without actually executing them")
+	strictKeys := flags.Bool("strict-keys", false, "Fail on paths with .. segments or absolute paths instead of sanitizing them")
+	tempDir := flags.String("temp-dir", "", "Directory for partial files, default is next to the destination file")
+	args = parseFlags(flags, args)
+
+	if len(args) != 2 {
+		unpackUsage()
+		os.Exit(1)
+	}
+	_, _, remotePath, err := r2sync.ParseRemoteURL(args[0])
+	if err != nil {
+		fmt.Println("Invalid source path: ", err)
+		fmt.Println()
+		unpackUsage()
+		os.Exit(1)
+	}
+
+	client, err := r2sync.NewClient(context.Background(), r2sync.WithR2Env(), r2sync.WithRemoteURL(args[0]))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := client.SetDownloadTempDir(*tempDir); err != nil {
+		fmt.Println("Invalid --temp-dir: ", err)
+		os.Exit(1)
+	}
+	client.StrictKeys = *strictKeys
+	count, err := client.Unpack(context.Background(), remotePath, args[1], *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d files unpacked.\n", count)
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gofika/r2sync"
+)
+
+// handlePauseSignals pauses gate on SIGUSR1 and resumes it on SIGUSR2
+func handlePauseSignals(gate *r2sync.PauseGate) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR1 {
+				gate.Pause()
+				log.Println("Paused by SIGUSR1, in-flight transfers continue. Send SIGUSR2 to resume.")
+			} else {
+				gate.Resume()
+				log.Println("Resumed by SIGUSR2.")
+			}
+		}
+	}()
+}
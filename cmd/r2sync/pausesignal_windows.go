@@ -0,0 +1,6 @@
+package main
+
+import "github.com/gofika/r2sync"
+
+// handlePauseSignals does nothing on Windows, which has no SIGUSR1 and SIGUSR2
+func handlePauseSignals(gate *r2sync.PauseGate) {}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/gofika/r2sync"
+)
+
+func policyUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: r2sync policy get <bucket url> [output file]
+       r2sync policy put [--dryrun] <bucket url> <policy file>
+
+Policy files are IAM policy JSON documents, or YAML when the file ends with .yaml/.yml.
+Putting an empty document removes the bucket policy. R2 does not support bucket
+policies, use "r2sync public" to toggle public access of R2 buckets instead.
+
+Options:
+  --dryrun (boolean)
+    	Only display the operations to be performed, without actually executing them
+
+Examples:
+    r2sync policy get s3://bucket
+    r2sync policy put s3://bucket policy.json`)
+}
+
+func runPolicy(args []string) {
+	command := &bucketConfigCommand{
+		name:  "policy",
+		usage: policyUsage,
+		get: func(ctx context.Context, client *r2sync.R2Client) (any, error) {
+			return client.GetPolicy(ctx)
+		},
+		put: func(ctx context.Context, client *r2sync.R2Client, name string, dryRun bool) error {
+			policy := map[string]any{}
+			if err := readConfigFile(name, &policy); err != nil {
+				return err
+			}
+			return client.PutPolicy(ctx, policy, dryRun)
+		},
+	}
+	command.run(args)
+}
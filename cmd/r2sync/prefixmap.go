@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gofika/r2sync"
+)
+
+// prefixMapping routes a local subdirectory to its own target, which may be in another bucket
+type prefixMapping struct {
+	localDir string // relative to the source path
+	target   string // remote url
+}
+
+// loadPrefixMap reads a mapping file with one "local/dir/ -> bucket/prefix/" line per subdirectory.
+// Targets without a scheme use the scheme of the main target, empty lines and lines starting with
+// # are ignored.
+func loadPrefixMap(file, scheme string) ([]prefixMapping, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mappings []prefixMapping
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		localDir, target, ok := strings.Cut(text, "->")
+		localDir, target = strings.TrimSpace(localDir), strings.TrimSpace(target)
+		if !ok || localDir == "" || target == "" {
+			return nil, fmt.Errorf("%s:%d: expected local/dir/ -> bucket/prefix/", file, line)
+		}
+		localDir = path.Clean(r2sync.NormalizePath(localDir))
+		if localDir == "." || path.IsAbs(localDir) || localDir == ".." || strings.HasPrefix(localDir, "../") {
+			return nil, fmt.Errorf("%s:%d: %s is not a subdirectory of the source path", file, line, localDir)
+		}
+		if seen[localDir] {
+			return nil, fmt.Errorf("%s:%d: %s is mapped twice", file, line, localDir)
+		}
+		seen[localDir] = true
+		if !strings.Contains(target, "://") {
+			target = scheme + "://" + target
+		}
+		if _, _, _, err := r2sync.ParseRemoteURL(target); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+		mappings = append(mappings, prefixMapping{localDir: localDir, target: target})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, a := range mappings {
+		for _, b := range mappings {
+			if a != b && strings.HasPrefix(a.localDir+"/", b.localDir+"/") {
+				return nil, fmt.Errorf("%s: %s is inside the mapped directory %s", file, a.localDir, b.localDir)
+			}
+		}
+	}
+	return mappings, nil
+}
+
+// prefixesOverlap reports whether one key prefix contains the other, taking both as directories
+func prefixesOverlap(a, b string) bool {
+	a, b = strings.TrimSuffix(a, "/")+"/", strings.TrimSuffix(b, "/")+"/"
+	return a == "/" || b == "/" || strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// escapeGlob quotes the path.Match metacharacters in s
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofika/r2sync"
+)
+
+// progressServer streams progress events as JSON lines to every client connected to a local socket
+type progressServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	clients  map[net.Conn]chan []byte
+	wg       sync.WaitGroup
+}
+
+// listenProgress listens on a unix socket at path, replacing a stale socket left by a previous run
+func listenProgress(path string) (*progressServer, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &progressServer{listener: listener, clients: make(map[net.Conn]chan []byte)}
+	go s.accept()
+	return s, nil
+}
+
+func (s *progressServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		queue := make(chan []byte, 256)
+		s.mu.Lock()
+		s.clients[conn] = queue
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serve(conn, queue)
+	}
+}
+
+func (s *progressServer) serve(conn net.Conn, queue chan []byte) {
+	defer s.wg.Done()
+	defer conn.Close()
+	failed := false
+	for line := range queue {
+		// after a failed write keep draining until Close, events for this client are discarded
+		if failed {
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write(line); err != nil {
+			failed = true
+		}
+	}
+}
+
+// Emit sends the event to all clients, events are dropped for clients that can't keep up
+func (s *progressServer) Emit(event r2sync.ProgressEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("progress event failed: %v\n", err)
+		return
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, queue := range s.clients {
+		select {
+		case queue <- line:
+		default:
+		}
+	}
+}
+
+// Close stops accepting clients, removes the socket and waits until queued events are written
+func (s *progressServer) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn, queue := range s.clients {
+		close(queue)
+		delete(s.clients, conn)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
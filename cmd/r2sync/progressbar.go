@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofika/r2sync"
+)
+
+// maxProgressFiles is the number of running transfers shown with their own bar
+const maxProgressFiles = 5
+
+// interactiveStderr reports whether stderr is a terminal that can redraw progress bars
+func interactiveStderr() bool {
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+// progressBars draws the bars of a transfer meter below the log output of a terminal. Log lines are
+// written through it, so they scroll above the bars instead of being overwritten.
+type progressBars struct {
+	mu     sync.Mutex
+	meter  *r2sync.TransferMeter
+	lines  int // lines of the bars currently drawn
+	width  int
+	stop   chan struct{}
+	closed sync.WaitGroup
+}
+
+// startProgressBars redraws the bars of meter twice a second until Close
+func startProgressBars(meter *r2sync.TransferMeter) *progressBars {
+	width, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	if width <= 0 {
+		width = 80
+	}
+	bars := &progressBars{meter: meter, width: width, stop: make(chan struct{})}
+	log.SetOutput(bars)
+	bars.closed.Add(1)
+	go func() {
+		defer bars.closed.Done()
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-bars.stop:
+				return
+			case <-ticker.C:
+				bars.mu.Lock()
+				bars.clear()
+				bars.draw()
+				bars.mu.Unlock()
+			}
+		}
+	}()
+	return bars
+}
+
+// Write writes a log line above the bars
+func (b *progressBars) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	n, err := os.Stderr.Write(p)
+	b.draw()
+	return n, err
+}
+
+// clear moves the cursor to the first line of the bars and erases them
+func (b *progressBars) clear() {
+	if b.lines > 0 {
+		os.Stderr.WriteString("\033[" + strconv.Itoa(b.lines) + "A\r\033[J")
+		b.lines = 0
+	}
+}
+
+func (b *progressBars) draw() {
+	var out strings.Builder
+	lines := b.meter.Status().Lines(maxProgressFiles)
+	for _, line := range lines {
+		// a wrapped line would throw off the cursor movement of clear
+		if len(line) >= b.width {
+			line = line[:b.width-1]
+		}
+		out.WriteString(line + "\n")
+	}
+	os.Stderr.WriteString(out.String())
+	b.lines = len(lines)
+}
+
+// Close erases the bars and restores the log output
+func (b *progressBars) Close() {
+	close(b.stop)
+	b.closed.Wait()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	log.SetOutput(os.Stderr)
+}
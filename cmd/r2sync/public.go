@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gofika/r2sync"
+)
+
+func publicUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: r2sync public get|on|off [--account-id ID] [--dryrun] <bucket url>
+
+Shows or toggles public access of an R2 bucket through its r2.dev domain using the
+Cloudflare API. The API token is read from the CLOUDFLARE_API_TOKEN environment variable
+and needs the "Workers R2 Storage: Edit" permission.
+
+Options:
+  --account-id (string)
+    	Cloudflare account id, defaults to the CLOUDFLARE_ACCOUNT_ID environment variable
+  --dryrun (boolean)
+    	Only display the operations to be performed, without actually executing them
+
+Examples:
+    r2sync public get r2://bucket
+    r2sync public on --account-id 0123456789abcdef r2://bucket`)
+}
+
+func runPublic(args []string) {
+	if len(args) == 0 {
+		publicUsage()
+		os.Exit(1)
+	}
+	action := args[0]
+	flags := flag.NewFlagSet("public", flag.ExitOnError)
+	flags.Usage = publicUsage
+	accountID := flags.String("account-id", os.Getenv("CLOUDFLARE_ACCOUNT_ID"), "Cloudflare account id")
+	dryRun := flags.Bool("dryrun", false, "Only display the operations to be performed, without actually executing them")
+	flags.Parse(args[1:])
+
+	if flags.NArg() != 1 {
+		publicUsage()
+		os.Exit(1)
+	}
+	_, bucket, _, err := r2sync.ParseRemoteURL(flags.Arg(0))
+	if err != nil {
+		fmt.Println("Invalid bucket path: ", err)
+		fmt.Println()
+		publicUsage()
+		os.Exit(1)
+	}
+	token := os.Getenv("CLOUDFLARE_API_TOKEN")
+	if *accountID == "" || token == "" {
+		fmt.Println("--account-id (or CLOUDFLARE_ACCOUNT_ID) and CLOUDFLARE_API_TOKEN are required")
+		os.Exit(1)
+	}
+
+	client := r2sync.NewCloudflareClient(*accountID, token)
+	var domain *r2sync.ManagedDomain
+	switch action {
+	case "get":
+		domain, err = client.ManagedDomain(bucket)
+	case "on", "off":
+		domain, err = client.SetManagedDomain(bucket, action == "on", *dryRun)
+	default:
+		publicUsage()
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if domain.Domain != "" {
+		fmt.Printf("public access: %s, https://%s\n", onOff(domain.Enabled), domain.Domain)
+	} else {
+		fmt.Printf("public access: %s\n", onOff(domain.Enabled))
+	}
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
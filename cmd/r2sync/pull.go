@@ -0,0 +1,8 @@
+package main
+
+import "strings"
+
+// pullSource reports whether the arguments name a remote source and a local destination
+func pullSource(source, target string) bool {
+	return strings.Contains(source, "://") && !strings.Contains(target, "://")
+}
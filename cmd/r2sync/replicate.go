@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gofika/r2sync"
+)
+
+func replicateUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: r2sync replicate [--repair] [--delete] [--allow-empty-source] [--dryrun] [--concurrency N] [--size-only] [--legal-holds] [--preserve-attributes] [--request-payer requester] <source url> <target url>
+Options:
+  --allow-empty-source (boolean)
+    	With --repair --delete, allow deleting from the target when the source has no objects, which
+    	otherwise is refused because it usually means a wrong source path
+  --concurrency (number)
+    	Number of concurrent copy/delete operations, default is 5
+  --delete (boolean)
+    	With --repair, delete objects that exist in the target but not in the source
+  --dryrun (boolean)
+    	Only display the operations to be performed, without actually executing them
+  --legal-holds (boolean)
+    	Also compare the Object Lock legal hold status of objects present in both locations
+  --preserve-attributes (boolean)
+    	With --repair, read the user metadata, content headers like Cache-Control and Content-Type, tags
+    	and ACL of every copied source object and reapply them on the target copy, tags and ACLs only
+    	between s3:// buckets, costs up to three extra requests per object
+  --repair (boolean)
+    	Copy missing or changed objects from the source to the target with server-side copies
+  --request-payer (requester)
+    	Accept the request charges of requester-pays source buckets, e.g. public datasets on AWS S3
+  --size-only (boolean)
+    	Only use object size to determine if objects are the same
+
+Exits with status 1 when divergence is found and --repair is not given.
+
+Examples:
+    r2sync replicate r2://bucket/path/ r2://backup-bucket/path/
+    r2sync replicate --repair --delete --dryrun r2://bucket/path/ r2://backup-bucket/path/`)
+}
+
+func runReplicate(args []string) {
+	flags := flag.NewFlagSet("replicate", flag.ExitOnError)
+	flags.Usage = replicateUsage
+	repair := flags.Bool("repair", false, "Copy missing or changed objects from the source to the target with server-side copies")
+	deleteExtra := flags.Bool("delete", false, "With --repair, delete objects that exist in the target but not in the source")
+	allowEmptySource := flags.Bool("allow-empty-source", false, "Allow --delete when the source has no objects")
+	dryRun := flags.Bool("dryrun", false, "Only display the operations to be performed, without actually executing them")
+	concurrency := flags.Int("concurrency", 5, "Number of concurrent copy/delete operations")
+	sizeOnly := flags.Bool("size-only", false, "Only use object size to determine if objects are the same")
+	checkHolds := flags.Bool("legal-holds", false, "Also compare the Object Lock legal hold status of objects present in both locations")
+	preserve := flags.Bool("preserve-attributes", false, "With --repair, reapply source metadata, content headers, tags and ACLs on copies")
+	payer := flags.String("request-payer", "", "Set to requester to read from requester-pays source buckets")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		replicateUsage()
+		os.Exit(1)
+	}
+	_, _, sourcePrefix, err := r2sync.ParseRemoteURL(flags.Arg(0))
+	if err != nil {
+		fmt.Println("Invalid source path: ", err)
+		fmt.Println()
+		replicateUsage()
+		os.Exit(1)
+	}
+	_, _, targetPrefix, err := r2sync.ParseRemoteURL(flags.Arg(1))
+	if err != nil {
+		fmt.Println("Invalid target path: ", err)
+		fmt.Println()
+		replicateUsage()
+		os.Exit(1)
+	}
+
+	payerOpt, err := r2sync.RequestPayer(*payer)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// copies from a requester-pays source need the header on the target request too
+	source, err := r2sync.NewClient(context.Background(), r2sync.WithR2Env(), payerOpt, r2sync.WithRemoteURL(flags.Arg(0)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	target, err := r2sync.NewClient(context.Background(), r2sync.WithR2Env(), payerOpt, r2sync.WithRemoteURL(flags.Arg(1)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := r2sync.SyncOptions{
+		Delete:           *deleteExtra,
+		DryRun:           *dryRun,
+		Recursive:        true,
+		Concurrency:      *concurrency,
+		SizeOnly:         *sizeOnly,
+		AllowEmptySource: *allowEmptySource,
+	}
+	divergent, err := target.Replicate(context.Background(), source, sourcePrefix, targetPrefix, *repair, *checkHolds, *preserve, opts)
+	if errors.Is(err, r2sync.ErrPartialFailure) {
+		log.Println(err)
+		os.Exit(5)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if divergent > 0 && !*repair {
+		os.Exit(1)
+	}
+	log.Println("Replicate completed.")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// interactiveStdin reports whether stdin is a terminal a confirmation can be read from
+func interactiveStdin() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmRootDelete asks on the terminal whether --delete may remove objects anywhere in the
+// buckets of the root urls, and reports whether the answer was yes. Without a terminal nothing is
+// asked and it reports false.
+func confirmRootDelete(roots []string) bool {
+	if !interactiveStdin() || !interactiveStderr() {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "--delete removes every object not in the source from the whole bucket of %s\n", strings.Join(roots, ", "))
+	fmt.Fprint(os.Stderr, "Type yes to continue: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
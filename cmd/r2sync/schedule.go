@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofika/r2sync"
+)
+
+// cronSchedule is a parsed standard 5-field cron expression (minute hour day-of-month month day-of-week)
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+var cronFieldRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// parseCronField parses one field such as *, */15, 1-5, 1,15 or 0-30/10 into a bitset
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, expected 5 fields", expr)
+	}
+	var bits [5]uint64
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFieldRanges[i][0], cronFieldRanges[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", expr, err)
+		}
+		bits[i] = b
+	}
+	// both 0 and 7 mean sunday
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &cronSchedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	// like cron, a restricted day-of-month and day-of-week match either
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first matching time strictly after t
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return limit
+}
+
+// runSchedule runs fn on every tick of the schedule, delayed by a random jitter, until drain is closed.
+// Runs never overlap: ticks that pass while fn is still running are skipped. When control is set
+// the next run time is reported to it and its trigger starts a sync immediately.
+func runSchedule(drain <-chan struct{}, schedule *cronSchedule, jitter time.Duration, control *daemonState, fn func() error) {
+	startSystemd()
+	for !r2sync.Draining(drain) {
+		next := schedule.Next(time.Now())
+		delay := time.Until(next)
+		if jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(jitter)))
+		}
+		nextRun := time.Now().Add(delay).Format(time.RFC3339)
+		log.Printf("Next sync at %s\n", nextRun)
+		sdNotify("STATUS=Next sync at " + nextRun)
+		control.SetNextRun(time.Now().Add(delay))
+		sdWatchdog.Idle()
+		select {
+		case <-drain:
+			return
+		case <-time.After(delay):
+		case <-control.Trigger():
+			log.Println("Sync triggered via control API.")
+			next = time.Now()
+		}
+		sdWatchdog.Touch()
+		sdNotify("STATUS=Syncing")
+
+		if err := fn(); err != nil {
+			log.Printf("sync failed: %v\n", err)
+		}
+
+		skipped := 0
+		for tick := schedule.Next(next); tick.Before(time.Now()); tick = schedule.Next(tick) {
+			skipped++
+		}
+		if skipped > 0 {
+			log.Printf("%d scheduled syncs skipped, previous sync still running.\n", skipped)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// interruptExitCode is the exit status for the received signal, 130 for SIGINT and 143 for SIGTERM
+var interruptExitCode atomic.Int32
+
+// handleInterrupts escalates SIGINT: the first one closes drain so no new transfers are scheduled
+// while in-flight ones finish, the second cancels ctx which aborts in-flight transfers, and the
+// third exits immediately. SIGTERM also closes drain, then cancels ctx once the grace period
+// is over, so containers shut down within their termination grace period.
+func handleInterrupts(grace time.Duration) (ctx context.Context, drain <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	drainCh := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		close(drainCh)
+		if sig == syscall.SIGTERM {
+			interruptExitCode.Store(143)
+			log.Printf("Terminated, finishing in-flight transfers within %s.\n", grace)
+			time.AfterFunc(grace, func() {
+				log.Println("Shutdown grace period over, aborting in-flight transfers.")
+				cancel()
+			})
+		} else {
+			interruptExitCode.Store(130)
+			log.Println("Interrupted, finishing in-flight transfers. Press Ctrl-C again to abort them.")
+		}
+		<-signals
+		log.Println("Interrupted again, aborting in-flight transfers.")
+		cancel()
+		<-signals
+		os.Exit(int(interruptExitCode.Load()))
+	}()
+	return ctx, drainCh
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sdNotify sends a state such as READY=1 to the systemd notify socket, it is a no-op outside systemd
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// abstract namespace sockets are passed with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdog pings the systemd watchdog only while the sync loop makes progress,
+// so systemd restarts the process when the loop wedges
+type watchdog struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	last    time.Time
+	idle    bool
+}
+
+// sdWatchdog is nil unless systemd enabled the watchdog for this process
+var sdWatchdog *watchdog
+
+// Touch records progress of the sync loop
+func (w *watchdog) Touch() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.last = time.Now()
+	w.idle = false
+	w.mu.Unlock()
+}
+
+// Idle marks the loop as intentionally waiting, e.g. for the next scheduled sync
+func (w *watchdog) Idle() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.idle = true
+	w.mu.Unlock()
+}
+
+func (w *watchdog) healthy() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.idle || time.Since(w.last) < w.timeout
+}
+
+func (w *watchdog) run() {
+	ticker := time.NewTicker(w.timeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !w.healthy() {
+			log.Printf("sync loop made no progress for %s, stopping watchdog pings\n", w.timeout)
+			continue
+		}
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Printf("watchdog ping failed: %v\n", err)
+		}
+	}
+}
+
+// startSystemd signals readiness to systemd (Type=notify) and starts watchdog pings when WatchdogSec is set
+func startSystemd() {
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("systemd notify failed: %v\n", err)
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	sdWatchdog = &watchdog{timeout: time.Duration(usec) * time.Microsecond, last: time.Now()}
+	go sdWatchdog.run()
+}
+
+// heartbeat records progress of a sync, event or replication loop, it is the Heartbeat of every
+// client
+func heartbeat() {
+	sdWatchdog.Touch()
+}
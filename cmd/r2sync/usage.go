@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gofika/r2sync"
+)
+
+func usageCommandUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: r2sync usage [--depth N] [--top N] [--compare FILE] [--save FILE] <bucket url>
+
+Reports the number of objects and bytes under the bucket url, by prefix and by storage class.
+Saving a snapshot and comparing a later run against it shows the growth in between.
+
+Options:
+  --compare (file)
+    	Snapshot saved by an earlier run to show the growth since, e.g. usage-2026-10.json
+  --depth (number)
+    	Path segments below the url that group objects by prefix, default is 1
+  --save (file)
+    	Write the snapshot as JSON to this file
+  --top (number)
+    	Number of largest prefixes listed, the rest are summed up, 0 lists all, default is 20
+
+Examples:
+    r2sync usage r2://bucket
+    r2sync usage --depth 2 --save usage.json r2://bucket/backups/
+    r2sync usage --compare usage.json r2://bucket/backups/`)
+}
+
+func runUsage(args []string) {
+	flags := flag.NewFlagSet("usage", flag.ExitOnError)
+	flags.Usage = usageCommandUsage
+	depth := flags.Int("depth", 1, "Path segments below the url that group objects by prefix")
+	top := flags.Int("top", 20, "Number of largest prefixes listed, 0 lists all")
+	compare := flags.String("compare", "", "Snapshot saved by an earlier run to show the growth since")
+	save := flags.String("save", "", "Write the snapshot as JSON to this file")
+	positional := parseFlags(flags, args)
+
+	if len(positional) != 1 || *depth < 1 || *top < 0 {
+		usageCommandUsage()
+		os.Exit(1)
+	}
+	_, _, prefix, err := r2sync.ParseRemoteURL(positional[0])
+	if err != nil {
+		fmt.Println("Invalid bucket url: ", err)
+		fmt.Println()
+		usageCommandUsage()
+		os.Exit(1)
+	}
+	var previous *r2sync.UsageSnapshot
+	if *compare != "" {
+		if previous, err = r2sync.LoadUsageSnapshot(*compare); err != nil {
+			fmt.Println("Invalid --compare: ", err)
+			os.Exit(1)
+		}
+	}
+
+	client, err := r2sync.NewClient(context.Background(), r2sync.WithR2Env(), r2sync.WithRemoteURL(positional[0]))
+	if err != nil {
+		log.Fatal(err)
+	}
+	snapshot, err := client.Usage(context.Background(), prefix, *depth)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if previous != nil && previous.URL != snapshot.URL {
+		log.Printf("Comparing with a snapshot of %s.\n", previous.URL)
+	}
+	snapshot.Report(os.Stdout, previous, *top)
+	if *save != "" {
+		if err := snapshot.Save(*save); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package r2sync
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+)
+
+// metadata recorded on compressed objects
+const (
+	metaCompression = "r2sync-compression"
+	metaSize        = "r2sync-size" // size of the original file
+	metaMD5         = "r2sync-md5"  // md5 of the original file
+)
+
+func ParseCompression(algorithm string) (string, error) {
+	switch algorithm {
+	case "", "zstd", "gzip":
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("invalid compression: %s (valid values: zstd, gzip)", algorithm)
+	}
+}
+
+// compressFile writes the compressed contents of file to a temporary file and returns it
+// rewound, together with the md5 of the original contents. The caller removes the file.
+func compressFile(file io.Reader, algorithm string) (*os.File, string, error) {
+	temp, err := os.CreateTemp("", "r2sync-compress-*")
+	if err != nil {
+		return nil, "", err
+	}
+	var zw io.WriteCloser
+	if algorithm == "zstd" {
+		zw, err = zstd.NewWriter(temp)
+	} else {
+		zw = gzip.NewWriter(temp)
+	}
+	if err == nil {
+		sum := md5.New()
+		if _, err = io.Copy(zw, io.TeeReader(file, sum)); err == nil {
+			err = zw.Close()
+		}
+		if err == nil {
+			_, err = temp.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			return temp, hex.EncodeToString(sum.Sum(nil)), nil
+		}
+	}
+	temp.Close()
+	os.Remove(temp.Name())
+	return nil, "", err
+}
+
+// decompressed returns the original contents of a downloaded object, decompressing objects
+// uploaded with --compress
+func decompressed(resp *s3.GetObjectOutput) (io.ReadCloser, error) {
+	switch resp.Metadata[metaCompression] {
+	case "":
+		return resp.Body, nil
+	case "zstd":
+		decoder, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case "gzip":
+		return gzip.NewReader(resp.Body)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", resp.Metadata[metaCompression])
+	}
+}
+
+// CompressedChanged reports whether the compressed copy at key differs from the local file,
+// comparing the original size and md5 recorded in its metadata. etag is the md5 of the local
+// file, empty to compare sizes only.
+func (r *R2Client) CompressedChanged(ctx context.Context, key string, info os.FileInfo, etag string) (bool, error) {
+	resp, err := r.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return true, err
+	}
+	if resp.Metadata[metaCompression] != r.Compression {
+		return true, nil
+	}
+	if size, err := strconv.ParseInt(resp.Metadata[metaSize], 10, 64); err != nil || size != info.Size() {
+		return true, nil
+	}
+	return etag != "" && strings.Trim(etag, `"`) != resp.Metadata[metaMD5], nil
+}
@@ -0,0 +1,89 @@
+package r2sync
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"path"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	minAutoConcurrency = 2
+	MaxAutoConcurrency = 64
+	probeSize          = 8 << 20
+)
+
+// autoConcurrency derives the number of concurrent transfers when --concurrency isn't given: 4 per
+// CPU, doubled when most files are small since their transfers wait on latency, halved when most
+// are large since they compete for bandwidth, and capped when the probed upload rate is low
+func (r *R2Client) autoConcurrency(ctx context.Context, localPath, remotePath string, opts SyncOptions, rollups map[string]*dirRollup) int {
+	n := 4 * runtime.NumCPU()
+	if rollups == nil {
+		var err error
+		if rollups, err = localRollups(r.IOLimit, localPath, opts); err != nil {
+			log.Printf("failed to scan file sizes: %v\n", err)
+		}
+	}
+	var sizes []int64
+	for _, rollup := range rollups {
+		for _, file := range rollup.files {
+			sizes = append(sizes, file.size)
+		}
+	}
+	var median int64
+	if len(sizes) > 0 {
+		sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+		median = sizes[len(sizes)/2]
+	}
+	switch {
+	case median < 1<<20:
+		n *= 2
+	case median >= 64<<20:
+		n /= 2
+	}
+
+	rate := ""
+	if opts.ProbeBandwidth && !opts.DryRun {
+		bytesPerSecond, err := r.ProbeBandwidth(ctx, remotePath)
+		if err != nil {
+			log.Printf("bandwidth probe failed: %s\n", ErrorDetail(err))
+		} else {
+			rate = ", probed upload " + formatSpeed(bytesPerSecond)
+			switch {
+			case bytesPerSecond < 2<<20:
+				n = min(n, 4)
+			case bytesPerSecond < 12<<20:
+				n = min(n, 16)
+			}
+		}
+	}
+	n = min(max(n, minAutoConcurrency), MaxAutoConcurrency)
+	log.Printf("Concurrency: %d (%d CPUs, median file size %s%s)\n", n, runtime.NumCPU(), formatSize(median), rate)
+	return n
+}
+
+// ProbeBandwidth uploads and deletes a temporary object under remotePath and returns the upload rate
+func (r *R2Client) ProbeBandwidth(ctx context.Context, remotePath string) (float64, error) {
+	key := path.Join(remotePath, ".r2sync-probe")
+	startTime := time.Now()
+	_, err := r.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(r.bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(make([]byte, probeSize)),
+		ContentLength: aws.Int64(probeSize),
+	})
+	if err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(startTime).Seconds()
+	if err := r.DeleteObject(ctx, key, false); err != nil {
+		log.Printf("delete failed %s: %s\n", r.RemotePath(key), ErrorDetail(err))
+	}
+	return probeSize / elapsed, nil
+}
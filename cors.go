@@ -0,0 +1,52 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// GetCORS returns the bucket CORS configuration, a bucket without one returns no rules
+func (r *R2Client) GetCORS(ctx context.Context) (*types.CORSConfiguration, error) {
+	resp, err := r.client.GetBucketCors(ctx, &s3.GetBucketCorsInput{
+		Bucket: aws.String(r.bucket),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchCORSConfiguration" {
+			return &types.CORSConfiguration{}, nil
+		}
+		return nil, err
+	}
+	return &types.CORSConfiguration{CORSRules: resp.CORSRules}, nil
+}
+
+// PutCORS replaces the bucket CORS configuration, an empty rule list removes it
+func (r *R2Client) PutCORS(ctx context.Context, cors *types.CORSConfiguration, dryRun bool) error {
+	if dryRun {
+		log.Printf("(dryrun) put cors: %s, %d rules\n", r.RemotePath(""), len(cors.CORSRules))
+		return nil
+	}
+
+	var err error
+	if len(cors.CORSRules) == 0 {
+		_, err = r.client.DeleteBucketCors(ctx, &s3.DeleteBucketCorsInput{
+			Bucket: aws.String(r.bucket),
+		})
+	} else {
+		_, err = r.client.PutBucketCors(ctx, &s3.PutBucketCorsInput{
+			Bucket:            aws.String(r.bucket),
+			CORSConfiguration: cors,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("put cors: %s, %d rules\n", r.RemotePath(""), len(cors.CORSRules))
+	return nil
+}
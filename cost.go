@@ -0,0 +1,111 @@
+package r2sync
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Pricing are the rates the cost of operations is estimated with, in USD
+type Pricing struct {
+	ClassA  float64 // per million Class A requests like PUT, COPY and LIST
+	ClassB  float64 // per million Class B requests like GET and HEAD
+	Egress  float64 // per GB (10^9 bytes) downloaded
+	Storage float64 // per GB stored for a month
+}
+
+// R2Pricing are the rates of R2 standard storage, egress is free
+var R2Pricing = Pricing{ClassA: 4.50, ClassB: 0.36, Storage: 0.015}
+
+// S3Pricing are the rates of S3 Standard in us-east-1, egress to the internet in the first tier
+var S3Pricing = Pricing{ClassA: 5.00, ClassB: 0.40, Egress: 0.09, Storage: 0.023}
+
+// pricing returns pricing, or the default rates of the client scheme when it is nil
+func (r *R2Client) pricing(pricing *Pricing) Pricing {
+	switch {
+	case pricing != nil:
+		return *pricing
+	case r.scheme == "s3":
+		return S3Pricing
+	default:
+		return R2Pricing
+	}
+}
+
+// ParsePricing parses comma separated rates like "s3" or "r2,egress=0.01,storage=0.02". The
+// models r2 and s3 replace base, the rates class-a, class-b, egress and storage override it.
+func ParsePricing(spec string, base Pricing) (Pricing, error) {
+	pricing := base
+	for _, field := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			switch name {
+			case "r2":
+				pricing = R2Pricing
+			case "s3":
+				pricing = S3Pricing
+			default:
+				return pricing, fmt.Errorf("unknown pricing model %q, expected r2 or s3", name)
+			}
+			continue
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 {
+			return pricing, fmt.Errorf("invalid rate %q of %s", value, name)
+		}
+		switch name {
+		case "class-a":
+			pricing.ClassA = rate
+		case "class-b":
+			pricing.ClassB = rate
+		case "egress":
+			pricing.Egress = rate
+		case "storage":
+			pricing.Storage = rate
+		default:
+			return pricing, fmt.Errorf("unknown rate %q, expected class-a, class-b, egress or storage", name)
+		}
+	}
+	return pricing, nil
+}
+
+// cost returns the estimated cost of the requests and egress of an operation at pricing
+func (o Operation) cost(pricing Pricing) float64 {
+	cost := float64(o.ClassA)*pricing.ClassA/1e6 + float64(o.ClassB)*pricing.ClassB/1e6
+	if o.Action == "download" {
+		cost += float64(o.Size) * pricing.Egress / 1e9
+	}
+	return cost
+}
+
+// CostEstimate sums the planned operations of a sync
+type CostEstimate struct {
+	ClassA       int     // Class A requests
+	ClassB       int     // Class B requests
+	Egress       int64   // bytes downloaded
+	StorageDelta int64   // bytes the stored objects grow by, negative when they shrink
+	Cost         float64 // USD of the requests and egress
+	StorageCost  float64 // USD per month the storage bill changes by
+}
+
+func (e *CostEstimate) add(operation Operation, pricing Pricing) {
+	e.ClassA += operation.ClassA
+	e.ClassB += operation.ClassB
+	if operation.Action == "download" {
+		e.Egress += operation.Size
+	}
+	e.StorageDelta += operation.StorageDelta
+	e.Cost += operation.EstimatedCost
+	e.StorageCost += float64(operation.StorageDelta) * pricing.Storage / 1e9
+}
+
+// Log prints the estimate as part of the dry-run summary
+func (e CostEstimate) Log() {
+	delta := "+" + formatSize(e.StorageDelta)
+	if e.StorageDelta < 0 {
+		delta = "-" + formatSize(-e.StorageDelta)
+	}
+	log.Printf("Estimated cost: $%.4f for %d Class A and %d Class B requests and %s egress, storage %s ($%+.4f per month)\n",
+		e.Cost, e.ClassA, e.ClassB, formatSize(e.Egress), delta, e.StorageCost)
+}
@@ -0,0 +1,103 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// CredentialOptions selects where credentials come from
+type CredentialOptions struct {
+	Profile   string // shared config profile, defaults to AWS_PROFILE
+	MFASerial string // MFA device serial or ARN
+	MFAToken  string // MFA token code, prompted on stdin when empty
+	Command   string // prints credentials in the credential_process JSON format, overrides the profile
+}
+
+func (c CredentialOptions) tokenProvider() func() (string, error) {
+	if c.MFAToken != "" {
+		return func() (string, error) { return c.MFAToken, nil }
+	}
+	return stscreds.StdinTokenProvider
+}
+
+// LoadCredentials resolves credentials of the profile once up front, so MFA prompts and expired
+// SSO sessions surface before any transfer starts. Profiles with role_arn assume the role with the
+// MFA device, other profiles with an MFA device get session credentials from sts GetSessionToken.
+// A credentials command is run again when the temporary keys it printed expire.
+func LoadCredentials(ctx context.Context, c CredentialOptions) (func(*s3.Options), error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if c.Profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(c.Profile))
+	}
+	loadOpts = append(loadOpts, config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+		if c.MFASerial != "" {
+			o.SerialNumber = aws.String(c.MFASerial)
+		}
+		o.TokenProvider = c.tokenProvider()
+	}))
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := c.Profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	assumesRole := false
+	if profile != "" {
+		shared, err := config.LoadSharedConfigProfile(ctx, profile)
+		if err != nil {
+			return nil, err
+		}
+		assumesRole = shared.RoleARN != ""
+	}
+	switch {
+	case c.Command != "":
+		cfg.Credentials = aws.NewCredentialsCache(processcreds.NewProvider(c.Command))
+	case c.MFASerial != "" && !assumesRole:
+		token, err := c.tokenProvider()()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := sts.NewFromConfig(cfg).GetSessionToken(ctx, &sts.GetSessionTokenInput{
+			SerialNumber: aws.String(c.MFASerial),
+			TokenCode:    aws.String(token),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get MFA session token: %v", err)
+		}
+		cfg.Credentials = aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(
+			aws.ToString(resp.Credentials.AccessKeyId),
+			aws.ToString(resp.Credentials.SecretAccessKey),
+			aws.ToString(resp.Credentials.SessionToken),
+		))
+	}
+
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		var tokenErr *ssocreds.InvalidTokenError
+		if errors.As(err, &tokenErr) {
+			return nil, fmt.Errorf("SSO session expired, run: aws sso login --profile %s", profile)
+		}
+		return nil, fmt.Errorf("failed to load credentials: %v", err)
+	}
+
+	return func(o *s3.Options) {
+		o.Credentials = cfg.Credentials
+		o.Region = cfg.Region
+		if cfg.BaseEndpoint != nil {
+			o.BaseEndpoint = cfg.BaseEndpoint
+		}
+	}, nil
+}
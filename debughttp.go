@@ -0,0 +1,88 @@
+package r2sync
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// redactedHeaders and redactedQuery carry credentials or signatures
+var (
+	redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Amz-Security-Token", "X-Amz-Server-Side-Encryption-Customer-Key"}
+	redactedQuery   = []string{"X-Amz-Credential", "X-Amz-Security-Token", "X-Amz-Signature"}
+)
+
+// debugHTTPClient logs every signed request and its response with secrets redacted
+type debugHTTPClient struct {
+	next s3.HTTPClient
+}
+
+func redactURL(u *url.URL) string {
+	redacted := *u
+	query := redacted.Query()
+	for key := range query {
+		if slices.ContainsFunc(redactedQuery, func(name string) bool { return strings.EqualFold(name, key) }) {
+			query.Set(key, "REDACTED")
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+func logHeaders(header http.Header) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		value := strings.Join(header[key], ", ")
+		if slices.Contains(redactedHeaders, http.CanonicalHeaderKey(key)) {
+			if key == "Authorization" {
+				// keep the algorithm and signed header list, they matter for signature problems
+				if i := strings.Index(value, "Signature="); i >= 0 {
+					value = value[:i] + "Signature=REDACTED"
+				}
+				if i := strings.Index(value, "Credential="); i >= 0 {
+					end := strings.Index(value[i:], "/")
+					if end > 0 {
+						value = value[:i] + "Credential=REDACTED" + value[i+end:]
+					}
+				}
+			} else {
+				value = "REDACTED"
+			}
+		}
+		log.Printf("  %s: %s\n", key, value)
+	}
+}
+
+func (c debugHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	log.Printf("http request: %s %s\n", req.Method, redactURL(req.URL))
+	logHeaders(req.Header)
+	startTime := time.Now()
+	resp, err := c.next.Do(req)
+	if err != nil {
+		log.Printf("http error: %s %s: %v\n", req.Method, redactURL(req.URL), err)
+		return resp, err
+	}
+	log.Printf("http response: %s %s -> %s in %s\n", req.Method, redactURL(req.URL), resp.Status, time.Since(startTime).Round(time.Millisecond))
+	logHeaders(resp.Header)
+	return resp, nil
+}
+
+// WithDebugHTTP wraps the http client of an s3 client with request/response tracing
+func WithDebugHTTP() S3Option {
+	return func(o *s3.Options) {
+		next := o.HTTPClient
+		if next == nil {
+			next = http.DefaultClient
+		}
+		o.HTTPClient = debugHTTPClient{next: next}
+	}
+}
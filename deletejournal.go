@@ -0,0 +1,129 @@
+package r2sync
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// deleteJournal is an append-only log of the deletes of a sync, kept next to the checkpoint. Every
+// delete is recorded with the object version it was planned for before it is sent and marked done
+// after, so a run resumed after a crash never re-issues a delete for a key that a concurrent
+// writer re-created in between.
+type deleteJournal struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries map[string]journalEntry // by remote url
+}
+
+type journalEntry struct {
+	Key          string    `json:"key"` // remote url
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+	Done         bool      `json:"done,omitempty"`
+}
+
+func deleteJournalPath(checkpointPath string) string {
+	return checkpointPath + ".deletes"
+}
+
+// openDeleteJournal reads the entries left by an earlier run at path and opens it for appending,
+// a line torn by a crash is ignored
+func openDeleteJournal(path string) (*deleteJournal, error) {
+	j := &deleteJournal{path: path, entries: make(map[string]journalEntry)}
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		for scanner.Scan() {
+			var entry journalEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+				j.entries[entry.Key] = entry
+			}
+		}
+		existing.Close()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j.file = file
+	return j, nil
+}
+
+// Allow reports whether the object at key may be deleted. An object whose delete an earlier run
+// completed, or whose version changed since an earlier run planned to delete it, was re-created
+// by another writer.
+func (j *deleteJournal) Allow(key string, object FileInfo) bool {
+	if j == nil {
+		return true
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.entries[key]
+	if !ok {
+		return true
+	}
+	return !entry.Done && entry.ETag == object.ETag && entry.LastModified.Equal(object.LastModified)
+}
+
+// Begin durably records the intent to delete the objects at keys before the request deleting them
+// is sent
+func (j *deleteJournal) Begin(keys []string, objects []FileInfo) error {
+	if j == nil {
+		return nil
+	}
+	for i, key := range keys {
+		if err := j.append(journalEntry{Key: key, ETag: objects[i].ETag, LastModified: objects[i].LastModified}, false); err != nil {
+			return err
+		}
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Sync()
+}
+
+// Done records that the delete succeeded
+func (j *deleteJournal) Done(key string, object FileInfo) error {
+	return j.append(journalEntry{Key: key, ETag: object.ETag, LastModified: object.LastModified, Done: true}, false)
+}
+
+func (j *deleteJournal) append(entry journalEntry, durable bool) error {
+	if j == nil {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries[entry.Key] = entry
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if durable {
+		return j.file.Sync()
+	}
+	return nil
+}
+
+// Close closes the journal, keeping it for the next run when remove is false
+func (j *deleteJournal) Close(remove bool) error {
+	if j == nil {
+		return nil
+	}
+	err := j.file.Close()
+	if remove {
+		if removeErr := os.Remove(j.path); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			return removeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,319 @@
+package r2sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const dirManifestDir = ".r2sync-dirs"
+
+// dirRollup summarizes the files of a local directory subtree. It is stored remotely after a
+// sync, a matching hash means the remote copy of the subtree is up to date.
+type dirRollup struct {
+	Count int64  `json:"count"`
+	Bytes int64  `json:"bytes"`
+	Hash  string `json:"hash"` // over names, sizes and modification times of files and child hashes
+
+	files []rollupFile // files directly inside
+	dirs  []string     // names of child directories
+}
+
+type rollupFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func dirManifestKey(remotePath, relDir string) string {
+	return path.Join(remotePath, dirManifestDir, relDir, "manifest.json")
+}
+
+// isDirManifestKey reports whether key is a directory manifest of the tree under remotePath
+func isDirManifestKey(remotePath, key string) bool {
+	return strings.HasPrefix(key, path.Join(remotePath, dirManifestDir)+"/")
+}
+
+// dirPrefix is the key prefix of the objects inside a directory relative to remotePath
+func dirPrefix(remotePath, relDir string) string {
+	if prefix := path.Join(remotePath, relDir); prefix != "" {
+		return prefix + "/"
+	}
+	return ""
+}
+
+// parentDir returns the directory of a path relative to the local root, "" for the root itself
+func parentDir(relPath string) string {
+	if dir := path.Dir(relPath); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// localRollups walks localPath with the exclude and recursion rules of Sync and returns the rollup
+// of every directory by its path relative to localPath, "" is the root
+func localRollups(limit *IOLimiter, localPath string, opts SyncOptions) (map[string]*dirRollup, error) {
+	rollups := map[string]*dirRollup{"": {}}
+	var addDir func(relDir string) *dirRollup
+	addDir = func(relDir string) *dirRollup {
+		if rollup, ok := rollups[relDir]; ok {
+			return rollup
+		}
+		rollup := &dirRollup{}
+		rollups[relDir] = rollup
+		parent := addDir(parentDir(relDir))
+		parent.dirs = append(parent.dirs, path.Base(relDir))
+		return rollup
+	}
+	err := filepath.Walk(localPath, func(fullpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		fullpath = NormalizePath(fullpath)
+		if filterReason(localPath, fullpath, info.IsDir(), opts) != "" {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !opts.Recursive && path.Dir(fullpath) != localPath {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relPath, _ := filepath.Rel(localPath, fullpath)
+		relPath = NormalizePath(relPath)
+		if info.IsDir() {
+			if relPath != "." {
+				addDir(relPath)
+			}
+			return nil
+		}
+		if excluded, err := mimeExcluded(limit, fullpath, opts); err != nil || excluded {
+			return err
+		}
+		rollup := addDir(parentDir(relPath))
+		rollup.files = append(rollup.files, rollupFile{name: path.Base(relPath), size: info.Size(), modTime: info.ModTime()})
+		rollup.Count++
+		rollup.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// children before their parents
+	dirs := make([]string, 0, len(rollups))
+	for relDir := range rollups {
+		dirs = append(dirs, relDir)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, relDir := range dirs {
+		rollup := rollups[relDir]
+		sort.Slice(rollup.files, func(i, j int) bool { return rollup.files[i].name < rollup.files[j].name })
+		sort.Strings(rollup.dirs)
+		hash := sha256.New()
+		for _, file := range rollup.files {
+			fmt.Fprintf(hash, "f %s\x00%d\x00%d\n", file.name, file.size, file.modTime.UnixNano())
+		}
+		for _, name := range rollup.dirs {
+			child := rollups[path.Join(relDir, name)]
+			fmt.Fprintf(hash, "d %s %s\n", name, child.Hash)
+			rollup.Count += child.Count
+			rollup.Bytes += child.Bytes
+		}
+		rollup.Hash = hex.EncodeToString(hash.Sum(nil))
+	}
+	return rollups, nil
+}
+
+func (r *R2Client) loadDirManifest(ctx context.Context, key string) (*dirRollup, error) {
+	resp, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rollup := &dirRollup{}
+	if err := json.NewDecoder(resp.Body).Decode(rollup); err != nil {
+		return nil, fmt.Errorf("invalid directory manifest %s: %v", r.RemotePath(key), err)
+	}
+	return rollup, nil
+}
+
+// compareDirManifests fetches the remote directory manifests top-down and returns the directories
+// whose subtree matches the local rollup and the directories that changed. Children of unchanged
+// directories are not fetched.
+func (r *R2Client) compareDirManifests(ctx context.Context, remotePath string, rollups map[string]*dirRollup, concurrency int) (unchanged map[string]bool, changed []string, err error) {
+	unchanged = make(map[string]bool)
+	level := []string{""}
+	for len(level) > 0 {
+		var next []string
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		semaphore := make(chan struct{}, concurrency)
+		for _, relDir := range level {
+			wg.Add(1)
+			semaphore <- struct{}{}
+			go func(relDir string) {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+				remote, fetchErr := r.loadDirManifest(ctx, dirManifestKey(remotePath, relDir))
+				mu.Lock()
+				defer mu.Unlock()
+				if fetchErr != nil && err == nil {
+					err = fetchErr
+				}
+				local := rollups[relDir]
+				if remote != nil && remote.Hash == local.Hash {
+					unchanged[relDir] = true
+					return
+				}
+				changed = append(changed, relDir)
+				for _, name := range local.dirs {
+					next = append(next, path.Join(relDir, name))
+				}
+			}(relDir)
+		}
+		wg.Wait()
+		if err != nil {
+			return nil, nil, err
+		}
+		level = next
+	}
+	return unchanged, changed, nil
+}
+
+// listDirectory lists the objects directly inside prefix and the prefixes of its subdirectories
+func (r *R2Client) listDirectory(ctx context.Context, prefix string) (map[string]FileInfo, []string, error) {
+	files := make(map[string]FileInfo)
+	var subdirs []string
+	paginator := s3.NewListObjectsV2Paginator(r.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(r.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, obj := range resp.Contents {
+			files[*obj.Key] = FileInfo{
+				Path:         *obj.Key,
+				Size:         *obj.Size,
+				LastModified: *obj.LastModified,
+				ETag:         *obj.ETag,
+			}
+		}
+		for _, common := range resp.CommonPrefixes {
+			subdirs = append(subdirs, aws.ToString(common.Prefix))
+		}
+	}
+	return files, subdirs, nil
+}
+
+// listChangedDirs lists the objects directly inside every changed directory and everything under
+// remote directories of changed directories that don't exist locally
+func (r *R2Client) listChangedDirs(ctx context.Context, remotePath string, rollups map[string]*dirRollup, changed []string) (map[string]FileInfo, error) {
+	result := make(map[string]FileInfo)
+	for _, relDir := range changed {
+		files, subdirs, err := r.listDirectory(ctx, dirPrefix(remotePath, relDir))
+		if err != nil {
+			return nil, err
+		}
+		for key, info := range files {
+			result[key] = info
+		}
+		for _, subdir := range subdirs {
+			relSubdir := path.Join(relDir, path.Base(subdir))
+			if _, local := rollups[relSubdir]; local || isDirManifestKey(remotePath, subdir) {
+				continue
+			}
+			remoteOnly, err := r.ListObjects(ctx, subdir)
+			if err != nil {
+				return nil, err
+			}
+			for key, info := range remoteOnly {
+				result[key] = info
+			}
+		}
+	}
+	return result, nil
+}
+
+// putDirManifests stores the rollups of the changed directories
+func (r *R2Client) putDirManifests(ctx context.Context, remotePath string, rollups map[string]*dirRollup, changed []string, concurrency int) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	semaphore := make(chan struct{}, concurrency)
+	for _, relDir := range changed {
+		body, err := json.Marshal(rollups[relDir])
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			_, err := r.client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket:        aws.String(r.bucket),
+				Key:           aws.String(key),
+				Body:          bytes.NewReader(body),
+				ContentLength: aws.Int64(int64(len(body))),
+				ContentType:   aws.String("application/json"),
+			})
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(dirManifestKey(remotePath, relDir))
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// skipsDir reports whether relDir or one of its ancestors is unchanged
+func skipsDir(unchanged map[string]bool, relDir string) bool {
+	for {
+		if unchanged[relDir] {
+			return true
+		}
+		if relDir == "" {
+			return false
+		}
+		relDir = parentDir(relDir)
+	}
+}
+
+// skipsDir reports whether relDir or one of its ancestors is unchanged on the target
+func (t *syncTarget) skipsDir(relDir string) bool {
+	return skipsDir(t.unchanged, relDir)
+}
@@ -0,0 +1,51 @@
+package r2sync
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// IOLimiter bounds the local files read at the same time, so spinning disks and network mounts
+// stream a few files one after another instead of seeking between every transfer. Clients reading
+// the same disk share one limiter.
+type IOLimiter struct {
+	slots chan struct{}
+}
+
+// NewIOLimiter returns a limiter of n files read at the same time, nil when n is 0 which doesn't
+// limit
+func NewIOLimiter(n int) *IOLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &IOLimiter{slots: make(chan struct{}, n)}
+}
+
+// localFile is a local file that holds a slot of its limiter from open to close
+type localFile struct {
+	*os.File
+	release func()
+}
+
+// open waits for a slot and opens the file, a nil limiter opens it right away
+func (l *IOLimiter) open(name string) (*localFile, error) {
+	release := func() {}
+	if l != nil {
+		l.slots <- struct{}{}
+		release = sync.OnceFunc(func() { <-l.slots })
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &localFile{File: file, release: release}, nil
+}
+
+func (f *localFile) Close() error {
+	defer f.release()
+	return f.File.Close()
+}
+
+var _ io.ReadSeekCloser = (*localFile)(nil)
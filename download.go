@@ -0,0 +1,232 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// localPathFor maps a key relative to the remote prefix to a path inside localDir. Keys with ..
+// segments or absolute-looking keys are sanitized, or refused when strict, and paths through
+// symlinked directories that resolve outside localDir are always refused.
+func localPathFor(localDir, relKey string, strict bool) (string, error) {
+	rel := filepath.FromSlash(relKey)
+	if !filepath.IsLocal(rel) {
+		if strict {
+			return "", fmt.Errorf("unsafe key %q escapes %s", relKey, localDir)
+		}
+		rel = sanitizeKey(relKey)
+		if !filepath.IsLocal(rel) {
+			return "", fmt.Errorf("unsafe key %q escapes %s", relKey, localDir)
+		}
+		log.Printf("unsafe key %q written to %s\n", relKey, rel)
+	}
+	localPath := filepath.Join(localDir, rel)
+	if err := checkInside(localDir, localPath); err != nil {
+		return "", err
+	}
+	return localPath, nil
+}
+
+// sanitizeKey drops the empty, . and .. segments and volume names of a key, so it names a path
+// below the destination directory
+func sanitizeKey(relKey string) string {
+	var segments []string
+	for _, segment := range strings.FieldsFunc(relKey, func(c rune) bool { return c == '/' || c == '\\' }) {
+		segment = strings.TrimPrefix(segment, filepath.VolumeName(segment))
+		if segment != "" && segment != "." && segment != ".." {
+			segments = append(segments, segment)
+		}
+	}
+	return filepath.Join(segments...)
+}
+
+// checkInside refuses localPath when its deepest existing parent directory resolves outside
+// localDir through a symlink, since the missing directories below it are created through the link.
+// A dangling symlink on the way is refused as well.
+func checkInside(localDir, localPath string) error {
+	root, err := filepath.EvalSymlinks(localDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(localPath)
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) && rel != "." {
+				return fmt.Errorf("unsafe path %s resolves outside %s", localPath, localDir)
+			}
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if _, err := os.Lstat(dir); err == nil {
+			return fmt.Errorf("unsafe path %s below the dangling symlink %s", localPath, dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// SetDownloadTempDir makes downloads write their partial files to dir, which must be an existing
+// directory, instead of next to their destination. An empty dir restores the default.
+func (r *R2Client) SetDownloadTempDir(dir string) error {
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	r.tempDir = dir
+	return nil
+}
+
+// createPartial creates the temporary file a download to localPath is written to, in tempDir when
+// it is set and else next to localPath
+func createPartial(tempDir, localPath string) (*os.File, error) {
+	dir := filepath.Dir(localPath)
+	if tempDir != "" {
+		dir = tempDir
+	}
+	return os.CreateTemp(dir, "."+filepath.Base(localPath)+".*.partial")
+}
+
+// commitPartial atomically replaces localPath with a completed partial file. A partial file the
+// rename can't move, like one in another file system, is first copied next to localPath, so
+// readers of the destination never observe a truncated file either way.
+func commitPartial(tempDir, tempPath, localPath string) error {
+	err := os.Rename(tempPath, localPath)
+	if err == nil || tempDir == "" {
+		return err
+	}
+	src, err := os.Open(tempPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".*.partial")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(dst.Name(), info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(dst.Name(), localPath)
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	return os.Remove(tempPath)
+}
+
+// DownloadFile writes a remote object to localPath through a temporary file that is renamed on completion
+func (r *R2Client) DownloadFile(ctx context.Context, remotePath, localPath string, dryRun bool) error {
+	if dryRun {
+		log.Printf("(dryrun) download: %s -> %s\n", r.RemotePath(remotePath), localPath)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+
+	resp, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(remotePath),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	file, err := createPartial(r.tempDir, localPath)
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	modTime := aws.ToTime(resp.LastModified)
+	var size int64
+	var transfer *meterTransfer
+	if resp.Metadata[chunkMetadata] != "" {
+		// reassemble a chunked file from the chunks its manifest lists
+		var manifest *chunkManifest
+		if manifest, err = decodeChunkManifest(remotePath, resp.Body); err == nil {
+			if r.Meter != nil {
+				transfer = r.Meter.start(localPath, manifest.Size)
+			}
+			modTime = manifest.ModTime
+			size, err = r.writeChunks(ctx, remotePath, manifest, file)
+		}
+	} else {
+		if r.Meter != nil {
+			// compressed objects are counted in the bytes received
+			transfer = r.Meter.start(localPath, aws.ToInt64(resp.ContentLength))
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{&meterReader{Reader: resp.Body, transfer: transfer}, resp.Body}
+		}
+		var body io.ReadCloser
+		if body, err = decompressed(resp); err == nil {
+			size, err = io.Copy(file, body)
+			body.Close()
+		}
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(tempPath, modTime, modTime)
+	}
+	if err == nil {
+		err = commitPartial(r.tempDir, tempPath, localPath)
+	}
+	if transfer != nil {
+		// counted with the size of the object as listed, which is what Pull planned
+		received := aws.ToInt64(resp.ContentLength)
+		if resp.ContentLength == nil {
+			received = size
+		}
+		transfer.finish(received, err == nil)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	elapsedTime := time.Since(startTime).Seconds()
+	bytesPerSecond := float64(size) / elapsedTime
+	log.Printf("download: %s -> %s, size: %s, average speed: %s\n", r.RemotePath(remotePath), localPath, formatSize(size), formatSpeed(bytesPerSecond))
+	return nil
+}
@@ -0,0 +1,152 @@
+package r2sync
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestLocalPathFor(t *testing.T) {
+	localDir := t.TempDir()
+	tests := []struct {
+		key      string
+		want     string // relative to localDir, "" when the key is refused
+		strictOK bool   // strict mode accepts the key unchanged
+	}{
+		{"a/b.txt", "a/b.txt", true},
+		{"my file#1 100%.txt", "my file#1 100%.txt", true},
+		{"照片/旅行.jpg", "照片/旅行.jpg", true},
+		{"report?a=1", "report?a=1", true},
+		{"a#b", "a#b", true},
+		{"a%2Fb", "a%2Fb", true},
+		{"dir/a%2F..%2Fb", "dir/a%2F..%2Fb", true},
+		{"../x", "x", false},
+		{"a/../../x", "a/x", false},
+		{"../../../etc/passwd", "etc/passwd", false},
+		{"/etc/passwd", "etc/passwd", false},
+		{"//server/share/x", "server/share/x", false},
+		{"..", "", false},
+		{"../..", "", false},
+		{"/", "", false},
+	}
+	if runtime.GOOS == "windows" {
+		tests = append(tests, []struct {
+			key      string
+			want     string
+			strictOK bool
+		}{
+			{`..\..\x`, "x", false},
+			{`a\..\..\x`, "a/x", false},
+			{`C:\Windows\x`, "Windows/x", false},
+			{"C:/Windows/x", "Windows/x", false},
+			{"C:x", "x", false},
+		}...)
+	} else {
+		// backslashes and drive letters are ordinary file name characters outside Windows
+		tests = append(tests, []struct {
+			key      string
+			want     string
+			strictOK bool
+		}{
+			{`..\..\x`, `..\..\x`, true},
+			{"C:/Windows/x", "C:/Windows/x", true},
+		}...)
+	}
+
+	for _, test := range tests {
+		got, err := localPathFor(localDir, test.key, false)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("localPathFor(%q) = %q, want an error", test.key, got)
+			}
+		} else if want := filepath.Join(localDir, filepath.FromSlash(test.want)); err != nil || got != want {
+			t.Errorf("localPathFor(%q) = %q, %v, want %q", test.key, got, err, want)
+		}
+
+		got, err = localPathFor(localDir, test.key, true)
+		if test.strictOK {
+			if want := filepath.Join(localDir, filepath.FromSlash(test.want)); err != nil || got != want {
+				t.Errorf("strict localPathFor(%q) = %q, %v, want %q", test.key, got, err, want)
+			}
+		} else if err == nil {
+			t.Errorf("strict localPathFor(%q) = %q, want an error", test.key, got)
+		}
+	}
+}
+
+func TestSanitizeKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"a/b", "a/b"},
+		{"a//b/", "a/b"},
+		{"./a/./b", "a/b"},
+		{"../a/../b", "a/b"},
+		{"/abs/path", "abs/path"},
+		{`a\b\..\c`, "a/b/c"},
+		{"..", ""},
+	}
+	for _, test := range tests {
+		if got := sanitizeKey(test.key); got != filepath.FromSlash(test.want) {
+			t.Errorf("sanitizeKey(%q) = %q, want %q", test.key, got, filepath.FromSlash(test.want))
+		}
+	}
+}
+
+func TestCheckInsideSymlink(t *testing.T) {
+	localDir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(localDir, "escape")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(localDir, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(localDir, "real"), filepath.Join(localDir, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, strict := range []bool{false, true} {
+		if got, err := localPathFor(localDir, "escape/x", strict); err == nil {
+			t.Errorf("localPathFor through a symlink outside the directory = %q, want an error", got)
+		}
+		for _, key := range []string{"escape/sub/x", "escape/sub/deeper/x"} {
+			if got, err := localPathFor(localDir, key, strict); err == nil {
+				t.Errorf("localPathFor(%q) below a missing directory of a symlink outside the directory = %q, want an error", key, got)
+			}
+		}
+		if _, err := localPathFor(localDir, "inside/x", strict); err != nil {
+			t.Errorf("localPathFor through a symlink inside the directory failed: %v", err)
+		}
+	}
+
+	// a dangling symlink points at a directory outside that doesn't exist yet
+	if err := os.Symlink(filepath.Join(outside, "missing"), filepath.Join(localDir, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	// keys are written the way downloads write them, nothing may appear outside localDir
+	for _, key := range []string{"escape/x", "escape/sub/x", "escape/sub/deeper/x", "dangling/x", "dangling/sub/x", "inside/sub/x"} {
+		localPath, err := localPathFor(localDir, key, false)
+		if err != nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(localPath, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries, err := os.ReadDir(outside); err != nil || len(entries) != 0 {
+		t.Errorf("files created outside the directory: %v, %v", entries, err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "real", "sub", "x")); err != nil {
+		t.Errorf("file below a symlink inside the directory not written: %v", err)
+	}
+
+	if err := checkInside(filepath.Join(localDir, "missing"), filepath.Join(localDir, "missing", "x")); err != nil {
+		t.Errorf("checkInside of a missing directory failed: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package r2sync
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// R2Endpoint returns the S3 API endpoint of an R2 account. Buckets created with a jurisdiction
+// (eu, fedramp) for data residency are only reachable through the matching endpoint.
+func R2Endpoint(accountID, jurisdiction string) (string, error) {
+	switch jurisdiction {
+	case "", "default":
+		return fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID), nil
+	case "eu", "fedramp":
+		return fmt.Sprintf("https://%s.%s.r2.cloudflarestorage.com", accountID, jurisdiction), nil
+	default:
+		return "", fmt.Errorf("invalid jurisdiction: %s (valid values: eu, fedramp)", jurisdiction)
+	}
+}
+
+// WithEndpoint points an s3 client at endpoint, R2 only accepts the auto region
+func WithEndpoint(endpoint string) S3Option {
+	return func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		if o.Region == "" {
+			o.Region = "auto"
+		}
+	}
+}
+
+// WithRegion signs requests for region, e.g. us-east-1 for MinIO
+func WithRegion(region string) S3Option {
+	return func(o *s3.Options) {
+		o.Region = region
+	}
+}
+
+// WithPathStyle addresses buckets in the path (https://endpoint/bucket/key) instead of the host
+// name, as MinIO and other self-hosted backends without wildcard DNS require
+func WithPathStyle() S3Option {
+	return func(o *s3.Options) {
+		o.UsePathStyle = true
+	}
+}
+
+// WithAccelerate uses the S3 Transfer Acceleration endpoint of the bucket, AWS only
+func WithAccelerate() S3Option {
+	return func(o *s3.Options) {
+		o.UseAccelerate = true
+	}
+}
+
+// urlOptionsPattern matches a query string of client options, anything else after a ? like ?a=1 is
+// part of the key
+var urlOptionsPattern = regexp.MustCompile(`^(endpoint|region|profile)=[^&]*(&(endpoint|region|profile)=[^&]*)*$`)
+
+// splitURLOptions splits the client options off a remote url. Only a trailing ?name=value&... of
+// known parameters is taken as options, so keys containing ? stay intact.
+func splitURLOptions(remoteURL string) (string, url.Values, error) {
+	i := strings.LastIndex(remoteURL, "?")
+	if i < 0 || !urlOptionsPattern.MatchString(remoteURL[i+1:]) {
+		return remoteURL, nil, nil
+	}
+	query, err := url.ParseQuery(remoteURL[i+1:])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid url parameters %q: %v", remoteURL[i+1:], err)
+	}
+	return remoteURL[:i], query, nil
+}
+
+// validateURLOptions checks the query parameters of a remote url like
+// r2://bucket/prefix?endpoint=https://...&region=auto&profile=prod
+func validateURLOptions(query url.Values) error {
+	for key, values := range query {
+		if len(values) != 1 || values[0] == "" {
+			return fmt.Errorf("url parameter %s needs exactly one value", key)
+		}
+		if key == "endpoint" {
+			if u, err := url.Parse(values[0]); err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("invalid endpoint %q, expected a url like https://host", values[0])
+			}
+		}
+	}
+	return nil
+}
+
+// RemoteURLOptions appends the client options given as query parameters of a validated remote url
+// to optFns, they apply after the command line flags so a url fully describes its location
+func RemoteURLOptions(remoteURL string, optFns ...func(*s3.Options)) ([]func(*s3.Options), error) {
+	_, query, err := splitURLOptions(NormalizePath(remoteURL))
+	if err != nil {
+		return nil, err
+	}
+	// never append into the caller's array
+	optFns = slices.Clip(optFns)
+	if profile := query.Get("profile"); profile != "" {
+		withProfile, err := profileOption(profile)
+		if err != nil {
+			return nil, err
+		}
+		optFns = append(optFns, withProfile)
+	}
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		optFns = append(optFns, WithEndpoint(endpoint))
+	}
+	if region := query.Get("region"); region != "" {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.Region = region
+		})
+	}
+	return optFns, nil
+}
+
+// profileOption uses the credentials and region of a shared config profile
+func profileOption(profile string) (func(*s3.Options), error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithSharedConfigProfile(profile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profile %s: %v", profile, err)
+	}
+	return func(o *s3.Options) {
+		o.Credentials = cfg.Credentials
+		if cfg.Region != "" {
+			o.Region = cfg.Region
+		}
+	}, nil
+}
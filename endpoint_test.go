@@ -0,0 +1,47 @@
+package r2sync
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestSplitURLOptions(t *testing.T) {
+	tests := []struct {
+		url      string
+		location string
+		query    url.Values
+	}{
+		{"r2://bucket/path", "r2://bucket/path", nil},
+		{"r2://bucket/my file.txt", "r2://bucket/my file.txt", nil},
+		{"r2://bucket/notes#1.txt", "r2://bucket/notes#1.txt", nil},
+		{"r2://bucket/100%.txt", "r2://bucket/100%.txt", nil},
+		{"r2://bucket/why?.txt", "r2://bucket/why?.txt", nil},
+		{"r2://bucket/report?a=1", "r2://bucket/report?a=1", nil},
+		{"r2://bucket/report?a=1&b=2", "r2://bucket/report?a=1&b=2", nil},
+		{"r2://bucket/照片/旅行.jpg", "r2://bucket/照片/旅行.jpg", nil},
+		{"r2://bucket/path?region=auto", "r2://bucket/path", url.Values{"region": {"auto"}}},
+		{"r2://bucket/why?.txt?profile=prod", "r2://bucket/why?.txt", url.Values{"profile": {"prod"}}},
+		{
+			"r2://bucket/path/?endpoint=https://example.com&region=auto",
+			"r2://bucket/path/",
+			url.Values{"endpoint": {"https://example.com"}, "region": {"auto"}},
+		},
+	}
+	for _, test := range tests {
+		location, query, err := splitURLOptions(test.url)
+		if err != nil {
+			t.Errorf("splitURLOptions(%q) failed: %v", test.url, err)
+			continue
+		}
+		if location != test.location || !reflect.DeepEqual(query, test.query) {
+			t.Errorf("splitURLOptions(%q) = %q, %v, want %q, %v", test.url, location, query, test.location, test.query)
+		}
+	}
+}
+
+func TestSplitURLOptionsInvalid(t *testing.T) {
+	if _, _, err := splitURLOptions("r2://bucket/path?region=%zz"); err == nil {
+		t.Error("splitURLOptions accepted an invalid escape in the options")
+	}
+}
@@ -0,0 +1,109 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// ErrorClass is the category of a failed request
+type ErrorClass string
+
+const (
+	classThrottling   ErrorClass = "throttling"
+	classAccessDenied ErrorClass = "access-denied"
+	classNetwork      ErrorClass = "network"
+	classChecksum     ErrorClass = "checksum"
+	classNotFound     ErrorClass = "not-found"
+	classServer       ErrorClass = "server"
+	classOther        ErrorClass = "other"
+)
+
+// errorClasses lists the classes in report order with a hint to the likely fix
+var errorClasses = []struct {
+	class ErrorClass
+	hint  string
+}{
+	{classThrottling, "rate limited, lower --concurrency"},
+	{classAccessDenied, "check credentials and token permissions"},
+	{classNetwork, "timeouts or dropped connections, check the network"},
+	{classChecksum, "data changed or was corrupted in transit"},
+	{classNotFound, "bucket or object missing"},
+	{classServer, "backend errors, usually transient"},
+	{classOther, ""},
+}
+
+// classifyError maps an error to its ErrorClass by api error code, http status or network error
+func classifyError(err error) ErrorClass {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "SlowDown", "Throttling", "ThrottlingException", "TooManyRequests", "TooManyRequestsException", "RequestLimitExceeded":
+			return classThrottling
+		case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken", "InvalidToken", "Unauthorized", "Forbidden":
+			return classAccessDenied
+		case "BadDigest", "InvalidDigest", "XAmzContentSHA256Mismatch", "InvalidChecksum":
+			return classChecksum
+		case "NoSuchKey", "NoSuchBucket", "NotFound":
+			return classNotFound
+		case "RequestTimeout", "RequestTimeoutException":
+			return classNetwork
+		}
+	}
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch status := respErr.HTTPStatusCode(); {
+		case status == http.StatusTooManyRequests:
+			return classThrottling
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			return classAccessDenied
+		case status == http.StatusNotFound:
+			return classNotFound
+		case status >= 500:
+			return classServer
+		}
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE):
+		return classNetwork
+	}
+	return classOther
+}
+
+// requestIDs returns the identifiers of a failed api call that support needs to trace it:
+// x-amz-request-id, x-amz-id-2 and the Cloudflare cf-ray header
+func requestIDs(err error) (requestID, hostID, cfRay string) {
+	var respErr *smithyhttp.ResponseError
+	if !errors.As(err, &respErr) {
+		return "", "", ""
+	}
+	header := respErr.HTTPResponse().Header
+	return header.Get("X-Amz-Request-Id"), header.Get("X-Amz-Id-2"), header.Get("Cf-Ray")
+}
+
+// ErrorDetail formats err with the request identifiers the sdk does not include in its message
+func ErrorDetail(err error) string {
+	text := err.Error()
+	requestID, _, cfRay := requestIDs(err)
+	if requestID != "" && !strings.Contains(text, requestID) {
+		text += ", request id: " + requestID
+	}
+	if cfRay != "" {
+		text += ", cf-ray: " + cfRay
+	}
+	return text
+}
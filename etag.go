@@ -0,0 +1,137 @@
+package r2sync
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// multipartETagPattern matches the ETag of a multipart upload, the MD5 of the part MD5s followed by
+// the part count
+var multipartETagPattern = regexp.MustCompile(`^"?[0-9a-f]{32}-([0-9]+)"?$`)
+
+// commonPartSizes are the default part sizes of common upload tools: 8 MiB of the aws cli and boto3,
+// 5 MiB of rclone and the sdk upload managers, 15 MiB of s3cmd and a few larger round sizes
+var commonPartSizes = []int64{8 << 20, 5 << 20, 16 << 20, 15 << 20, 64 << 20, 100 << 20}
+
+// multipartParts returns the part count of a multipart ETag, 0 for a plain MD5 ETag
+func multipartParts(etag string) int {
+	match := multipartETagPattern.FindStringSubmatch(etag)
+	if match == nil {
+		return 0
+	}
+	parts, _ := strconv.Atoi(match[1])
+	return parts
+}
+
+// partSizes returns the part sizes an upload of size bytes in parts parts may have used, partSize
+// first when it is set. Besides the common defaults, tools sizing parts to the file usually use the
+// smallest MiB multiple that fits.
+func partSizes(size int64, parts int, partSize int64) []int64 {
+	fitted := (size + int64(parts) - 1) / int64(parts)
+	fitted = (fitted + 1<<20 - 1) &^ (1<<20 - 1)
+	var sizes []int64
+	for _, candidate := range append(append([]int64{partSize}, commonPartSizes...), fitted) {
+		// every part but the last is full and the last one isn't empty
+		if candidate <= 0 || int64(parts-1)*candidate >= size || int64(parts)*candidate < size {
+			continue
+		}
+		if !slices.Contains(sizes, candidate) {
+			sizes = append(sizes, candidate)
+		}
+	}
+	return sizes
+}
+
+// partHasher computes the ETag a multipart upload in parts of partSize would get
+type partHasher struct {
+	partSize int64
+	written  int64 // bytes of the current part
+	part     hash.Hash
+	sums     []byte
+	parts    int
+}
+
+func newPartHasher(partSize int64) *partHasher {
+	return &partHasher{partSize: partSize, part: md5.New()}
+}
+
+func (h *partHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := min(int64(len(p)), h.partSize-h.written)
+		h.part.Write(p[:chunk])
+		h.written += chunk
+		p = p[chunk:]
+		if h.written == h.partSize {
+			h.finishPart()
+		}
+	}
+	return n, nil
+}
+
+func (h *partHasher) finishPart() {
+	h.sums = h.part.Sum(h.sums)
+	h.parts++
+	h.part.Reset()
+	h.written = 0
+}
+
+// ETag returns the quoted ETag once everything was written
+func (h *partHasher) ETag() string {
+	if h.written > 0 || h.parts == 0 {
+		h.finishPart()
+	}
+	sum := md5.Sum(h.sums)
+	return fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(sum[:]), h.parts)
+}
+
+// multipartETagMatches reads r once and reports whether it has the multipart ETag with any
+// plausible part size
+func multipartETagMatches(r io.Reader, size int64, etag string, partSize int64) (bool, error) {
+	parts := multipartParts(etag)
+	if parts == 0 {
+		return false, nil
+	}
+	sizes := partSizes(size, parts, partSize)
+	if len(sizes) == 0 {
+		return false, nil
+	}
+	hashers := make([]*partHasher, len(sizes))
+	writers := make([]io.Writer, len(sizes))
+	for i, candidate := range sizes {
+		hashers[i] = newPartHasher(candidate)
+		writers[i] = hashers[i]
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return false, err
+	}
+	for _, h := range hashers {
+		if h.ETag() == etag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// etagMatches reports whether the local file at path of size bytes, whose MD5 ETag is localETag,
+// has the remote ETag. Multipart ETags are computed with every plausible part size in one read.
+func etagMatches(limit *IOLimiter, path string, size int64, localETag, remoteETag string, partSize int64) (bool, error) {
+	if localETag == remoteETag {
+		return true, nil
+	}
+	if multipartParts(remoteETag) == 0 {
+		return false, nil
+	}
+	file, err := limit.open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	return multipartETagMatches(file, size, remoteETag, partSize)
+}
@@ -0,0 +1,327 @@
+package r2sync
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// ObjectEvent is a change notification for a single remote object
+type ObjectEvent struct {
+	Bucket  string
+	Key     string
+	Deleted bool
+}
+
+// EventMessage is a queue message carrying one or more object events
+type EventMessage struct {
+	Events []ObjectEvent
+	Handle string // lease id or receipt handle used to acknowledge the message
+}
+
+// EventQueue receives object events, messages that are not acknowledged are redelivered by the queue
+type EventQueue interface {
+	Receive(ctx context.Context) ([]EventMessage, error)
+	Ack(ctx context.Context, handles []string) error
+}
+
+// cloudflareQueue consumes R2 event notifications from a Cloudflare Queue with an HTTP pull consumer
+type cloudflareQueue struct {
+	client  *CloudflareClient
+	queueID string
+}
+
+// NewCloudflareQueue consumes the Cloudflare Queue queueID with an HTTP pull consumer
+func NewCloudflareQueue(client *CloudflareClient, queueID string) EventQueue {
+	return &cloudflareQueue{client: client, queueID: queueID}
+}
+
+// r2Event is the body of an R2 event notification
+type r2Event struct {
+	Action string `json:"action"`
+	Bucket string `json:"bucket"`
+	Object struct {
+		Key string `json:"key"`
+	} `json:"object"`
+}
+
+// decodeQueueBody accepts a message body as a JSON object, a JSON encoded string or base64
+func decodeQueueBody(raw json.RawMessage, v any) error {
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return json.Unmarshal(raw, v)
+	}
+	if err := json.Unmarshal([]byte(text), v); err == nil {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return fmt.Errorf("unsupported message body")
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (q *cloudflareQueue) endpoint(action string) string {
+	return fmt.Sprintf("/accounts/%s/queues/%s/messages/%s", url.PathEscape(q.client.accountID), url.PathEscape(q.queueID), action)
+}
+
+func (q *cloudflareQueue) Receive(ctx context.Context) ([]EventMessage, error) {
+	var result struct {
+		Messages []struct {
+			Body    json.RawMessage `json:"body"`
+			LeaseID string          `json:"lease_id"`
+		} `json:"messages"`
+	}
+	body := map[string]int{"batch_size": 10, "visibility_timeout_ms": 60000}
+	if err := q.client.do(ctx, http.MethodPost, q.endpoint("pull"), body, &result); err != nil {
+		return nil, err
+	}
+
+	messages := make([]EventMessage, 0, len(result.Messages))
+	for _, m := range result.Messages {
+		var event r2Event
+		if err := decodeQueueBody(m.Body, &event); err != nil {
+			log.Printf("ignoring queue message: %v\n", err)
+			continue
+		}
+		messages = append(messages, EventMessage{
+			Events: []ObjectEvent{{
+				Bucket:  event.Bucket,
+				Key:     event.Object.Key,
+				Deleted: event.Action == "DeleteObject" || event.Action == "LifecycleDeletion",
+			}},
+			Handle: m.LeaseID,
+		})
+	}
+	return messages, nil
+}
+
+func (q *cloudflareQueue) Ack(ctx context.Context, handles []string) error {
+	acks := make([]map[string]string, 0, len(handles))
+	for _, handle := range handles {
+		acks = append(acks, map[string]string{"lease_id": handle})
+	}
+	return q.client.do(ctx, http.MethodPost, q.endpoint("ack"), map[string]any{"acks": acks}, nil)
+}
+
+// sqsQueue consumes S3 event notifications from an SQS queue
+type sqsQueue struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+// NewSQSQueue consumes the SQS queue at queueURL
+func NewSQSQueue(client *sqs.Client, queueURL string) EventQueue {
+	return &sqsQueue{client: client, queueURL: queueURL}
+}
+
+// s3EventRecords is the body of an S3 event notification, object keys are url-encoded
+type s3EventRecords struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+func (q *sqsQueue) Receive(ctx context.Context) ([]EventMessage, error) {
+	resp, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.queueURL),
+		MaxNumberOfMessages: 10,
+		WaitTimeSeconds:     20,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]EventMessage, 0, len(resp.Messages))
+	for _, m := range resp.Messages {
+		var records s3EventRecords
+		if err := json.Unmarshal([]byte(aws.ToString(m.Body)), &records); err != nil {
+			log.Printf("ignoring queue message: %v\n", err)
+			continue
+		}
+		message := EventMessage{Handle: aws.ToString(m.ReceiptHandle)}
+		for _, record := range records.Records {
+			key, err := url.QueryUnescape(record.S3.Object.Key)
+			if err != nil {
+				key = record.S3.Object.Key
+			}
+			message.Events = append(message.Events, ObjectEvent{
+				Bucket:  record.S3.Bucket.Name,
+				Key:     key,
+				Deleted: strings.HasPrefix(record.EventName, "ObjectRemoved:") || strings.HasPrefix(record.EventName, "LifecycleExpiration:"),
+			})
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func (q *sqsQueue) Ack(ctx context.Context, handles []string) error {
+	for _, handle := range handles {
+		_, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(q.queueURL),
+			ReceiptHandle: aws.String(handle),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBudget caps the local files removed in one run, nil removes any number
+type DeleteBudget struct {
+	max   int64
+	count atomic.Int64
+}
+
+// NewDeleteBudget allows max local files to be removed
+func NewDeleteBudget(max int64) *DeleteBudget {
+	return &DeleteBudget{max: max}
+}
+
+// Take reserves one delete, false once the budget is used up
+func (b *DeleteBudget) Take() bool {
+	return b == nil || b.count.Add(1) <= b.max
+}
+
+// applyEvent pulls or removes the local copy of a changed object. Keys the filters of opts skip are
+// neither pulled nor removed, keys of sibling prefixes like path2/ and directory markers are ignored.
+func (r *R2Client) applyEvent(ctx context.Context, event ObjectEvent, remotePath, localDir string, opts SyncOptions, deletes *DeleteBudget) error {
+	prefix := listingPrefix(remotePath, "")
+	if event.Bucket != r.bucket || !strings.HasPrefix(event.Key, prefix) || strings.HasSuffix(event.Key, "/") {
+		return nil
+	}
+	if isPackKey(remotePath, event.Key) {
+		// a new index means bundles changed, bundles are only read through it
+		if event.Key == packKey(remotePath, packIndexName) && !event.Deleted {
+			_, err := r.Unpack(ctx, remotePath, localDir, opts.DryRun)
+			return err
+		}
+		return nil
+	}
+	relKey := RelativeKey(event.Key, prefix)
+	if isInternalKey(relKey) {
+		// chunks and manifests are read through the files they belong to
+		return nil
+	}
+	localPath, err := localPathFor(localDir, relKey, r.StrictKeys)
+	if err != nil {
+		return err
+	}
+	if filterReason(localDir, NormalizePath(localPath), false, opts) != "" {
+		return nil
+	}
+	if !event.Deleted {
+		err := r.DownloadFile(ctx, event.Key, localPath, opts.DryRun)
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			// deleted after the event was sent, its delete event follows
+			log.Printf("Skipping %s, the object no longer exists\n", r.RemotePath(event.Key))
+			return nil
+		}
+		return err
+	}
+	if !opts.Delete {
+		return nil
+	}
+	if !deletes.Take() {
+		// left unacknowledged, so the delete is redelivered to a run with a higher limit
+		return fmt.Errorf("delete limit of %d reached, not deleting %s", deletes.max, localPath)
+	}
+	if opts.DryRun {
+		log.Printf("(dryrun) delete: %s\n", localPath)
+		return nil
+	}
+	if err := os.Remove(localPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	log.Printf("delete: %s\n", localPath)
+	return nil
+}
+
+// SyncEvents consumes object events from the queue and pull-syncs only the changed keys into localDir
+// until opts.Drain is closed or ctx is cancelled. Of opts, Delete removes the local files of deleted
+// objects, and DryRun, Concurrency and the filters apply as for Pull.
+func (r *R2Client) SyncEvents(ctx context.Context, queue EventQueue, remotePath, localDir string, opts SyncOptions, deletes *DeleteBudget, pollInterval time.Duration) error {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = min(4*runtime.NumCPU(), MaxAutoConcurrency)
+	}
+	log.Printf("Waiting for events: %s ...\n", r.RemotePath(remotePath))
+	for {
+		if Draining(opts.Drain) {
+			return ErrInterrupted
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		r.heartbeat()
+		messages, err := queue.Receive(ctx)
+		if err != nil {
+			log.Printf("receive failed: %v\n", err)
+		}
+		if err != nil || len(messages) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-opts.Drain:
+				return ErrInterrupted
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		semaphore := make(chan struct{}, opts.Concurrency)
+		handles := make([]string, 0, len(messages))
+		for _, message := range messages {
+			wg.Add(1)
+			semaphore <- struct{}{}
+
+			go func(message EventMessage) {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+				for _, event := range message.Events {
+					r.heartbeat()
+					if err := r.applyEvent(ctx, event, remotePath, localDir, opts, deletes); err != nil {
+						// leave the message unacknowledged so the queue redelivers it
+						log.Printf("event failed %s: %s\n", r.RemotePath(event.Key), ErrorDetail(err))
+						return
+					}
+				}
+				mu.Lock()
+				handles = append(handles, message.Handle)
+				mu.Unlock()
+			}(message)
+		}
+		wg.Wait()
+
+		if err := queue.Ack(ctx, handles); err != nil {
+			log.Printf("ack failed: %v\n", err)
+		}
+	}
+}
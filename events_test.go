@@ -0,0 +1,91 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyEvent(t *testing.T) {
+	f, client := newFakeS3(t)
+	ctx := context.Background()
+	for key, data := range map[string]string{
+		"site/a.txt":        "a",
+		"site/page.html":    "page",
+		"site/.hidden":      "hidden",
+		"site2/sibling.txt": "sibling prefix",
+	} {
+		f.put(key, data)
+	}
+	target := t.TempDir()
+	writeFiles(t, target, map[string]string{"dir/keep.txt": "keep", "old.html": "old"})
+
+	opts := SyncOptions{
+		Delete:        true,
+		Filters:       []Filter{{Pattern: "*"}, {Pattern: "*.html", Include: true}, {Pattern: "dir", Include: true}},
+		ExcludeHidden: true,
+	}
+	for _, event := range []ObjectEvent{
+		{Bucket: "bucket", Key: "site/a.txt"},
+		{Bucket: "bucket", Key: "site/page.html"},
+		{Bucket: "bucket", Key: "site/.hidden"},
+		{Bucket: "bucket", Key: "site2/sibling.txt"},
+		{Bucket: "bucket", Key: "site/dir/"},
+		{Bucket: "bucket", Key: "site/gone.html"},
+		{Bucket: "bucket", Key: "site/old.html", Deleted: true},
+		{Bucket: "other", Key: "site/page.html", Deleted: true},
+	} {
+		if err := client.applyEvent(ctx, event, "site", target, opts, nil); err != nil {
+			t.Errorf("applyEvent(%s, deleted %v) failed: %v", event.Key, event.Deleted, err)
+		}
+	}
+	want := map[string]string{"dir/keep.txt": "keep", "page.html": "page"}
+	if got := readFiles(t, target); !reflect.DeepEqual(got, want) {
+		t.Errorf("files after events = %v, want %v", got, want)
+	}
+}
+
+// failingQueue fails every receive
+type failingQueue struct{}
+
+func (failingQueue) Receive(ctx context.Context) ([]EventMessage, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (failingQueue) Ack(ctx context.Context, handles []string) error {
+	return nil
+}
+
+func TestSyncEventsStops(t *testing.T) {
+	_, client := newFakeS3(t)
+	drain := make(chan struct{})
+	time.AfterFunc(10*time.Millisecond, func() { close(drain) })
+	done := make(chan error, 1)
+	go func() {
+		done <- client.SyncEvents(context.Background(), failingQueue{}, "site", t.TempDir(), SyncOptions{Drain: drain}, nil, time.Hour)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrInterrupted) {
+			t.Errorf("SyncEvents after drain = %v, want ErrInterrupted", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SyncEvents kept waiting for the poll interval after drain")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	go func() {
+		done <- client.SyncEvents(ctx, failingQueue{}, "site", t.TempDir(), SyncOptions{}, nil, time.Hour)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("SyncEvents after cancel = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SyncEvents kept waiting for the poll interval after cancel")
+	}
+}
@@ -0,0 +1,176 @@
+package r2sync
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is an in-memory bucket serving the requests a sync makes: put, get, head, copy, delete,
+// batch delete and list
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	deletes int // objects deleted, by DELETE or DeleteObjects
+}
+
+// newFakeS3 returns the fake and a client of its bucket "bucket"
+func newFakeS3(t *testing.T) (*fakeS3, *R2Client) {
+	t.Helper()
+	f := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "auto")
+	client, err := NewClient(context.Background(), WithBucket("bucket"), WithEndpoint(server.URL), WithPathStyle())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, client
+}
+
+// keys returns the sorted keys of the bucket
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeS3) put(key, data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = []byte(data)
+}
+
+func (f *fakeS3) get(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[key]
+	return string(data), ok
+}
+
+func fakeETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// path style: /bucket/key, the key is unescaped by net/http
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, query.Get("prefix"))
+	case r.Method == http.MethodPost && query.Has("delete"):
+		f.deleteObjects(w, r)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		_, sourceKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+		data, ok := f.objects[sourceKey]
+		if !ok {
+			notFound(w)
+			return
+		}
+		f.objects[key] = data
+		fmt.Fprintf(w, `<CopyObjectResult><ETag>%s</ETag><LastModified>2024-01-01T00:00:00.000Z</LastModified></CopyObjectResult>`, fakeETag(data))
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+		w.Header().Set("ETag", fakeETag(data))
+	case r.Method == http.MethodDelete:
+		if _, ok := f.objects[key]; ok {
+			delete(f.objects, key)
+			f.deletes++
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			notFound(w)
+			return
+		}
+		w.Header().Set("ETag", fakeETag(data))
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		if byteRange := r.Header.Get("Range"); byteRange != "" {
+			var start, end int
+			fmt.Sscanf(byteRange, "bytes=%d-%d", &start, &end)
+			data = data[min(start, len(data)):min(end+1, len(data))]
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		}
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func notFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+}
+
+// list answers ListObjectsV2 with every key below prefix in one page
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type contents struct {
+		Key          string
+		Size         int
+		LastModified string
+		ETag         string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		IsTruncated bool
+		Contents    []contents
+	}{}
+	for key, data := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, contents{key, len(data), "2024-01-01T00:00:00.000Z", fakeETag(data)})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	xml.NewEncoder(w).Encode(result)
+}
+
+// deleteObjects answers DeleteObjects, deleting every key of the request
+func (f *fakeS3) deleteObjects(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Objects []struct{ Key string } `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, object := range request.Objects {
+		if _, ok := f.objects[object.Key]; ok {
+			delete(f.objects, object.Key)
+			f.deletes++
+		}
+	}
+	fmt.Fprint(w, `<DeleteResult></DeleteResult>`)
+}
@@ -0,0 +1,154 @@
+package r2sync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// FindFilter selects objects by name, size, age, tags and user metadata, zero values don't filter
+type FindFilter struct {
+	Name        string // glob matched against the last key segment
+	LargerThan  int64
+	SmallerThan int64
+	OlderThan   time.Duration
+	NewerThan   time.Duration
+	Tags        map[string]string // object tags that must have these values
+	Metadata    map[string]string // user metadata that must have these values
+}
+
+// Match reports whether an object passes the filters available from a listing
+func (f FindFilter) Match(object FileInfo, now time.Time) bool {
+	if f.Name != "" {
+		if matched, err := path.Match(f.Name, path.Base(object.Path)); err != nil || !matched {
+			return false
+		}
+	}
+	if f.LargerThan > 0 && object.Size <= f.LargerThan {
+		return false
+	}
+	if f.SmallerThan > 0 && object.Size >= f.SmallerThan {
+		return false
+	}
+	age := now.Sub(object.LastModified)
+	if f.OlderThan > 0 && age <= f.OlderThan {
+		return false
+	}
+	if f.NewerThan > 0 && age >= f.NewerThan {
+		return false
+	}
+	return true
+}
+
+// matchAttributes reports whether an object has the tags and user metadata of the filter,
+// fetching them with one request each
+func (r *R2Client) matchAttributes(ctx context.Context, key string, filter FindFilter) (bool, error) {
+	if len(filter.Tags) > 0 {
+		resp, err := r.client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+			Bucket: aws.String(r.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return false, err
+		}
+		tags := make(map[string]string, len(resp.TagSet))
+		for _, tag := range resp.TagSet {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		if !hasValues(tags, filter.Tags) {
+			return false, nil
+		}
+	}
+	if len(filter.Metadata) > 0 {
+		resp, err := r.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(r.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return false, err
+		}
+		if !hasValues(resp.Metadata, filter.Metadata) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func hasValues(values, want map[string]string) bool {
+	for key, value := range want {
+		if got, ok := values[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Find lists the objects under prefix that match the filter, sorted by key. Tags and metadata are
+// fetched for objects passing the other filters, at most concurrency at a time.
+func (r *R2Client) Find(ctx context.Context, prefix string, filter FindFilter, concurrency int) ([]FileInfo, error) {
+	remoteFiles, err := r.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var candidates []FileInfo
+	for _, object := range remoteFiles {
+		if filter.Match(object, now) {
+			candidates = append(candidates, object)
+		}
+	}
+	matches := candidates
+	if len(filter.Tags) > 0 || len(filter.Metadata) > 0 {
+		matches = nil
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var firstErr error
+		semaphore := make(chan struct{}, concurrency)
+		for _, object := range candidates {
+			wg.Add(1)
+			semaphore <- struct{}{}
+			go func(object FileInfo) {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+				matched, err := r.matchAttributes(ctx, object.Path, filter)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("%s: %v", r.RemotePath(object.Path), err)
+				}
+				if matched {
+					matches = append(matches, object)
+				}
+			}(object)
+		}
+		wg.Wait()
+		if firstErr != nil {
+			return nil, firstErr
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Path < matches[j].Path })
+	return matches, nil
+}
+
+// ParseKeyValues parses key=value flag values into a map
+func ParseKeyValues(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]string, len(values))
+	for _, value := range values {
+		key, v, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid %q, expected key=value", value)
+		}
+		result[key] = v
+	}
+	return result, nil
+}
@@ -0,0 +1,94 @@
+package r2sync
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// content headers uploads can set per key pattern
+const (
+	HeaderCacheControl       = "Cache-Control"
+	HeaderContentDisposition = "Content-Disposition"
+	HeaderContentLanguage    = "Content-Language"
+)
+
+// HeaderRule sets a content header on uploads whose key matches Pattern. An empty Value sets no
+// header, so a later rule can exempt keys from an earlier one.
+type HeaderRule struct {
+	Header  string
+	Pattern string
+	Value   string
+}
+
+// ParseHeaderRule parses a pattern=value rule of header. The pattern is split off at the first =,
+// the value may contain further ones like max-age=3600.
+func ParseHeaderRule(header, spec string) (HeaderRule, error) {
+	pattern, value, ok := strings.Cut(spec, "=")
+	if !ok || pattern == "" {
+		return HeaderRule{}, fmt.Errorf("invalid rule %q, expected pattern=value", spec)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return HeaderRule{}, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return HeaderRule{}, fmt.Errorf("invalid value %q, must be a single line", value)
+	}
+	return HeaderRule{Header: header, Pattern: pattern, Value: strings.TrimSpace(value)}, nil
+}
+
+// matchesKey reports whether pattern matches the key or any trailing part of it after a slash, so
+// *.html matches every HTML file and assets/* the files directly in any assets directory
+func matchesKey(key, pattern string) bool {
+	for {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+		_, rest, ok := strings.Cut(key, "/")
+		if !ok {
+			return false
+		}
+		key = rest
+	}
+}
+
+// headerValue returns the value of the last rule of header matching key, "" when none matches
+func headerValue(rules []HeaderRule, header, key string) string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].Header == header && matchesKey(key, rules[i].Pattern) {
+			return rules[i].Value
+		}
+	}
+	return ""
+}
+
+// contentHeaders returns the content headers the rules set for key, nil for the ones no rule sets
+func (r *R2Client) contentHeaders(key string) (cacheControl, disposition, language *string) {
+	if value := headerValue(r.HeaderRules, HeaderCacheControl, key); value != "" {
+		cacheControl = aws.String(value)
+	}
+	if value := headerValue(r.HeaderRules, HeaderContentDisposition, key); value != "" {
+		disposition = aws.String(value)
+	}
+	if value := headerValue(r.HeaderRules, HeaderContentLanguage, key); value != "" {
+		language = aws.String(value)
+	}
+	return cacheControl, disposition, language
+}
+
+// applyHeaderRules sets the content headers of the rules matching the key of an upload
+func (r *R2Client) applyHeaderRules(input *s3.PutObjectInput) {
+	cacheControl, disposition, language := r.contentHeaders(aws.ToString(input.Key))
+	if cacheControl != nil {
+		input.CacheControl = cacheControl
+	}
+	if disposition != nil {
+		input.ContentDisposition = disposition
+	}
+	if language != nil {
+		input.ContentLanguage = language
+	}
+}
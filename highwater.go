@@ -0,0 +1,80 @@
+package r2sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// highWaterMark is the newest file of an append-only source synced so far, ordered by
+// modification time and then relative path. Files at or below the mark are not compared again.
+type highWaterMark struct {
+	path    string
+	Source  string    `json:"source"`
+	Target  string    `json:"target"`
+	ModTime time.Time `json:"mod_time"`
+	Name    string    `json:"name"`
+
+	next highWaterEntry // newest file seen by the current run
+}
+
+type highWaterEntry struct {
+	modTime time.Time
+	name    string
+}
+
+// after reports whether the file ordered by modTime and name comes after e
+func (e highWaterEntry) after(modTime time.Time, name string) bool {
+	return modTime.After(e.modTime) || modTime.Equal(e.modTime) && name > e.name
+}
+
+// DefaultHighWaterPath returns a per source and target high-water mark file in the user cache
+// directory
+func DefaultHighWaterPath(source, target string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	sum := sha256.Sum256([]byte(source + "\n" + target))
+	return filepath.Join(dir, "r2sync", "highwater-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// loadHighWaterMark reads the mark at path, a missing or foreign mark starts before every file
+func loadHighWaterMark(path, source, target string) *highWaterMark {
+	m := &highWaterMark{path: path, Source: source, Target: target}
+	if data, err := os.ReadFile(path); err == nil {
+		var saved highWaterMark
+		if json.Unmarshal(data, &saved) == nil && saved.Source == source && saved.Target == target {
+			m.ModTime, m.Name = saved.ModTime, saved.Name
+		}
+	}
+	m.next = highWaterEntry{m.ModTime, m.Name}
+	return m
+}
+
+// Beyond reports whether the file comes after the mark, and moves the mark saved by Save past it
+func (m *highWaterMark) Beyond(relPath string, info os.FileInfo) bool {
+	if !(highWaterEntry{m.ModTime, m.Name}).after(info.ModTime(), relPath) {
+		return false
+	}
+	if m.next.after(info.ModTime(), relPath) {
+		m.next = highWaterEntry{info.ModTime(), relPath}
+	}
+	return true
+}
+
+// Save writes the newest file seen as the mark of the next run
+func (m *highWaterMark) Save() error {
+	m.ModTime, m.Name = m.next.modTime, m.next.name
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, data, 0644)
+}
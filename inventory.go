@@ -0,0 +1,149 @@
+package r2sync
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultInventorySchema are the columns of a listing object without a manifest
+const defaultInventorySchema = "Bucket, Key, Size, LastModifiedDate, ETag"
+
+// Inventory is an S3 Inventory manifest.json, or a CSV listing object in the default schema, read
+// as the remote state instead of listing the bucket. Objects changed after the report was written
+// are compared in their reported state.
+type Inventory struct {
+	Client *R2Client
+	Key    string
+}
+
+// inventoryManifest is the manifest.json of an S3 Inventory report
+type inventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	CreationTimestamp string `json:"creationTimestamp"` // unix milliseconds
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// Load returns the objects of bucket under prefix listed by the inventory, like ListObjects
+func (i *Inventory) Load(ctx context.Context, bucket, prefix string) (map[string]FileInfo, error) {
+	result := make(map[string]FileInfo)
+	if !strings.HasSuffix(i.Key, ".json") {
+		if err := i.readCSV(ctx, i.Key, defaultInventorySchema, bucket, prefix, result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	resp, err := i.Client.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(i.Client.bucket),
+		Key:    aws.String(i.Key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var manifest inventoryManifest
+	err = json.NewDecoder(resp.Body).Decode(&manifest)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory manifest %s: %v", i.Client.RemotePath(i.Key), err)
+	}
+	if manifest.FileFormat != "CSV" {
+		return nil, fmt.Errorf("inventory format %s of %s is not supported, only CSV", manifest.FileFormat, i.Client.RemotePath(i.Key))
+	}
+	if manifest.SourceBucket != "" && manifest.SourceBucket != bucket {
+		return nil, fmt.Errorf("inventory %s lists bucket %s, not %s", i.Client.RemotePath(i.Key), manifest.SourceBucket, bucket)
+	}
+	if millis, err := strconv.ParseInt(manifest.CreationTimestamp, 10, 64); err == nil {
+		log.Printf("Using inventory of %s: %s\n", time.UnixMilli(millis).UTC().Format(time.RFC3339), i.Client.RemotePath(i.Key))
+	}
+	for _, file := range manifest.Files {
+		if err := i.readCSV(ctx, file.Key, manifest.FileSchema, bucket, prefix, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// readCSV adds the current objects of bucket under prefix in the CSV inventory file at key to result.
+// Files may be gzip compressed, keys are url-encoded.
+func (i *Inventory) readCSV(ctx context.Context, key, schema, bucket, prefix string, result map[string]FileInfo) error {
+	columns := make(map[string]int)
+	for n, name := range strings.Split(schema, ",") {
+		columns[strings.TrimSpace(name)] = n
+	}
+	if _, ok := columns["Key"]; !ok {
+		return fmt.Errorf("inventory schema %q has no Key column", schema)
+	}
+	if _, ok := columns["Size"]; !ok {
+		return fmt.Errorf("inventory schema %q has no Size column", schema)
+	}
+	resp, err := i.Client.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(i.Client.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var body io.Reader = bufio.NewReader(resp.Body)
+	if magic, _ := body.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		body = gz
+	}
+	field := func(record []string, name string) string {
+		if n, ok := columns[name]; ok && n < len(record) {
+			return record[n]
+		}
+		return ""
+	}
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid inventory file %s: %v", i.Client.RemotePath(key), err)
+		}
+		if b := field(record, "Bucket"); b != "" && b != bucket {
+			continue
+		}
+		if field(record, "IsLatest") == "false" || field(record, "IsDeleteMarker") == "true" {
+			continue
+		}
+		objectKey, err := url.QueryUnescape(field(record, "Key"))
+		if err != nil || !strings.HasPrefix(objectKey, prefix) {
+			continue
+		}
+		size, err := strconv.ParseInt(field(record, "Size"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid size %q of %s in inventory file %s", field(record, "Size"), objectKey, i.Client.RemotePath(key))
+		}
+		object := FileInfo{Path: objectKey, Size: size}
+		object.LastModified, _ = time.Parse(time.RFC3339, field(record, "LastModifiedDate"))
+		if etag := field(record, "ETag"); etag != "" {
+			object.ETag = `"` + strings.Trim(etag, `"`) + `"`
+		}
+		result[objectKey] = object
+	}
+}
@@ -0,0 +1,52 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// GetLifecycle returns the bucket lifecycle configuration, a bucket without one returns no rules
+func (r *R2Client) GetLifecycle(ctx context.Context) (*types.BucketLifecycleConfiguration, error) {
+	resp, err := r.client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(r.bucket),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration" {
+			return &types.BucketLifecycleConfiguration{}, nil
+		}
+		return nil, err
+	}
+	return &types.BucketLifecycleConfiguration{Rules: resp.Rules}, nil
+}
+
+// PutLifecycle replaces the bucket lifecycle configuration, an empty rule list removes it
+func (r *R2Client) PutLifecycle(ctx context.Context, lifecycle *types.BucketLifecycleConfiguration, dryRun bool) error {
+	if dryRun {
+		log.Printf("(dryrun) put lifecycle: %s, %d rules\n", r.RemotePath(""), len(lifecycle.Rules))
+		return nil
+	}
+
+	var err error
+	if len(lifecycle.Rules) == 0 {
+		_, err = r.client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{
+			Bucket: aws.String(r.bucket),
+		})
+	} else {
+		_, err = r.client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 aws.String(r.bucket),
+			LifecycleConfiguration: lifecycle,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("put lifecycle: %s, %d rules\n", r.RemotePath(""), len(lifecycle.Rules))
+	return nil
+}
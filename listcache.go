@@ -0,0 +1,119 @@
+package r2sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ListingCache keeps remote listings on disk, so syncs repeated within TTL don't list the target
+// again. A sync that uploads or deletes anything drops the listing of its target, and changes by
+// other writers are not seen until the listing expires.
+type ListingCache struct {
+	Dir     string        // directory of the cached listings, one file per endpoint, bucket and prefix
+	TTL     time.Duration // age after which a listing is fetched again
+	Refresh bool          // list again even when the cached listing is fresh, and cache the result
+}
+
+// cachedListing is the file a listing is cached in
+type cachedListing struct {
+	URL   string     `json:"url"`
+	Time  time.Time  `json:"time"`
+	Files []FileInfo `json:"files"`
+}
+
+// DefaultListingCacheDir returns the listings directory in the user cache directory
+func DefaultListingCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "r2sync", "listings")
+}
+
+// path returns the cache file of the listing of prefix, which differs between endpoints serving
+// buckets of the same name
+func (c *ListingCache) path(r *R2Client, prefix string) string {
+	sum := sha256.Sum256([]byte(aws.ToString(r.client.Options().BaseEndpoint) + "\n" + r.RemotePath(prefix)))
+	return filepath.Join(c.Dir, "listing-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// listObjects returns the cached listing of prefix while it is fresh, and otherwise lists the
+// prefix and caches the result. Without a cache it only lists.
+func (c *ListingCache) listObjects(ctx context.Context, r *R2Client, prefix string) (map[string]FileInfo, error) {
+	if c == nil {
+		return r.ListObjects(ctx, prefix)
+	}
+	path := c.path(r, prefix)
+	if !c.Refresh {
+		var cached cachedListing
+		data, err := os.ReadFile(path)
+		if err == nil && json.Unmarshal(data, &cached) == nil && cached.URL == r.RemotePath(prefix) {
+			if age := time.Since(cached.Time); age >= 0 && age < c.TTL {
+				log.Printf("Using remote file list cached %s ago, --refresh-remote lists again\n", age.Round(time.Second))
+				files := make(map[string]FileInfo, len(cached.Files))
+				for _, file := range cached.Files {
+					files[file.Path] = file
+				}
+				return files, nil
+			}
+		}
+	}
+	listed := time.Now()
+	files, err := r.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	cached := cachedListing{URL: r.RemotePath(prefix), Time: listed, Files: make([]FileInfo, 0, len(files))}
+	for _, file := range files {
+		cached.Files = append(cached.Files, file)
+	}
+	if err := c.save(path, cached); err != nil {
+		log.Printf("failed to cache remote file list %s: %v\n", path, err)
+	}
+	return files, nil
+}
+
+// save writes the listing through a temporary file, so concurrent runs never read a partial one
+func (c *ListingCache) save(path string, cached cachedListing) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(c.Dir, ".listing-*.json")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+// invalidate drops the cached listing of prefix after the sync changed objects under it
+func (c *ListingCache) invalidate(r *R2Client, prefix string) {
+	if c == nil {
+		return
+	}
+	if err := os.Remove(c.path(r, prefix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("failed to drop cached remote file list: %v\n", err)
+	}
+}
@@ -0,0 +1,205 @@
+package r2sync
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// meterWindow is the time the current speed is averaged over
+const meterWindow = 10 * time.Second
+
+// TransferMeter tracks the bytes moved by running transfers against the planned total of a sync,
+// fed by wrappers around upload and download bodies. Set it as the Meter of every client of a sync.
+type TransferMeter struct {
+	mu       sync.Mutex
+	total    int64 // planned bytes
+	done     int64 // bytes of finished transfers and moved bytes of running ones
+	files    int   // planned transfers
+	finished int
+	active   map[*meterTransfer]struct{}
+	samples  []meterSample
+}
+
+type meterSample struct {
+	time time.Time
+	done int64
+}
+
+// meterTransfer is a transfer in flight
+type meterTransfer struct {
+	meter *TransferMeter
+	name  string
+	size  int64
+	moved int64
+}
+
+// MeterStatus is the progress of a sync at one point in time
+type MeterStatus struct {
+	Done     int64
+	Total    int64
+	Files    int
+	Finished int
+	Speed    float64       // bytes per second over the last 10 seconds
+	ETA      time.Duration // 0 while the speed is unknown
+	Active   []TransferStatus
+}
+
+// TransferStatus is the progress of one running transfer
+type TransferStatus struct {
+	Name string
+	Size int64
+	Done int64
+}
+
+// NewTransferMeter returns a meter without planned transfers
+func NewTransferMeter() *TransferMeter {
+	return &TransferMeter{active: make(map[*meterTransfer]struct{})}
+}
+
+// Plan adds a transfer of size bytes to the total
+func (m *TransferMeter) Plan(size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.total += size
+	m.files++
+}
+
+// start registers a transfer of a body of size bytes
+func (m *TransferMeter) start(name string, size int64) *meterTransfer {
+	t := &meterTransfer{meter: m, name: name, size: size}
+	m.mu.Lock()
+	m.active[t] = struct{}{}
+	m.mu.Unlock()
+	return t
+}
+
+// set moves the transfer to position pos of its body, which goes back when a request is retried
+func (t *meterTransfer) set(pos int64) {
+	t.meter.mu.Lock()
+	defer t.meter.mu.Unlock()
+	t.meter.done += pos - t.moved
+	t.moved = pos
+}
+
+// finish ends the transfer, counting planned bytes for a successful one and none for a failed one
+func (t *meterTransfer) finish(planned int64, ok bool) {
+	m := t.meter
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.active, t)
+	m.done -= t.moved
+	if ok {
+		m.done += planned
+		m.finished++
+	}
+}
+
+// body wraps an upload body starting at offset base of the transfer
+func (t *meterTransfer) body(body io.ReadSeeker, base int64) io.ReadSeeker {
+	return &meterBody{body: body, transfer: t, base: base}
+}
+
+// meterBody reports the position of a request body to its transfer, including the seeks back to
+// the start when a request is retried
+type meterBody struct {
+	body     io.ReadSeeker
+	transfer *meterTransfer
+	base     int64
+	pos      int64
+}
+
+func (b *meterBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.pos += int64(n)
+	b.transfer.set(b.base + b.pos)
+	return n, err
+}
+
+func (b *meterBody) Seek(offset int64, whence int) (int64, error) {
+	pos, err := b.body.Seek(offset, whence)
+	if err == nil {
+		b.pos = pos
+		b.transfer.set(b.base + pos)
+	}
+	return pos, err
+}
+
+// meterReader reports the bytes read from a response body to its transfer
+type meterReader struct {
+	io.Reader
+	transfer *meterTransfer
+	pos      int64
+}
+
+func (r *meterReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.pos += int64(n)
+	r.transfer.set(r.pos)
+	return n, err
+}
+
+// Status returns the current progress, running transfers sorted by name
+func (m *TransferMeter) Status() MeterStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.samples = append(m.samples, meterSample{now, m.done})
+	for len(m.samples) > 1 && now.Sub(m.samples[0].time) > meterWindow {
+		m.samples = m.samples[1:]
+	}
+	status := MeterStatus{Done: m.done, Total: max(m.total, m.done), Files: m.files, Finished: m.finished}
+	if first := m.samples[0]; now.Sub(first.time) > time.Second {
+		status.Speed = float64(m.done-first.done) / now.Sub(first.time).Seconds()
+	}
+	if status.Speed > 0 {
+		status.ETA = time.Duration(float64(status.Total-status.Done) / status.Speed * float64(time.Second)).Round(time.Second)
+	}
+	for t := range m.active {
+		status.Active = append(status.Active, TransferStatus{Name: t.name, Size: t.size, Done: t.moved})
+	}
+	sort.Slice(status.Active, func(i, j int) bool { return status.Active[i].Name < status.Active[j].Name })
+	return status
+}
+
+// progressBar returns a bar of width characters filled to done of total
+func progressBar(done, total int64, width int) string {
+	filled := width
+	if total > 0 {
+		filled = int(int64(width) * min(done, total) / total)
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "]"
+}
+
+// percent returns done of total in percent, 100 for an empty total
+func percent(done, total int64) int64 {
+	if total <= 0 {
+		return 100
+	}
+	return 100 * min(done, total) / total
+}
+
+// Lines renders the status as one line per running transfer, at most maxActive of them, followed
+// by the total
+func (s MeterStatus) Lines(maxActive int) []string {
+	var lines []string
+	for i, transfer := range s.Active {
+		if i == maxActive {
+			lines = append(lines, fmt.Sprintf("  ... %d more transfers", len(s.Active)-maxActive))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("  %s %3d%% %10s / %-10s %s", progressBar(transfer.Done, transfer.Size, 20),
+			percent(transfer.Done, transfer.Size), formatSize(transfer.Done), formatSize(transfer.Size), path.Base(NormalizePath(transfer.Name))))
+	}
+	eta := "--"
+	if s.ETA > 0 {
+		eta = s.ETA.String()
+	}
+	lines = append(lines, fmt.Sprintf("%s %3d%% %s / %s  %s  ETA %s  %d/%d files", progressBar(s.Done, s.Total, 20),
+		percent(s.Done, s.Total), formatSize(s.Done), formatSize(s.Total), formatSpeed(s.Speed), eta, s.Finished, s.Files))
+	return lines
+}
@@ -0,0 +1,82 @@
+package r2sync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gofika/fikamime"
+)
+
+// mimeByExtension guesses the MIME type of a file from its extension
+func mimeByExtension(name string) string {
+	ext := path.Ext(name)
+	contentType := mime.TypeByExtension(ext)
+	if contentType == "" {
+		contentType = fikamime.TypeByExtension(ext)
+		if contentType == "" {
+			contentType = "application/octet-stream" // Default type
+		}
+	}
+	return contentType
+}
+
+// detectMIME sniffs the MIME type of a file from its first 512 bytes, falling back to the
+// extension when the contents are not recognized. Parameters like charset are dropped.
+func detectMIME(limit *IOLimiter, name string) (string, error) {
+	file, err := limit.open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	contentType := http.DetectContentType(head[:n])
+	if contentType == "application/octet-stream" {
+		contentType = mimeByExtension(name)
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.TrimSpace(mediaType), nil
+}
+
+// ValidateMIMEPatterns checks --include-mime and --exclude-mime patterns like image/*
+func ValidateMIMEPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || !strings.Contains(pattern, "/") {
+			return fmt.Errorf("invalid MIME pattern %q, expected type/subtype like image/*", pattern)
+		}
+	}
+	return nil
+}
+
+func matchesMIME(mediaType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), mediaType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// mimeExcluded reports whether the detected MIME type of a file is filtered out by
+// opts.IncludeMIME or opts.ExcludeMIME, without reading the file when neither is set
+func mimeExcluded(limit *IOLimiter, fullpath string, opts SyncOptions) (bool, error) {
+	if len(opts.IncludeMIME) == 0 && len(opts.ExcludeMIME) == 0 {
+		return false, nil
+	}
+	mediaType, err := detectMIME(limit, fullpath)
+	if err != nil {
+		return false, err
+	}
+	if len(opts.IncludeMIME) > 0 && !matchesMIME(mediaType, opts.IncludeMIME) {
+		return true, nil
+	}
+	return matchesMIME(mediaType, opts.ExcludeMIME), nil
+}
@@ -0,0 +1,92 @@
+package r2sync
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// MultipartUpload is an incomplete multipart upload
+type MultipartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// ListMultipartUploads lists incomplete multipart uploads under prefix
+func (r *R2Client) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	var result []MultipartUpload
+	var keyMarker, uploadIDMarker *string
+
+	for {
+		resp, err := r.client.ListMultipartUploads(ctx, &s3.ListMultipartUploadsInput{
+			Bucket:         aws.String(r.bucket),
+			Prefix:         aws.String(prefix),
+			KeyMarker:      keyMarker,
+			UploadIdMarker: uploadIDMarker,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, upload := range resp.Uploads {
+			result = append(result, MultipartUpload{
+				Key:       aws.ToString(upload.Key),
+				UploadID:  aws.ToString(upload.UploadId),
+				Initiated: aws.ToTime(upload.Initiated),
+			})
+		}
+
+		if !aws.ToBool(resp.IsTruncated) {
+			break
+		}
+		keyMarker = resp.NextKeyMarker
+		uploadIDMarker = resp.NextUploadIdMarker
+	}
+
+	return result, nil
+}
+
+// AbortMultipartUpload aborts an incomplete multipart upload and frees its stored parts
+func (r *R2Client) AbortMultipartUpload(ctx context.Context, upload MultipartUpload, dryRun bool) error {
+	if dryRun {
+		log.Printf("(dryrun) abort multipart: %s, initiated %s\n", r.RemotePath(upload.Key), upload.Initiated.Format(time.RFC3339))
+		return nil
+	}
+
+	_, err := r.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(r.bucket),
+		Key:      aws.String(upload.Key),
+		UploadId: aws.String(upload.UploadID),
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("abort multipart: %s, initiated %s\n", r.RemotePath(upload.Key), upload.Initiated.Format(time.RFC3339))
+	return nil
+}
+
+// AbortStaleMultipartUploads aborts incomplete multipart uploads under prefix initiated before olderThan ago
+// and returns how many were aborted
+func (r *R2Client) AbortStaleMultipartUploads(ctx context.Context, prefix string, olderThan time.Duration, dryRun bool) (int, error) {
+	uploads, err := r.ListMultipartUploads(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	abortCount := 0
+	for _, upload := range uploads {
+		if upload.Initiated.After(cutoff) {
+			continue
+		}
+		if err := r.AbortMultipartUpload(ctx, upload, dryRun); err != nil {
+			log.Printf("abort multipart failed %s: %v\n", r.RemotePath(upload.Key), err)
+			continue
+		}
+		abortCount++
+	}
+	return abortCount, nil
+}
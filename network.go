@@ -0,0 +1,156 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// NetworkOptions controls how connections to the endpoints are made
+type NetworkOptions struct {
+	IPVersion string        // "4" or "6" forces the address family, empty uses both
+	DNS       string        // resolver address used instead of the system resolver, host or host:port
+	CacheTTL  time.Duration // how long resolved addresses are reused, 0 resolves on every dial
+	IdleConns int           // idle connections kept per host, at least the sdk default
+
+	mu       sync.Mutex
+	resolver *net.Resolver
+	cache    map[string]dnsEntry
+}
+
+// dnsEntry is a cached lookup result
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func ParseIPVersion(value string) (string, error) {
+	switch value {
+	case "", "4", "6":
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid ip version: %s (valid values: 4, 6)", value)
+	}
+}
+
+// dnsServerAddr adds the default port to a resolver address
+func dnsServerAddr(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, "53")
+}
+
+// lookup resolves host through the configured resolver and cache. When a lookup fails after the
+// entry expired the stale addresses are used, so flaky DNS doesn't fail transfers.
+func (n *NetworkOptions) lookup(ctx context.Context, host string) ([]string, error) {
+	n.mu.Lock()
+	if n.resolver == nil {
+		n.resolver = net.DefaultResolver
+		if n.DNS != "" {
+			server := dnsServerAddr(n.DNS)
+			n.resolver = &net.Resolver{
+				PreferGo: true,
+				Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, network, server)
+				},
+			}
+		}
+		n.cache = make(map[string]dnsEntry)
+	}
+	entry, cached := n.cache[host]
+	n.mu.Unlock()
+	if cached && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := n.resolver.LookupHost(ctx, host)
+	if err != nil {
+		if cached {
+			return entry.addrs, nil
+		}
+		return nil, err
+	}
+	if n.CacheTTL > 0 {
+		n.mu.Lock()
+		n.cache[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(n.CacheTTL)}
+		n.mu.Unlock()
+	}
+	return addrs, nil
+}
+
+func (n *NetworkOptions) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if n.IPVersion != "" {
+		network = "tcp" + n.IPVersion
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil || (n.DNS == "" && n.CacheTTL <= 0) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := n.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, ip := range addrs {
+		parsed := net.ParseIP(ip)
+		if (n.IPVersion == "4" && parsed.To4() == nil) || (n.IPVersion == "6" && parsed.To4() != nil) {
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no IPv%s address for %s", n.IPVersion, host)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// apply replaces the http client of an s3 client with one dialing through these options
+func (n *NetworkOptions) Apply(o *s3.Options) {
+	o.HTTPClient = awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		tr.DialContext = n.dial
+		tr.MaxIdleConnsPerHost = max(tr.MaxIdleConnsPerHost, n.IdleConns)
+	})
+}
+
+// WithDualStack uses the dual-stack (IPv4 and IPv6) endpoint of the bucket, AWS only
+func WithDualStack() S3Option {
+	return func(o *s3.Options) {
+		o.EndpointOptions.UseDualStackEndpoint = aws.DualStackEndpointStateEnabled
+	}
+}
+
+// Prewarm opens n connections to the endpoint with concurrent requests, so the first wave of
+// small uploads doesn't wait for TCP and TLS handshakes. The connections stay in the idle pool.
+func (r *R2Client) Prewarm(ctx context.Context, n int) {
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.Probe(ctx); err != nil {
+				failed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	log.Printf("%d connections pre-warmed: %s\n", n-int(failed.Load()), r.RemotePath(""))
+}
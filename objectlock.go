@@ -0,0 +1,157 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// RetentionProtectedError is returned when a delete is refused because the object is under Object Lock retention or legal hold
+type RetentionProtectedError struct {
+	Key         string
+	Mode        types.ObjectLockRetentionMode
+	RetainUntil time.Time
+	LegalHold   bool
+	Err         error
+}
+
+func (e *RetentionProtectedError) Error() string {
+	if e.LegalHold {
+		return fmt.Sprintf("%s is under legal hold", e.Key)
+	}
+	return fmt.Sprintf("%s is retention-protected (%s until %s)", e.Key, strings.ToLower(string(e.Mode)), e.RetainUntil.Format(time.RFC3339))
+}
+
+func (e *RetentionProtectedError) Unwrap() error {
+	return e.Err
+}
+
+// ParseDuration extends time.ParseDuration with a "d" (day) unit, e.g. 30d or 1d12h
+func ParseDuration(s string) (time.Duration, error) {
+	if days, rest, found := strings.Cut(s, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d := time.Duration(n) * 24 * time.Hour
+		if rest == "" {
+			return d, nil
+		}
+		r, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return d + r, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func ParseRetentionMode(mode string) (types.ObjectLockMode, error) {
+	switch strings.ToLower(mode) {
+	case "":
+		return "", nil
+	case "governance":
+		return types.ObjectLockModeGovernance, nil
+	case "compliance":
+		return types.ObjectLockModeCompliance, nil
+	}
+	return "", fmt.Errorf("invalid retention mode %q, must be governance or compliance", mode)
+}
+
+func ParseLegalHold(status string) (types.ObjectLockLegalHoldStatus, error) {
+	switch strings.ToLower(status) {
+	case "":
+		return "", nil
+	case "on":
+		return types.ObjectLockLegalHoldStatusOn, nil
+	case "off":
+		return types.ObjectLockLegalHoldStatusOff, nil
+	}
+	return "", fmt.Errorf("invalid legal hold %q, must be on or off", status)
+}
+
+// LegalHold returns the legal hold status of a remote object, objects without a hold report OFF
+func (r *R2Client) LegalHold(ctx context.Context, remotePath string) (types.ObjectLockLegalHoldStatus, error) {
+	resp, err := r.client.GetObjectLegalHold(ctx, &s3.GetObjectLegalHoldInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(remotePath),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchObjectLockConfiguration" {
+			return types.ObjectLockLegalHoldStatusOff, nil
+		}
+		return "", err
+	}
+	if resp.LegalHold == nil || resp.LegalHold.Status == "" {
+		return types.ObjectLockLegalHoldStatusOff, nil
+	}
+	return resp.LegalHold.Status, nil
+}
+
+// SetLegalHold places or clears the legal hold of a remote object
+func (r *R2Client) SetLegalHold(ctx context.Context, remotePath string, status types.ObjectLockLegalHoldStatus, dryRun bool) error {
+	if dryRun {
+		log.Printf("(dryrun) legal hold %s: %s\n", strings.ToLower(string(status)), r.RemotePath(remotePath))
+		return nil
+	}
+
+	_, err := r.client.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(r.bucket),
+		Key:       aws.String(remotePath),
+		LegalHold: &types.ObjectLockLegalHold{Status: status},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("legal hold %s: %s\n", strings.ToLower(string(status)), r.RemotePath(remotePath))
+	return nil
+}
+
+// applyObjectLock sets the configured retention and legal hold on an upload
+func (r *R2Client) applyObjectLock(input *s3.PutObjectInput) {
+	if r.RetentionMode != "" {
+		input.ObjectLockMode = r.RetentionMode
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(r.RetentionPeriod))
+	}
+	if r.LegalHoldStatus != "" {
+		input.ObjectLockLegalHoldStatus = r.LegalHoldStatus
+	}
+}
+
+// retentionError checks whether a failed delete was caused by Object Lock retention or legal hold
+func (r *R2Client) retentionError(ctx context.Context, remotePath string, deleteErr error) error {
+	if status, err := r.LegalHold(ctx, remotePath); err == nil && status == types.ObjectLockLegalHoldStatusOn {
+		return &RetentionProtectedError{
+			Key:       r.RemotePath(remotePath),
+			LegalHold: true,
+			Err:       deleteErr,
+		}
+	}
+
+	resp, err := r.client.GetObjectRetention(ctx, &s3.GetObjectRetentionInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(remotePath),
+	})
+	if err != nil || resp.Retention == nil || resp.Retention.RetainUntilDate == nil {
+		return deleteErr
+	}
+	if !resp.Retention.RetainUntilDate.After(time.Now()) {
+		return deleteErr
+	}
+	return &RetentionProtectedError{
+		Key:         r.RemotePath(remotePath),
+		Mode:        resp.Retention.Mode,
+		RetainUntil: *resp.Retention.RetainUntilDate,
+		Err:         deleteErr,
+	}
+}
@@ -0,0 +1,245 @@
+package r2sync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// clientConfig collects the options of NewClient
+type clientConfig struct {
+	bucket     string
+	scheme     string
+	httpClient *http.Client
+	optFns     []func(*s3.Options)
+	urlOptFns  []func(*s3.Options) // options of the remote url, applied last
+	r2Env      bool                // read the R2_* environment variables for r2:// clients
+	retry      *RetryPolicy
+	settings   []func(*R2Client) error // applied to the new client
+	err        error
+}
+
+// ClientOption configures a client built by NewClient
+type ClientOption interface {
+	applyClient(*clientConfig)
+}
+
+type clientOptionFunc func(*clientConfig)
+
+func (f clientOptionFunc) applyClient(c *clientConfig) {
+	f(c)
+}
+
+// S3Option is an option of the underlying s3 client. WithEndpoint, WithRequestLimit and the other
+// options of the s3 client return one, so they can be passed to NewClient directly and anywhere a
+// func(*s3.Options) is expected, like WithClientOptions of a Syncer.
+type S3Option func(*s3.Options)
+
+func (f S3Option) applyClient(c *clientConfig) {
+	c.optFns = append(c.optFns, f)
+}
+
+// WithBucket selects the bucket of the client, shown as r2://bucket/ in logs
+func WithBucket(bucket string) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		c.bucket = bucket
+	})
+}
+
+// WithRemoteURL selects the scheme and bucket of a remote url like r2://bucket/path and applies
+// the client options given as its query parameters after all other options
+func WithRemoteURL(remoteURL string) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		scheme, bucket, _, err := ParseRemoteURL(remoteURL)
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.scheme, c.bucket = scheme, bucket
+		c.urlOptFns, c.err = RemoteURLOptions(remoteURL)
+	})
+}
+
+// WithCredentials signs requests with the credentials of provider instead of the default chain,
+// e.g. credentials.NewStaticCredentialsProvider for the access keys of an R2 API token
+func WithCredentials(provider aws.CredentialsProvider) ClientOption {
+	return S3Option(func(o *s3.Options) {
+		o.Credentials = provider
+	})
+}
+
+// WithR2Account points the client at the R2 endpoint of a Cloudflare account and signs requests with
+// the access key pair of an R2 API token
+func WithR2Account(accountID, accessKeyID, secretAccessKey string) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		if accountID == "" || accessKeyID == "" || secretAccessKey == "" {
+			c.err = fmt.Errorf("R2 account id, access key id and secret access key are required")
+			return
+		}
+		endpoint, _ := R2Endpoint(accountID, "")
+		c.optFns = append(c.optFns, WithEndpoint(endpoint), r2Credentials(accessKeyID, secretAccessKey))
+	})
+}
+
+// WithR2Env configures r2:// clients from the environment before all other options: R2_ACCOUNT_ID
+// selects the endpoint of the account and R2_ACCESS_KEY_ID with R2_SECRET_ACCESS_KEY sign requests
+// instead of the AWS credential chain. Unset variables and clients of other schemes are unchanged.
+func WithR2Env() ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		c.r2Env = true
+	})
+}
+
+// r2EnvOptions returns the options of the R2_* environment variables
+func r2EnvOptions() ([]func(*s3.Options), error) {
+	var optFns []func(*s3.Options)
+	if accountID := os.Getenv("R2_ACCOUNT_ID"); accountID != "" {
+		endpoint, _ := R2Endpoint(accountID, "")
+		optFns = append(optFns, WithEndpoint(endpoint))
+	}
+	accessKeyID, secretAccessKey := os.Getenv("R2_ACCESS_KEY_ID"), os.Getenv("R2_SECRET_ACCESS_KEY")
+	if (accessKeyID == "") != (secretAccessKey == "") {
+		return nil, fmt.Errorf("R2_ACCESS_KEY_ID and R2_SECRET_ACCESS_KEY must be set together")
+	}
+	if accessKeyID != "" {
+		optFns = append(optFns, r2Credentials(accessKeyID, secretAccessKey))
+	}
+	return optFns, nil
+}
+
+// r2Credentials signs requests with the access key pair of an R2 API token
+func r2Credentials(accessKeyID, secretAccessKey string) func(*s3.Options) {
+	provider := credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, "")
+	return func(o *s3.Options) {
+		o.Credentials = provider
+	}
+}
+
+// WithHTTPClient sends requests through client, e.g. one with its own transport or proxy. Options
+// wrapping the http client like WithRequestLimit wrap this one regardless of their order.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		c.httpClient = client
+	})
+}
+
+// WithRetryPolicy replaces the sdk default retries of requests and decides which failed uploads
+// Sync attempts once more after the other transfers
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		c.optFns = append(c.optFns, policy.Apply)
+		c.retry = &policy
+	})
+}
+
+// WithStrictKeys makes downloads refuse keys with .. segments or absolute-looking keys instead of
+// sanitizing them
+func WithStrictKeys() ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		c.settings = append(c.settings, func(r *R2Client) error {
+			r.StrictKeys = true
+			return nil
+		})
+	})
+}
+
+// WithDownloadTempDir makes downloads write their partial files to dir, which must be an existing
+// directory, instead of next to their destination
+func WithDownloadTempDir(dir string) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		c.settings = append(c.settings, func(r *R2Client) error {
+			return r.SetDownloadTempDir(dir)
+		})
+	})
+}
+
+// WithIOLimiter bounds the local files read at the same time by the client, clients reading the
+// same disk share one limiter
+func WithIOLimiter(limit *IOLimiter) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		c.settings = append(c.settings, func(r *R2Client) error {
+			r.IOLimit = limit
+			return nil
+		})
+	})
+}
+
+// WithDeterministicLogs drops timings from transfer logs, so identical runs log identical lines
+func WithDeterministicLogs() ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		c.settings = append(c.settings, func(r *R2Client) error {
+			r.DeterministicLogs = true
+			return nil
+		})
+	})
+}
+
+// WithHeartbeat calls fn whenever a sync, event or replication loop makes progress, e.g. to ping a
+// watchdog
+func WithHeartbeat(fn func()) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		c.settings = append(c.settings, func(r *R2Client) error {
+			r.Heartbeat = fn
+			return nil
+		})
+	})
+}
+
+// WithS3Options applies plain options of the underlying s3 client, e.g. ones from the AWS SDK
+func WithS3Options(optFns ...func(*s3.Options)) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		c.optFns = append(c.optFns, optFns...)
+	})
+}
+
+// NewClient returns a client configured by options, one of which must select the bucket. The
+// region, credentials and endpoint default to the AWS SDK configuration of the environment.
+func NewClient(ctx context.Context, options ...ClientOption) (*R2Client, error) {
+	c := &clientConfig{scheme: "r2"}
+	for _, option := range options {
+		option.applyClient(c)
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.bucket == "" {
+		return nil, fmt.Errorf("no bucket given, use WithBucket or WithRemoteURL")
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the AWS configuration: %v", err)
+	}
+	var optFns []func(*s3.Options)
+	if c.r2Env && c.scheme == "r2" {
+		if optFns, err = r2EnvOptions(); err != nil {
+			return nil, err
+		}
+	}
+	if c.httpClient != nil {
+		httpClient := c.httpClient
+		optFns = append(optFns, func(o *s3.Options) {
+			o.HTTPClient = httpClient
+		})
+	}
+	optFns = append(append(optFns, c.optFns...), c.urlOptFns...)
+	client := &R2Client{
+		client: s3.NewFromConfig(cfg, optFns...),
+		bucket: c.bucket,
+		scheme: c.scheme,
+		retry:  c.retry,
+
+		SmallObjectThreshold: defaultSmallObjectThreshold,
+	}
+	for _, setting := range c.settings {
+		if err := setting(client); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
@@ -0,0 +1,440 @@
+package r2sync
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	packDir       = ".r2sync-pack"
+	packIndexName = "index.json"
+)
+
+// PackOptions bundles small files into tar objects instead of uploading them one by one
+type PackOptions struct {
+	Threshold  int64  // files up to this size are packed
+	BundleSize int64  // a bundle is closed once it reaches this size
+	Format     string // tar or tar.zst
+}
+
+// packEntry locates a packed file
+type packEntry struct {
+	Bundle  string    `json:"bundle"` // bundle name inside the pack directory
+	Offset  int64     `json:"offset"` // offset of the file data in the uncompressed tar stream
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	MD5     string    `json:"md5"`
+}
+
+// packIndex maps paths relative to the remote prefix to their bundles
+type packIndex struct {
+	Version int                  `json:"version"`
+	Files   map[string]packEntry `json:"files"`
+}
+
+// packFile is a local file selected for packing
+type packFile struct {
+	relPath  string
+	fullPath string
+	info     os.FileInfo
+}
+
+func packKey(remotePath, name string) string {
+	return path.Join(remotePath, packDir, name)
+}
+
+// isPackKey reports whether key is an index or bundle object of the pack under remotePath
+func isPackKey(remotePath, key string) bool {
+	return strings.HasPrefix(key, packKey(remotePath, "")+"/")
+}
+
+func ParsePackFormat(format string) (string, error) {
+	switch format {
+	case "tar", "tar.zst":
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid pack format: %s (valid values: tar, tar.zst)", format)
+	}
+}
+
+func (r *R2Client) loadPackIndex(ctx context.Context, remotePath string) (*packIndex, error) {
+	index := &packIndex{Version: 1, Files: make(map[string]packEntry)}
+	resp, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(packKey(remotePath, packIndexName)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return index, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := decompressed(resp)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(index); err != nil {
+		return nil, fmt.Errorf("invalid pack index %s: %v", r.RemotePath(packKey(remotePath, packIndexName)), err)
+	}
+	if index.Files == nil {
+		index.Files = make(map[string]packEntry)
+	}
+	return index, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// bundleWriter writes a tar bundle to a temporary file
+type bundleWriter struct {
+	file   *os.File
+	limit  *IOLimiter // of the files added
+	format string
+	hash   hash.Hash // sha256 of the bundle object, names the bundle
+	zw     io.WriteCloser
+	stream *countingWriter // uncompressed tar stream
+	tw     *tar.Writer
+}
+
+func newBundleWriter(limit *IOLimiter, format string) (*bundleWriter, error) {
+	file, err := os.CreateTemp("", "r2sync-bundle-*."+format)
+	if err != nil {
+		return nil, err
+	}
+	b := &bundleWriter{file: file, limit: limit, format: format, hash: sha256.New()}
+	var w io.Writer = io.MultiWriter(file, b.hash)
+	if format == "tar.zst" {
+		if b.zw, err = zstd.NewWriter(w); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return nil, err
+		}
+		w = b.zw
+	}
+	b.stream = &countingWriter{w: w}
+	b.tw = tar.NewWriter(b.stream)
+	return b, nil
+}
+
+// Add appends a file and returns the offset of its data and its md5
+func (b *bundleWriter) Add(f packFile) (int64, string, error) {
+	file, err := b.limit.open(f.fullPath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	header, err := tar.FileInfoHeader(f.info, "")
+	if err != nil {
+		return 0, "", err
+	}
+	header.Name = f.relPath
+	if err := b.tw.WriteHeader(header); err != nil {
+		return 0, "", err
+	}
+	offset := b.stream.n
+	sum := md5.New()
+	if _, err := io.CopyN(b.tw, io.TeeReader(file, sum), f.info.Size()); err != nil {
+		return 0, "", err
+	}
+	return offset, hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// Finish completes the bundle and returns its name
+func (b *bundleWriter) Finish() (string, error) {
+	err := b.tw.Close()
+	if b.zw != nil && err == nil {
+		err = b.zw.Close()
+	}
+	if closeErr := b.file.Close(); err == nil {
+		err = closeErr
+	}
+	return "bundle-" + hex.EncodeToString(b.hash.Sum(nil))[:16] + "." + b.format, err
+}
+
+func (b *bundleWriter) Remove() {
+	b.file.Close()
+	os.Remove(b.file.Name())
+}
+
+// SyncPack bundles files into tar objects under remotePath/.r2sync-pack and updates the index
+// mapping paths to bundles and offsets. Unchanged files keep their bundle and bundles no longer
+// referenced are deleted. Without deleteMissing, entries of files missing locally are kept.
+// Returns the number of packed files.
+func (r *R2Client) SyncPack(ctx context.Context, remotePath string, files []packFile, opts PackOptions, sizeOnly, deleteMissing, dryRun bool) (int, error) {
+	index, err := r.loadPackIndex(ctx, remotePath)
+	if err != nil {
+		return 0, err
+	}
+	next := &packIndex{Version: 1, Files: make(map[string]packEntry)}
+	if !deleteMissing {
+		for relPath, entry := range index.Files {
+			next.Files[relPath] = entry
+		}
+	}
+
+	var changed []packFile
+	for _, f := range files {
+		old, exists := index.Files[f.relPath]
+		switch {
+		case !exists || old.Size != f.info.Size():
+			changed = append(changed, f)
+		case sizeOnly || old.ModTime.Equal(f.info.ModTime()):
+			next.Files[f.relPath] = old
+		default:
+			etag, err := calcETag(r.IOLimit, f.fullPath)
+			if err != nil {
+				return 0, err
+			}
+			if strings.Trim(etag, `"`) != old.MD5 {
+				changed = append(changed, f)
+				continue
+			}
+			old.ModTime = f.info.ModTime()
+			next.Files[f.relPath] = old
+		}
+	}
+	if len(changed) == 0 && len(next.Files) == len(index.Files) {
+		return 0, nil
+	}
+	if dryRun {
+		log.Printf("(dryrun) pack: %d files -> %s\n", len(changed), r.RemotePath(packKey(remotePath, "")))
+		return len(changed), nil
+	}
+
+	var bundle *bundleWriter
+	var pending []string // files in the open bundle
+	flush := func() error {
+		name, err := bundle.Finish()
+		defer bundle.Remove()
+		if err != nil {
+			return err
+		}
+		if err := r.UploadFile(ctx, bundle.file.Name(), packKey(remotePath, name), false); err != nil {
+			return err
+		}
+		for _, relPath := range pending {
+			entry := next.Files[relPath]
+			entry.Bundle = name
+			next.Files[relPath] = entry
+		}
+		bundle, pending = nil, nil
+		return nil
+	}
+	for _, f := range changed {
+		if bundle == nil {
+			if bundle, err = newBundleWriter(r.IOLimit, opts.Format); err != nil {
+				return 0, err
+			}
+		}
+		offset, sum, err := bundle.Add(f)
+		if err != nil {
+			bundle.Remove()
+			return 0, fmt.Errorf("failed to pack %s: %v", f.fullPath, err)
+		}
+		next.Files[f.relPath] = packEntry{Offset: offset, Size: f.info.Size(), ModTime: f.info.ModTime(), MD5: sum}
+		pending = append(pending, f.relPath)
+		if bundle.stream.n >= opts.BundleSize {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if bundle != nil {
+		if err := flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	// the index is written after its bundles, so readers never see missing bundles
+	if err := r.putPackIndex(ctx, remotePath, next); err != nil {
+		return 0, err
+	}
+
+	referenced := make(map[string]bool)
+	for _, entry := range next.Files {
+		referenced[entry.Bundle] = true
+	}
+	objects, err := r.ListObjects(ctx, packKey(remotePath, "bundle-"))
+	if err != nil {
+		return len(changed), err
+	}
+	for key := range objects {
+		if !referenced[path.Base(key)] {
+			if err := r.DeleteObject(ctx, key, false); err != nil {
+				log.Printf("delete failed %s: %s\n", r.RemotePath(key), ErrorDetail(err))
+			}
+		}
+	}
+	return len(changed), nil
+}
+
+func (r *R2Client) putPackIndex(ctx context.Context, remotePath string, index *packIndex) error {
+	file, err := os.CreateTemp("", "r2sync-index-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	err = json.NewEncoder(file).Encode(index)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return r.UploadFile(ctx, file.Name(), packKey(remotePath, packIndexName), false)
+}
+
+// Unpack extracts the packed files under remotePath into localDir, downloading every bundle once.
+// Files whose local copy has the packed size and modification time are skipped.
+func (r *R2Client) Unpack(ctx context.Context, remotePath, localDir string, dryRun bool) (int, error) {
+	index, err := r.loadPackIndex(ctx, remotePath)
+	if err != nil {
+		return 0, err
+	}
+	wanted := make(map[string]map[string]packEntry) // bundle -> path -> entry
+	for relPath, entry := range index.Files {
+		localPath, err := localPathFor(localDir, relPath, r.StrictKeys)
+		if err != nil {
+			return 0, err
+		}
+		if info, err := os.Stat(localPath); err == nil && info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime) {
+			continue
+		}
+		if wanted[entry.Bundle] == nil {
+			wanted[entry.Bundle] = make(map[string]packEntry)
+		}
+		wanted[entry.Bundle][relPath] = entry
+	}
+	return r.unpackBundles(ctx, remotePath, localDir, wanted, dryRun)
+}
+
+// unpackBundles extracts the given entries of each bundle under remotePath into localDir
+func (r *R2Client) unpackBundles(ctx context.Context, remotePath, localDir string, wanted map[string]map[string]packEntry, dryRun bool) (int, error) {
+	count := 0
+	for name, entries := range wanted {
+		if dryRun {
+			for relPath := range entries {
+				localPath, _ := localPathFor(localDir, relPath, r.StrictKeys)
+				log.Printf("(dryrun) unpack: %s -> %s\n", r.RemotePath(packKey(remotePath, name)), localPath)
+			}
+			count += len(entries)
+			continue
+		}
+		n, err := r.unpackBundle(ctx, packKey(remotePath, name), localDir, entries)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func (r *R2Client) unpackBundle(ctx context.Context, key, localDir string, entries map[string]packEntry) (int, error) {
+	resp, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := decompressed(resp)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	var stream io.Reader = body
+	if strings.HasSuffix(key, ".zst") {
+		decoder, err := zstd.NewReader(body)
+		if err != nil {
+			return 0, err
+		}
+		defer decoder.Close()
+		stream = decoder
+	}
+
+	count := 0
+	tr := tar.NewReader(stream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		entry, ok := entries[header.Name]
+		if !ok {
+			continue
+		}
+		localPath, err := localPathFor(localDir, header.Name, r.StrictKeys)
+		if err != nil {
+			return count, err
+		}
+		if err := writeLocalFile(r.tempDir, localPath, tr, entry.ModTime); err != nil {
+			return count, err
+		}
+		log.Printf("unpack: %s -> %s\n", r.RemotePath(key), localPath)
+		count++
+	}
+}
+
+// writeLocalFile writes r to localPath through a temporary file that is renamed on completion
+func writeLocalFile(tempDir, localPath string, r io.Reader, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	file, err := createPartial(tempDir, localPath)
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tempPath, modTime, modTime)
+	}
+	if err == nil {
+		err = commitPartial(tempDir, tempPath, localPath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package r2sync
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackUnpack(t *testing.T) {
+	for _, format := range []string{"tar", "tar.zst"} {
+		t.Run(format, func(t *testing.T) {
+			f, client := newFakeS3(t)
+			ctx := context.Background()
+			files := map[string]string{
+				"small.txt":     "small",
+				"dir/small.txt": "nested",
+				"large.bin":     strings.Repeat("x", 200),
+			}
+			source := t.TempDir()
+			writeFiles(t, source, files)
+
+			opts := SyncOptions{Recursive: true, Pack: &PackOptions{Threshold: 100, BundleSize: 1 << 20, Format: format}}
+			if err := client.Sync(ctx, source, "site", opts); err != nil {
+				t.Fatal(err)
+			}
+			var bundles int
+			for _, key := range f.keys() {
+				switch {
+				case strings.HasPrefix(key, "site/.r2sync-pack/bundle-") && strings.HasSuffix(key, "."+format):
+					bundles++
+				case key != "site/.r2sync-pack/index.json" && key != "site/large.bin":
+					t.Errorf("unexpected object %s", key)
+				}
+			}
+			if bundles != 1 {
+				t.Errorf("%d bundles, want 1", bundles)
+			}
+
+			target := t.TempDir()
+			if n, err := client.Unpack(ctx, "site", target, false); err != nil || n != 2 {
+				t.Fatalf("Unpack = %d, %v, want 2 files", n, err)
+			}
+			want := map[string]string{"small.txt": "small", "dir/small.txt": "nested"}
+			if got := readFiles(t, target); !reflect.DeepEqual(got, want) {
+				t.Errorf("unpacked files = %v, want %v", got, want)
+			}
+			if n, err := client.Unpack(ctx, "site", target, false); err != nil || n != 0 {
+				t.Errorf("Unpack of unchanged files = %d, %v, want 0", n, err)
+			}
+
+			// a pull unpacks the packed files and keeps them when deleting
+			pulled := t.TempDir()
+			writeFiles(t, pulled, map[string]string{"small.txt": "stale copy", "extra.txt": "extra"})
+			if err := client.Pull(ctx, "site", pulled, SyncOptions{Recursive: true, Delete: true}); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFiles(t, pulled); !reflect.DeepEqual(got, files) {
+				t.Errorf("pulled files = %v, want %v", got, files)
+			}
+		})
+	}
+}
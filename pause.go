@@ -0,0 +1,56 @@
+package r2sync
+
+import (
+	"context"
+	"sync"
+)
+
+// PauseGate holds back new transfers while paused, in-flight transfers are not affected
+type PauseGate struct {
+	mu     sync.Mutex
+	resume chan struct{} // non-nil while paused, closed on resume
+}
+
+func (g *PauseGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume == nil {
+		g.resume = make(chan struct{})
+	}
+}
+
+func (g *PauseGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume != nil {
+		close(g.resume)
+		g.resume = nil
+	}
+}
+
+func (g *PauseGate) Paused() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resume != nil
+}
+
+// Wait blocks while paused until resumed, ctx is cancelled or drain is closed
+func (g *PauseGate) Wait(ctx context.Context, drain <-chan struct{}) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	resume := g.resume
+	g.mu.Unlock()
+	if resume == nil {
+		return
+	}
+	select {
+	case <-resume:
+	case <-ctx.Done():
+	case <-drain:
+	}
+}
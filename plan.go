@@ -0,0 +1,132 @@
+package r2sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrPlanDrift is returned by Sync when the plan hash differs from SyncOptions.ExpectPlan
+var ErrPlanDrift = errors.New("plan differs from the expected plan")
+
+// emptyPlanHash is the hash of a plan without operations
+var emptyPlanHash = newPlanHash(R2Pricing, nil).Sum()
+
+// Operation is a transfer or delete planned by a sync, with the requests it is expected to make
+type Operation struct {
+	Action        string  `json:"action"`                  // upload, copy, download, delete or pack
+	Key           string  `json:"key"`                     // url of the object, local path of downloads and local deletes
+	Size          int64   `json:"size,omitempty"`          // bytes transferred, files bundled by a pack
+	Reason        string  `json:"reason,omitempty"`        // why the operation is needed
+	ClassA        int     `json:"class_a,omitempty"`       // Class A requests, an upper bound for chunked uploads
+	ClassB        int     `json:"class_b,omitempty"`       // Class B requests
+	StorageDelta  int64   `json:"storage_delta,omitempty"` // bytes the stored objects grow by, negative when they shrink
+	EstimatedCost float64 `json:"estimated_cost"`          // USD of the requests and egress at the sync pricing
+}
+
+// planHash collects the operations a sync plans and hashes them sorted, so the hash only depends on
+// what changes and not on the order transfers were scheduled in
+type planHash struct {
+	mu         sync.Mutex
+	operations []string
+	pricing    Pricing
+	estimate   CostEstimate
+	observe    func(Operation) // receives every operation, may be nil
+}
+
+// newPlanHash returns a plan estimating costs at pricing
+func newPlanHash(pricing Pricing, observe func(Operation)) *planHash {
+	return &planHash{pricing: pricing, observe: observe}
+}
+
+// add records an operation, hashed as line
+func (p *planHash) add(operation Operation, line string) {
+	operation.EstimatedCost = operation.cost(p.pricing)
+	p.mu.Lock()
+	p.operations = append(p.operations, line)
+	p.estimate.add(operation, p.pricing)
+	p.mu.Unlock()
+	if p.observe != nil {
+		p.observe(operation)
+	}
+}
+
+// Upload records an upload of size bytes to the target url replacing an object of replaced bytes,
+// split into chunks of chunkSize when it is set
+func (p *planHash) Upload(target string, size, replaced, chunkSize int64, reason string) {
+	requests := 1
+	if chunkSize > 0 {
+		// every chunk and the manifest, chunks already stored are skipped
+		requests += int((size + chunkSize - 1) / chunkSize)
+	}
+	p.add(Operation{Action: "upload", Key: target, Size: size, Reason: reason, ClassA: requests, StorageDelta: size - replaced},
+		fmt.Sprintf("upload %s %d", target, size))
+}
+
+// Copy records a server-side copy of size bytes to the target url replacing an object of replaced
+// bytes
+func (p *planHash) Copy(target string, size, replaced int64, reason string) {
+	p.add(Operation{Action: "copy", Key: target, Size: size, Reason: reason, ClassA: 1, StorageDelta: size - replaced},
+		fmt.Sprintf("copy %s %d", target, size))
+}
+
+// Download records a download of size bytes to the local target path
+func (p *planHash) Download(target string, size int64, reason string) {
+	p.add(Operation{Action: "download", Key: target, Size: size, Reason: reason, ClassB: 1},
+		fmt.Sprintf("download %s %d", target, size))
+}
+
+// Delete records a delete of the target url of an object of size bytes, or of a local path with
+// size 0, deletes are free of charge
+func (p *planHash) Delete(target string, size int64, reason string) {
+	p.add(Operation{Action: "delete", Key: target, Reason: reason, StorageDelta: -size}, fmt.Sprintf("delete %s", target))
+}
+
+// Pack records count small files packed into bundles under the target url, estimated as one
+// bundle and the index
+func (p *planHash) Pack(target string, count int) {
+	p.add(Operation{Action: "pack", Key: target, Size: int64(count), Reason: "small files changed", ClassA: 2},
+		fmt.Sprintf("pack %s %d", target, count))
+}
+
+// Estimate returns the sum of the recorded operations
+func (p *planHash) Estimate() CostEstimate {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.estimate
+}
+
+func (p *planHash) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.operations)
+}
+
+// Sum returns the hash as sha256:<hex>
+func (p *planHash) Sum() string {
+	p.mu.Lock()
+	operations := append([]string{}, p.operations...)
+	p.mu.Unlock()
+	sort.Strings(operations)
+	sum := sha256.New()
+	for _, operation := range operations {
+		fmt.Fprintln(sum, operation)
+	}
+	return "sha256:" + hex.EncodeToString(sum.Sum(nil))
+}
+
+// ParseExpectPlan accepts a plan hash or "empty" for a plan without operations
+func ParseExpectPlan(value string) (string, error) {
+	if value == "empty" {
+		return emptyPlanHash, nil
+	}
+	digest, ok := strings.CutPrefix(value, "sha256:")
+	if _, err := hex.DecodeString(digest); !ok || err != nil || len(digest) != 64 {
+		return "", fmt.Errorf("invalid plan hash %q, expected sha256:<64 hex digits> or empty", value)
+	}
+	return value, nil
+}
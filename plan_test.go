@@ -0,0 +1,92 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPlanHashOrder(t *testing.T) {
+	a := newPlanHash(R2Pricing, nil)
+	a.Upload("r2://bucket/a", 1, 0, 0, "no object")
+	a.Delete("r2://bucket/b", 2, "no local file")
+	b := newPlanHash(R2Pricing, nil)
+	b.Delete("r2://bucket/b", 2, "no local file")
+	b.Upload("r2://bucket/a", 1, 0, 0, "size differs from the object")
+	if a.Sum() != b.Sum() {
+		t.Errorf("plan hash depends on the order of operations: %s != %s", a.Sum(), b.Sum())
+	}
+	c := newPlanHash(R2Pricing, nil)
+	c.Upload("r2://bucket/a", 2, 0, 0, "no object")
+	c.Delete("r2://bucket/b", 2, "no local file")
+	if a.Sum() == c.Sum() {
+		t.Error("plan hash doesn't change with the upload size")
+	}
+	if got := newPlanHash(R2Pricing, nil).Sum(); got != emptyPlanHash {
+		t.Errorf("empty plan hash = %s, want %s", got, emptyPlanHash)
+	}
+}
+
+func TestParseExpectPlan(t *testing.T) {
+	if got, err := ParseExpectPlan("empty"); err != nil || got != emptyPlanHash {
+		t.Errorf("ParseExpectPlan(empty) = %q, %v, want %q", got, err, emptyPlanHash)
+	}
+	valid := "sha256:" + strings.Repeat("ab", 32)
+	if got, err := ParseExpectPlan(valid); err != nil || got != valid {
+		t.Errorf("ParseExpectPlan(%q) = %q, %v", valid, got, err)
+	}
+	for _, value := range []string{"", "sha256:", "sha256:abc", "md5:" + strings.Repeat("ab", 32), "sha256:" + strings.Repeat("zz", 32)} {
+		if _, err := ParseExpectPlan(value); err == nil {
+			t.Errorf("ParseExpectPlan(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestSyncExpectPlan(t *testing.T) {
+	f, client := newFakeS3(t)
+	ctx := context.Background()
+	source := t.TempDir()
+	writeFiles(t, source, map[string]string{"a.txt": "a", "dir/b.txt": "b"})
+	f.put("site/old.txt", "old")
+
+	var planHash string
+	opts := SyncOptions{Recursive: true, Delete: true, DryRun: true, Progress: func(event ProgressEvent) {
+		if event.Type == "sync_done" {
+			planHash = event.PlanHash
+		}
+	}}
+	if err := client.Sync(ctx, source, "site", opts); err != nil {
+		t.Fatal(err)
+	}
+	first := planHash
+	if err := client.Sync(ctx, source, "site", opts); err != nil {
+		t.Fatal(err)
+	}
+	if planHash != first {
+		t.Errorf("plan hash of identical dry runs differs: %s != %s", planHash, first)
+	}
+
+	// a drifted plan fails before anything is uploaded or deleted
+	opts.DryRun = false
+	opts.ExpectPlan = emptyPlanHash
+	if err := client.Sync(ctx, source, "site", opts); !errors.Is(err, ErrPlanDrift) {
+		t.Fatalf("Sync with a drifted plan = %v, want ErrPlanDrift", err)
+	}
+	if keys := f.keys(); len(keys) != 1 || keys[0] != "site/old.txt" {
+		t.Errorf("Sync with a drifted plan changed the bucket: %v", keys)
+	}
+
+	opts.ExpectPlan = first
+	if err := client.Sync(ctx, source, "site", opts); err != nil {
+		t.Fatalf("Sync with the expected plan failed: %v", err)
+	}
+	if got := strings.Join(f.keys(), " "); got != "site/a.txt site/dir/b.txt" {
+		t.Errorf("keys after sync = %s", got)
+	}
+
+	opts.ExpectPlan = emptyPlanHash
+	if err := client.Sync(ctx, source, "site", opts); err != nil {
+		t.Errorf("Sync of an unchanged tree with an empty expected plan failed: %v", err)
+	}
+}
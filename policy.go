@@ -0,0 +1,61 @@
+package r2sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// GetPolicy returns the bucket policy document, a bucket without a policy returns an empty document
+func (r *R2Client) GetPolicy(ctx context.Context) (map[string]any, error) {
+	resp, err := r.client.GetBucketPolicy(ctx, &s3.GetBucketPolicyInput{
+		Bucket: aws.String(r.bucket),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchBucketPolicy" {
+			return map[string]any{}, nil
+		}
+		return nil, err
+	}
+	policy := map[string]any{}
+	if err := json.Unmarshal([]byte(aws.ToString(resp.Policy)), &policy); err != nil {
+		return nil, fmt.Errorf("invalid bucket policy: %v", err)
+	}
+	return policy, nil
+}
+
+// PutPolicy replaces the bucket policy, an empty document removes it
+func (r *R2Client) PutPolicy(ctx context.Context, policy map[string]any, dryRun bool) error {
+	if dryRun {
+		log.Printf("(dryrun) put policy: %s\n", r.RemotePath(""))
+		return nil
+	}
+
+	var err error
+	if len(policy) == 0 {
+		_, err = r.client.DeleteBucketPolicy(ctx, &s3.DeleteBucketPolicyInput{
+			Bucket: aws.String(r.bucket),
+		})
+	} else {
+		var data []byte
+		if data, err = json.Marshal(policy); err != nil {
+			return err
+		}
+		_, err = r.client.PutBucketPolicy(ctx, &s3.PutBucketPolicyInput{
+			Bucket: aws.String(r.bucket),
+			Policy: aws.String(string(data)),
+		})
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("put policy: %s\n", r.RemotePath(""))
+	return nil
+}
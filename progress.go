@@ -0,0 +1,36 @@
+package r2sync
+
+import "time"
+
+// heartbeat calls the Heartbeat of the client when it is set
+func (r *R2Client) heartbeat() {
+	if r.Heartbeat != nil {
+		r.Heartbeat()
+	}
+}
+
+// ProgressEvent is a structured sync progress event
+type ProgressEvent struct {
+	Time      time.Time     `json:"time"`
+	Type      string        `json:"type"` // sync_start, upload_start, upload_done, upload_failed, download_start, download_done, download_failed, file_skipped, delete_done, delete_failed, sync_done
+	Path      string        `json:"path,omitempty"`
+	Target    string        `json:"target,omitempty"`
+	Size      int64         `json:"size,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Class     ErrorClass    `json:"class,omitempty"`      // category of Error
+	RequestID string        `json:"request_id,omitempty"` // x-amz-request-id of the failed request
+	HostID    string        `json:"host_id,omitempty"`    // x-amz-id-2 of the failed request
+	CFRay     string        `json:"cf_ray,omitempty"`     // cf-ray of the failed request
+	Uploads   int           `json:"uploads,omitempty"`
+	Deletes   int           `json:"deletes,omitempty"`
+	PlanHash  string        `json:"plan_hash,omitempty"` // hash of the planned operations, on sync_done
+	Reason    string        `json:"reason,omitempty"`    // why the file was skipped, on file_skipped
+}
+
+// failedEvent returns an event of a failed transfer with the error category and request identifiers
+func failedEvent(eventType, path, target string, size int64, err error) ProgressEvent {
+	event := ProgressEvent{Type: eventType, Path: path, Target: target, Size: size, Error: ErrorDetail(err), Class: classifyError(err)}
+	event.RequestID, event.HostID, event.CFRay = requestIDs(err)
+	return event
+}
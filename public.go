@@ -0,0 +1,119 @@
+package r2sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// CloudflareClient talks to the Cloudflare API for R2 settings that are not exposed via the S3 API
+type CloudflareClient struct {
+	accountID string
+	token     string
+	http      *http.Client
+}
+
+// ManagedDomain is the public r2.dev domain of an R2 bucket
+type ManagedDomain struct {
+	BucketID string `json:"bucketId"`
+	Domain   string `json:"domain"`
+	Enabled  bool   `json:"enabled"`
+}
+
+func NewCloudflareClient(accountID, token string) *CloudflareClient {
+	return &CloudflareClient{
+		accountID: accountID,
+		token:     token,
+		http:      http.DefaultClient,
+	}
+}
+
+func (c *CloudflareClient) do(ctx context.Context, method, endpoint string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cloudflareAPI+endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("cloudflare api %s: %s", endpoint, resp.Status)
+	}
+	if !envelope.Success {
+		messages := make([]string, 0, len(envelope.Errors))
+		for _, e := range envelope.Errors {
+			messages = append(messages, fmt.Sprintf("%d: %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("cloudflare api %s: %s", endpoint, strings.Join(messages, "; "))
+	}
+	if result != nil {
+		return json.Unmarshal(envelope.Result, result)
+	}
+	return nil
+}
+
+func (c *CloudflareClient) managedDomainEndpoint(bucket string) string {
+	return fmt.Sprintf("/accounts/%s/r2/buckets/%s/domains/managed", url.PathEscape(c.accountID), url.PathEscape(bucket))
+}
+
+// ManagedDomain returns the public r2.dev access settings of a bucket
+func (c *CloudflareClient) ManagedDomain(bucket string) (*ManagedDomain, error) {
+	var domain ManagedDomain
+	if err := c.do(context.TODO(), http.MethodGet, c.managedDomainEndpoint(bucket), nil, &domain); err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// SetManagedDomain enables or disables public r2.dev access of a bucket
+func (c *CloudflareClient) SetManagedDomain(bucket string, enabled bool, dryRun bool) (*ManagedDomain, error) {
+	if dryRun {
+		log.Printf("(dryrun) public access %s: %s\n", onOff(enabled), bucket)
+		return &ManagedDomain{Enabled: enabled}, nil
+	}
+
+	var domain ManagedDomain
+	body := map[string]bool{"enabled": enabled}
+	if err := c.do(context.TODO(), http.MethodPut, c.managedDomainEndpoint(bucket), body, &domain); err != nil {
+		return nil, err
+	}
+	log.Printf("public access %s: %s\n", onOff(enabled), bucket)
+	return &domain, nil
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
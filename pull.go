@@ -0,0 +1,343 @@
+package r2sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// isInternalKey reports whether a key relative to the synced prefix holds r2sync bookkeeping like
+// manifests, bundles or chunks instead of a synced file
+func isInternalKey(relKey string) bool {
+	if isChunkKey(relKey) {
+		return true
+	}
+	for _, segment := range strings.Split(relKey, "/") {
+		if strings.HasPrefix(segment, ".r2sync-") {
+			return true
+		}
+	}
+	return false
+}
+
+// Pull downloads the objects under remotePath into localDir, the reverse of Sync. Objects are
+// compared with the local files by size and ETag, or only size with SizeOnly, and with Delete
+// local files without an object are removed. Files stored in packs are unpacked and kept. Options
+// that only apply to uploads are ignored.
+func (r *R2Client) Pull(ctx context.Context, remotePath, localDir string, opts SyncOptions) error {
+	if opts.ExpectPlan != "" && !opts.DryRun {
+		// a dry run checks the plan first, so nothing is transferred or deleted when it drifted
+		log.Println("Checking the plan with a dry run ...")
+		check := opts
+		check.DryRun, check.Progress, check.Operations = true, nil, nil
+		if err := r.Pull(ctx, remotePath, localDir, check); err != nil {
+			return err
+		}
+		log.Println("Plan matches the expected plan, running it ...")
+	}
+	emit := func(event ProgressEvent) {
+		if opts.Progress != nil {
+			event.Time = time.Now()
+			opts.Progress(event)
+		}
+	}
+	emit(ProgressEvent{Type: "sync_start", Path: r.RemotePath(remotePath), Target: localDir})
+	skipped := func(key, localPath, reason string) {
+		if opts.Verbose {
+			log.Printf("skipped %s -> %s: %s\n", key, localPath, reason)
+		}
+		emit(ProgressEvent{Type: "file_skipped", Path: key, Target: localPath, Reason: reason})
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = min(4*runtime.NumCPU(), MaxAutoConcurrency)
+	}
+	if opts.Deterministic {
+		opts.Concurrency = 1
+	}
+
+	prefix := listingPrefix(remotePath, "")
+	var remoteFiles map[string]FileInfo
+	var err error
+	if opts.Inventory != nil {
+		log.Printf("Reading remote file list from inventory: %s ...\n", opts.Inventory.Client.RemotePath(opts.Inventory.Key))
+		remoteFiles, err = opts.Inventory.Load(ctx, r.bucket, prefix)
+	} else {
+		log.Printf("Getting remote file list: %s ...\n", r.RemotePath(prefix))
+		remoteFiles, err = opts.ListingCache.listObjects(ctx, r, prefix)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get remote file list: %v", err)
+	}
+	chunked := make(map[string]bool)
+	for key := range remoteFiles {
+		if i := strings.Index(key, chunkSuffix+"/"); i >= 0 {
+			chunked[key[:i]] = true
+		}
+	}
+	keys := make([]string, 0, len(remoteFiles))
+	for key := range remoteFiles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var wg sync.WaitGroup
+	var failures failureStats
+	plan := newPlanHash(r.pricing(opts.Pricing), opts.Operations)
+	semaphore := make(chan struct{}, opts.Concurrency)
+	// local paths of the pulled objects, kept when deleting
+	wanted := make(map[string]bool, len(keys))
+	downloadCount := 0
+	var downloaded atomic.Int64
+	for _, key := range keys {
+		relKey := RelativeKey(key, prefix)
+		if relKey == "" || strings.HasSuffix(key, "/") || isInternalKey(relKey) {
+			continue
+		}
+		if !opts.Recursive && strings.Contains(relKey, "/") {
+			continue
+		}
+		localPath, err := localPathFor(localDir, relKey, r.StrictKeys)
+		if err != nil {
+			log.Printf("skipped %s: %v\n", r.RemotePath(key), err)
+			failures.Add(err)
+			continue
+		}
+		fullpath := NormalizePath(localPath)
+		if reason := filterReason(localDir, fullpath, false, opts); reason != "" {
+			skipped(r.RemotePath(key), localPath, reason)
+			continue
+		}
+		wanted[fullpath] = true
+		if ctx.Err() != nil || Draining(opts.Drain) {
+			break
+		}
+
+		if opts.IgnoreExisting || opts.Existing {
+			_, err := os.Lstat(localPath)
+			if opts.IgnoreExisting && err == nil {
+				skipped(r.RemotePath(key), localPath, "local file exists, --ignore-existing")
+				continue
+			}
+			if opts.Existing && err != nil {
+				skipped(r.RemotePath(key), localPath, "no local file, --existing")
+				continue
+			}
+		}
+		var needDownload bool
+		var change string
+		if info, err := os.Stat(localPath); opts.Update && err == nil && info.Mode().IsRegular() {
+			// downloaded files get the modification time of their object
+			needDownload = remoteFiles[key].LastModified.After(info.ModTime().Add(opts.ClockSkew))
+			change = "object is newer than the local file"
+		} else {
+			needDownload, change, err = r.pullChanged(ctx, key, localPath, remoteFiles[key], chunked[key], opts.SizeOnly, opts.PartSize)
+			if err != nil {
+				log.Printf("failed to compare %s: %s\n", r.RemotePath(key), ErrorDetail(err))
+			}
+		}
+		if !needDownload {
+			if opts.Update {
+				skipped(r.RemotePath(key), localPath, "object is not newer than the local file, --update")
+			} else if opts.SizeOnly {
+				skipped(r.RemotePath(key), localPath, "size matches the local file")
+			} else {
+				skipped(r.RemotePath(key), localPath, "size and md5 match the local file")
+			}
+			continue
+		}
+		remoteInfo := remoteFiles[key]
+		plan.Download(localPath, remoteInfo.Size, change)
+		if r.Meter != nil {
+			r.Meter.Plan(remoteInfo.Size)
+		}
+		downloadCount++
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(key, localPath string, size int64) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			fullKey := r.RemotePath(key)
+			emit(ProgressEvent{Type: "download_start", Path: fullKey, Target: localPath, Size: size})
+			startTime := time.Now()
+			if err := r.DownloadFile(ctx, key, localPath, opts.DryRun); err != nil {
+				log.Printf("download failed %s: %s\n", fullKey, ErrorDetail(err))
+				failures.Add(err)
+				emit(failedEvent("download_failed", fullKey, localPath, size, err))
+				return
+			}
+			downloaded.Add(1)
+			emit(ProgressEvent{Type: "download_done", Path: fullKey, Target: localPath, Size: size, Duration: time.Since(startTime)})
+		}(key, localPath, remoteInfo.Size)
+	}
+	wg.Wait()
+	log.Printf("%d files downloaded.\n", downloadCount)
+
+	// files stored in packs have no object of their own, they are unpacked and kept when deleting
+	if _, ok := remoteFiles[packKey(remotePath, packIndexName)]; ok && ctx.Err() == nil && !Draining(opts.Drain) {
+		index, err := r.loadPackIndex(ctx, remotePath)
+		if err != nil {
+			return fmt.Errorf("failed to read pack index: %v", err)
+		}
+		relPaths := make([]string, 0, len(index.Files))
+		for relPath := range index.Files {
+			relPaths = append(relPaths, relPath)
+		}
+		sort.Strings(relPaths)
+		bundles := make(map[string]map[string]packEntry)
+		for _, relPath := range relPaths {
+			entry := index.Files[relPath]
+			if !opts.Recursive && strings.Contains(relPath, "/") {
+				continue
+			}
+			localPath, err := localPathFor(localDir, relPath, r.StrictKeys)
+			if err != nil {
+				log.Printf("skipped packed %s: %v\n", relPath, err)
+				failures.Add(err)
+				continue
+			}
+			fullpath := NormalizePath(localPath)
+			bundleKey := r.RemotePath(packKey(remotePath, entry.Bundle))
+			if reason := filterReason(localDir, fullpath, false, opts); reason != "" {
+				skipped(bundleKey, localPath, reason)
+				continue
+			}
+			wanted[fullpath] = true
+			if info, err := os.Stat(localPath); err == nil && info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime) {
+				skipped(bundleKey, localPath, "size and modification time match the packed file")
+				continue
+			}
+			plan.Download(localPath, entry.Size, "packed file differs from the local file")
+			if bundles[entry.Bundle] == nil {
+				bundles[entry.Bundle] = make(map[string]packEntry)
+			}
+			bundles[entry.Bundle][relPath] = entry
+		}
+		unpacked, err := r.unpackBundles(ctx, remotePath, localDir, bundles, opts.DryRun)
+		if err != nil {
+			log.Printf("unpack failed %s: %s\n", r.RemotePath(packKey(remotePath, "")), ErrorDetail(err))
+			failures.Add(err)
+		}
+		log.Printf("%d packed files unpacked.\n", unpacked)
+	}
+	if ctx.Err() != nil || Draining(opts.Drain) {
+		logStopSummary("download", int(downloaded.Load()), downloadCount, failures.Total(), 0, opts.Delete)
+		failures.Log()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return ErrInterrupted
+	}
+
+	deleteCount := 0
+	if opts.Delete {
+		if len(wanted) == 0 && !opts.AllowEmptySource {
+			return fmt.Errorf("source %s has no files, refusing to delete everything under %s, use --allow-empty-source if this is intended", r.RemotePath(prefix), localDir)
+		}
+		log.Printf("Starting file deletion: %s ...\n", localDir)
+		var extra []string
+		err := filepath.Walk(localDir, func(localPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			fullpath := NormalizePath(localPath)
+			if filterReason(localDir, fullpath, info.IsDir(), opts) != "" {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				if !opts.Recursive && fullpath != NormalizePath(localDir) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !wanted[fullpath] {
+				extra = append(extra, localPath)
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to walk %s: %v", localDir, err)
+		}
+		if opts.MaxDelete > 0 && len(extra) > opts.MaxDelete {
+			failures.Log()
+			return fmt.Errorf("%w: %d local files would be deleted, more than --max-delete %d, nothing was deleted", ErrTooManyDeletes, len(extra), opts.MaxDelete)
+		}
+		for _, localPath := range extra {
+			if ctx.Err() != nil || Draining(opts.Drain) {
+				break
+			}
+			plan.Delete(localPath, 0, "no object")
+			if opts.DryRun {
+				log.Printf("(dryrun) delete: %s\n", localPath)
+				deleteCount++
+				continue
+			}
+			if err := os.Remove(localPath); err != nil {
+				log.Printf("delete failed %s: %v\n", localPath, err)
+				failures.Add(err)
+				emit(failedEvent("delete_failed", localPath, "", 0, err))
+				continue
+			}
+			log.Printf("delete: %s\n", localPath)
+			emit(ProgressEvent{Type: "delete_done", Path: localPath})
+			deleteCount++
+		}
+		log.Printf("%d files deleted.\n", deleteCount)
+	}
+
+	planSum := plan.Sum()
+	log.Printf("Plan: %d operations, hash %s\n", plan.Len(), planSum)
+	if opts.DryRun {
+		plan.Estimate().Log()
+	}
+	emit(ProgressEvent{Type: "sync_done", Path: r.RemotePath(remotePath), Target: localDir, Uploads: downloadCount, Deletes: deleteCount, PlanHash: planSum})
+	failures.Log()
+	if opts.ExpectPlan != "" && planSum != opts.ExpectPlan {
+		return fmt.Errorf("%w: expected %s, got %s", ErrPlanDrift, opts.ExpectPlan, planSum)
+	}
+	if total := failures.Total(); total > 0 {
+		return fmt.Errorf("%d %w", total, ErrPartialFailure)
+	}
+	return nil
+}
+
+// pullChanged reports whether the object at key differs from the local file at localPath and why
+func (r *R2Client) pullChanged(ctx context.Context, key, localPath string, remoteInfo FileInfo, chunked, sizeOnly bool, partSize int64) (bool, string, error) {
+	info, err := os.Stat(localPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return true, "no local file", nil
+	}
+	if sizeOnly && !chunked && r.Compression == "" {
+		return info.Size() != remoteInfo.Size, "size differs from the local file", nil
+	}
+	etag := ""
+	if !sizeOnly {
+		if etag, err = calcETag(r.IOLimit, localPath); err != nil {
+			return true, "local file unreadable", err
+		}
+	}
+	// chunked and compressed copies are compared against the size and md5 they record
+	switch {
+	case chunked:
+		changed, err := r.ChunkedChanged(ctx, key, info, etag)
+		return changed, changeReason("chunk manifest", etag != ""), err
+	case r.Compression != "":
+		changed, err := r.CompressedChanged(ctx, key, info, etag)
+		return changed, changeReason("compressed object", etag != ""), err
+	}
+	if info.Size() != remoteInfo.Size {
+		return true, "size differs from the local file", nil
+	}
+	matched, err := r.etagMatches(ctx, key, localPath, info.Size(), etag, remoteInfo.ETag, partSize)
+	return !matched, "md5 differs from the local file", err
+}
@@ -0,0 +1,59 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPullDelete(t *testing.T) {
+	f, client := newFakeS3(t)
+	ctx := context.Background()
+	f.put("site/a.txt", "a")
+	f.put("site/dir/b.txt", "b")
+	f.put("site/dir/", "")
+	f.put("site2/sibling.txt", "sibling prefix")
+	target := t.TempDir()
+	writeFiles(t, target, map[string]string{"a.txt": "old", "extra.txt": "extra", "dir/extra.txt": "extra", "keep.log": "excluded"})
+
+	opts := SyncOptions{Recursive: true, Delete: true, MaxDelete: 1, ExcludePatterns: []string{"*.log"}}
+	if err := client.Pull(ctx, "site", target, opts); !errors.Is(err, ErrTooManyDeletes) {
+		t.Fatalf("Pull deleting 2 files with MaxDelete 1 = %v, want ErrTooManyDeletes", err)
+	}
+	if got := readFiles(t, target); got["extra.txt"] != "extra" || got["dir/extra.txt"] != "extra" {
+		t.Errorf("Pull over MaxDelete deleted files: %v", got)
+	}
+
+	opts.MaxDelete = 2
+	if err := client.Pull(ctx, "site", target, opts); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a.txt": "a", "dir/b.txt": "b", "keep.log": "excluded"}
+	if got := readFiles(t, target); !reflect.DeepEqual(got, want) {
+		t.Errorf("files after pull = %v, want %v", got, want)
+	}
+}
+
+func TestPullEmptySource(t *testing.T) {
+	_, client := newFakeS3(t)
+	ctx := context.Background()
+	target := t.TempDir()
+	writeFiles(t, target, map[string]string{"a.txt": "a"})
+
+	opts := SyncOptions{Recursive: true, Delete: true}
+	if err := client.Pull(ctx, "typo", target, opts); err == nil {
+		t.Error("Pull from an empty prefix with Delete succeeded, want an error")
+	}
+	if got := readFiles(t, target); len(got) != 1 {
+		t.Fatalf("Pull from an empty prefix deleted local files: %v", got)
+	}
+
+	opts.AllowEmptySource = true
+	if err := client.Pull(ctx, "typo", target, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFiles(t, target); len(got) != 0 {
+		t.Errorf("Pull from an empty prefix with AllowEmptySource kept %v", got)
+	}
+}
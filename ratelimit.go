@@ -0,0 +1,72 @@
+package r2sync
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// RequestLimiter is a token bucket shared by every client, so list, put, delete and head requests
+// including retries together stay below the account's request rate limits
+type RequestLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRequestLimiter allows perSecond requests per second with bursts of up to one second's worth
+func NewRequestLimiter(perSecond float64) *RequestLimiter {
+	burst := math.Max(1, math.Ceil(perSecond))
+	return &RequestLimiter{rate: perSecond, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Wait blocks until a request may be sent, the token is reserved even if ctx is done first
+func (l *RequestLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type rateLimitedHTTPClient struct {
+	next    s3.HTTPClient
+	limiter *RequestLimiter
+}
+
+func (c rateLimitedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if err := c.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return c.next.Do(req)
+}
+
+// WithRequestLimit sends every request of the client through limiter
+func WithRequestLimit(limiter *RequestLimiter) S3Option {
+	return func(o *s3.Options) {
+		next := o.HTTPClient
+		if next == nil {
+			next = http.DefaultClient
+		}
+		o.HTTPClient = rateLimitedHTTPClient{next: next, limiter: limiter}
+	}
+}
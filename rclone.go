@@ -0,0 +1,77 @@
+package r2sync
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// metadata keys rclone reads and writes, so objects of either tool compare as unchanged in the other
+const (
+	rcloneMD5   = "md5chksum" // base64 md5 of the whole file, set by rclone on multipart uploads
+	rcloneMtime = "mtime"     // modification time in seconds since the epoch with nanoseconds
+)
+
+// rcloneMetadata returns the rclone metadata of a local file, without the md5 when md5 is empty
+func rcloneMetadata(info os.FileInfo, md5 []byte) map[string]string {
+	modTime := info.ModTime()
+	metadata := map[string]string{rcloneMtime: fmt.Sprintf("%d.%09d", modTime.Unix(), modTime.Nanosecond())}
+	if md5 != nil {
+		metadata[rcloneMD5] = base64.StdEncoding.EncodeToString(md5)
+	}
+	return metadata
+}
+
+// fileMD5 returns the md5 of file and seeks back to its start
+func fileMD5(file io.ReadSeeker) ([]byte, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// objectMD5 returns the hex md5 rclone recorded in the metadata of the object at key, "" when the
+// object has none
+func (r *R2Client) objectMD5(ctx context.Context, key string) (string, error) {
+	resp, err := r.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	sum, err := base64.StdEncoding.DecodeString(resp.Metadata[rcloneMD5])
+	if err != nil || len(sum) != md5.Size {
+		return "", nil
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// etagMatches reports whether the local file at path has the content of the object at key with
+// remoteETag. The ETag of a multipart upload isn't an md5, so the md5 rclone records in the
+// metadata is compared first, and else the ETag is recomputed with every plausible part size.
+func (r *R2Client) etagMatches(ctx context.Context, key, path string, size int64, localETag, remoteETag string, partSize int64) (bool, error) {
+	if localETag == remoteETag || multipartParts(remoteETag) == 0 {
+		return localETag == remoteETag, nil
+	}
+	sum, err := r.objectMD5(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if sum != "" {
+		return sum == strings.Trim(localETag, `"`), nil
+	}
+	return etagMatches(r.IOLimit, path, size, localETag, remoteETag, partSize)
+}
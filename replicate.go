@@ -0,0 +1,498 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ReplicateReport describes how a target prefix diverges from a source prefix
+type ReplicateReport struct {
+	Missing []string // relative keys that exist in the source only
+	Changed []string // relative keys whose size or etag differ
+	Extra   []string // relative keys that exist in the target only
+	Common  []string // relative keys that exist in both locations
+
+	// legal hold status of the source for common keys whose hold differs in the target
+	HoldMismatch map[string]types.ObjectLockLegalHoldStatus
+
+	sourceSizes map[string]int64 // size of every source object by relative key
+	targetSizes map[string]int64 // size of every target object by relative key
+}
+
+func (r *ReplicateReport) Divergent() int {
+	return len(r.Missing) + len(r.Changed) + len(r.Extra) + len(r.HoldMismatch)
+}
+
+// copySource builds the url-encoded x-amz-copy-source value for a key
+func copySource(bucket, key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return bucket + "/" + strings.Join(parts, "/")
+}
+
+func RelativeKey(key, prefix string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+}
+
+// compareKey returns the key relative to the directory prefix, false for keys outside the prefix,
+// the prefix itself and keys left out by the filters or Recursive of opts
+func compareKey(key, prefix string, opts SyncOptions) (string, bool) {
+	rel, ok := strings.CutPrefix(key, prefix)
+	if !ok || rel == "" {
+		return "", false
+	}
+	if !opts.Recursive && strings.Contains(strings.TrimSuffix(rel, "/"), "/") {
+		return "", false
+	}
+	return rel, filterReason("", rel, false, opts) == ""
+}
+
+// maxCopySize is the largest object one CopyObject request copies, larger ones are copied in parts
+const maxCopySize = 5 << 30
+
+// copyPartSize is the part size of multipart copies, raised for objects that need more than
+// maxCopyParts parts
+const copyPartSize = 512 << 20
+
+const maxCopyParts = 10000
+
+// CheckCopySource returns an error when objects of source can't be copied server-side into this
+// client's bucket, which needs both buckets on the same service and endpoint
+func (r *R2Client) CheckCopySource(source *R2Client) error {
+	if r.scheme != source.scheme || aws.ToString(r.client.Options().BaseEndpoint) != aws.ToString(source.client.Options().BaseEndpoint) {
+		return fmt.Errorf("can't copy from %s to %s server-side, the buckets are on different endpoints", source.RemotePath(""), r.RemotePath(""))
+	}
+	return nil
+}
+
+// CopyObject copies an object of size bytes from another bucket into this client's bucket
+// server-side, objects larger than 5 GiB are copied in parts
+func (r *R2Client) CopyObject(ctx context.Context, sourceBucket, sourceKey, remotePath string, size int64, dryRun bool) error {
+	source := fmt.Sprintf("%s://%s/%s", r.scheme, sourceBucket, sourceKey)
+	if dryRun {
+		log.Printf("(dryrun) copy: %s -> %s\n", source, r.RemotePath(remotePath))
+		return nil
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(r.bucket),
+		Key:        aws.String(remotePath),
+		CopySource: aws.String(copySource(sourceBucket, sourceKey)),
+	}
+	if err := r.copyObject(ctx, input, sourceBucket, sourceKey, size); err != nil {
+		return err
+	}
+	log.Printf("copy: %s -> %s\n", source, r.RemotePath(remotePath))
+	return nil
+}
+
+// replaceWithSource makes input replace the metadata and content headers of the copy with those of
+// the source object, which a copy otherwise takes over without a request
+func (r *R2Client) replaceWithSource(ctx context.Context, input *s3.CopyObjectInput, sourceBucket, sourceKey string) error {
+	head, err := r.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(sourceBucket),
+		Key:    aws.String(sourceKey),
+	})
+	if err != nil {
+		return err
+	}
+	input.MetadataDirective = types.MetadataDirectiveReplace
+	input.Metadata = head.Metadata
+	input.ContentType = head.ContentType
+	input.CacheControl = head.CacheControl
+	input.ContentDisposition = head.ContentDisposition
+	input.ContentEncoding = head.ContentEncoding
+	input.ContentLanguage = head.ContentLanguage
+	input.Expires = head.Expires
+	return nil
+}
+
+// copyObject runs the server-side copy of input. With a RunID or HeaderRules matching the key the
+// copy records them in replaced metadata. Objects larger than maxCopySize are copied with a
+// multipart upload of UploadPartCopy requests, which takes the metadata and content headers of
+// input.
+func (r *R2Client) copyObject(ctx context.Context, input *s3.CopyObjectInput, sourceBucket, sourceKey string, size int64) error {
+	cacheControl, disposition, language := r.contentHeaders(aws.ToString(input.Key))
+	headers := cacheControl != nil || disposition != nil || language != nil
+	// a multipart copy has no metadata of its own, a run id and header rules need replaced metadata
+	if input.MetadataDirective != types.MetadataDirectiveReplace && (r.RunID != "" || headers || size > maxCopySize) {
+		if err := r.replaceWithSource(ctx, input, sourceBucket, sourceKey); err != nil {
+			return err
+		}
+	}
+	if cacheControl != nil {
+		input.CacheControl = cacheControl
+	}
+	if disposition != nil {
+		input.ContentDisposition = disposition
+	}
+	if language != nil {
+		input.ContentLanguage = language
+	}
+	if r.RunID != "" {
+		input.Metadata = maps.Clone(input.Metadata)
+		if input.Metadata == nil {
+			input.Metadata = make(map[string]string)
+		}
+		input.Metadata[metaRun] = r.RunID
+	}
+	if size <= maxCopySize {
+		_, err := r.client.CopyObject(ctx, input)
+		return err
+	}
+
+	create := &s3.CreateMultipartUploadInput{
+		Bucket:                  input.Bucket,
+		Key:                     input.Key,
+		Metadata:                input.Metadata,
+		ContentType:             input.ContentType,
+		CacheControl:            input.CacheControl,
+		ContentDisposition:      input.ContentDisposition,
+		ContentEncoding:         input.ContentEncoding,
+		ContentLanguage:         input.ContentLanguage,
+		Expires:                 input.Expires,
+		WebsiteRedirectLocation: input.WebsiteRedirectLocation,
+	}
+	if input.TaggingDirective == types.TaggingDirectiveReplace {
+		create.Tagging = input.Tagging
+	}
+	upload, err := r.client.CreateMultipartUpload(ctx, create)
+	if err != nil {
+		return err
+	}
+	abort := func() {
+		r.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   input.Bucket,
+			Key:      input.Key,
+			UploadId: upload.UploadId,
+		})
+	}
+
+	partSize := max(copyPartSize, (size+maxCopyParts-1)/maxCopyParts)
+	var parts []types.CompletedPart
+	for offset, number := int64(0), int32(1); offset < size; offset, number = offset+partSize, number+1 {
+		resp, err := r.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          input.Bucket,
+			Key:             input.Key,
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int32(number),
+			CopySource:      input.CopySource,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, min(offset+partSize, size)-1)),
+		})
+		if err != nil {
+			abort()
+			return err
+		}
+		parts = append(parts, types.CompletedPart{ETag: resp.CopyPartResult.ETag, PartNumber: aws.Int32(number)})
+	}
+	_, err = r.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abort()
+	}
+	return err
+}
+
+// Compare lists both locations and reports how the target prefix diverges from the source prefix.
+// Both prefixes are compared as directories, of opts it uses SizeOnly, Recursive and the filters.
+func (r *R2Client) Compare(ctx context.Context, source *R2Client, sourcePrefix, targetPrefix string, opts SyncOptions) (*ReplicateReport, error) {
+	sourcePrefix, targetPrefix = listingPrefix(sourcePrefix, ""), listingPrefix(targetPrefix, "")
+	log.Printf("Getting source file list: %s ...\n", source.RemotePath(sourcePrefix))
+	sourceFiles, err := source.ListObjects(ctx, sourcePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get source file list: %v", err)
+	}
+	log.Printf("Getting target file list: %s ...\n", r.RemotePath(targetPrefix))
+	targetFiles, err := r.ListObjects(ctx, targetPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get target file list: %v", err)
+	}
+
+	report := &ReplicateReport{sourceSizes: make(map[string]int64), targetSizes: make(map[string]int64)}
+	targets := make(map[string]FileInfo, len(targetFiles))
+	for key, info := range targetFiles {
+		if rel, ok := compareKey(key, targetPrefix, opts); ok {
+			targets[rel] = info
+			report.targetSizes[rel] = info.Size
+		}
+	}
+
+	for key, info := range sourceFiles {
+		rel, ok := compareKey(key, sourcePrefix, opts)
+		if !ok {
+			continue
+		}
+		report.sourceSizes[rel] = info.Size
+		targetInfo, exists := targets[rel]
+		delete(targets, rel)
+		if !exists {
+			report.Missing = append(report.Missing, rel)
+			continue
+		}
+		report.Common = append(report.Common, rel)
+		if info.Size != targetInfo.Size || (!opts.SizeOnly && info.ETag != targetInfo.ETag) {
+			report.Changed = append(report.Changed, rel)
+		}
+	}
+	for rel := range targets {
+		report.Extra = append(report.Extra, rel)
+	}
+	sort.Strings(report.Missing)
+	sort.Strings(report.Changed)
+	sort.Strings(report.Extra)
+	sort.Strings(report.Common)
+	return report, nil
+}
+
+// CompareLegalHolds fetches the legal hold status of every common key in both locations
+// and records the keys whose target hold differs from the source
+func (r *R2Client) CompareLegalHolds(ctx context.Context, source *R2Client, sourcePrefix, targetPrefix string, report *ReplicateReport, concurrency int) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	semaphore := make(chan struct{}, concurrency)
+	report.HoldMismatch = make(map[string]types.ObjectLockLegalHoldStatus)
+	for _, rel := range report.Common {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(rel string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			sourceHold, err := source.LegalHold(ctx, path.Join(sourcePrefix, rel))
+			if err == nil {
+				var targetHold types.ObjectLockLegalHoldStatus
+				targetHold, err = r.LegalHold(ctx, path.Join(targetPrefix, rel))
+				if err == nil && sourceHold != targetHold {
+					mu.Lock()
+					report.HoldMismatch[rel] = sourceHold
+					mu.Unlock()
+				}
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to get legal hold of %s: %v", rel, err)
+				}
+				mu.Unlock()
+			}
+		}(rel)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// Replicate compares the source prefix with this client's target prefix and optionally repairs
+// the target with server-side copies. Of opts it uses Delete, DryRun, Concurrency, SizeOnly,
+// Recursive, the filters, AllowEmptySource, MaxDelete, Drain, MaxRuntime, ExpectPlan, Operations,
+// Pricing and Progress, copies are reported as uploads. It returns the number of divergent keys
+// found.
+func (r *R2Client) Replicate(ctx context.Context, source *R2Client, sourcePrefix, targetPrefix string, repair, checkHolds, preserve bool, opts SyncOptions) (int, error) {
+	if repair {
+		if err := r.CheckCopySource(source); err != nil {
+			return 0, err
+		}
+	}
+	emit := func(event ProgressEvent) {
+		if opts.Progress != nil {
+			event.Time = time.Now()
+			opts.Progress(event)
+		}
+	}
+	var deadline time.Time
+	if opts.MaxRuntime > 0 {
+		deadline = time.Now().Add(opts.MaxRuntime)
+	}
+	stopped := func() error {
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case Draining(opts.Drain):
+			return ErrInterrupted
+		case !deadline.IsZero() && time.Now().After(deadline):
+			return ErrMaxRuntime
+		}
+		return nil
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = min(4*runtime.NumCPU(), MaxAutoConcurrency)
+	}
+	emit(ProgressEvent{Type: "sync_start", Path: source.RemotePath(sourcePrefix), Target: r.RemotePath(targetPrefix)})
+
+	report, err := r.Compare(ctx, source, sourcePrefix, targetPrefix, opts)
+	if err != nil {
+		return 0, err
+	}
+	if checkHolds {
+		if err := r.CompareLegalHolds(ctx, source, sourcePrefix, targetPrefix, report, opts.Concurrency); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, rel := range report.Missing {
+		log.Printf("missing: %s\n", r.RemotePath(path.Join(targetPrefix, rel)))
+	}
+	for _, rel := range report.Changed {
+		log.Printf("changed: %s\n", r.RemotePath(path.Join(targetPrefix, rel)))
+	}
+	for _, rel := range report.Extra {
+		log.Printf("extra: %s\n", r.RemotePath(path.Join(targetPrefix, rel)))
+	}
+	holdKeys := make([]string, 0, len(report.HoldMismatch))
+	for rel := range report.HoldMismatch {
+		holdKeys = append(holdKeys, rel)
+	}
+	sort.Strings(holdKeys)
+	for _, rel := range holdKeys {
+		log.Printf("legal hold differs: %s (source %s)\n", r.RemotePath(path.Join(targetPrefix, rel)), strings.ToLower(string(report.HoldMismatch[rel])))
+	}
+	log.Printf("%d missing, %d changed, %d extra.\n", len(report.Missing), len(report.Changed), len(report.Extra))
+	if checkHolds {
+		log.Printf("%d legal holds differ.\n", len(holdKeys))
+	}
+
+	if !repair {
+		return report.Divergent(), nil
+	}
+	if opts.Delete && len(report.Extra) > 0 && len(report.sourceSizes) == 0 && !opts.AllowEmptySource {
+		return report.Divergent(), fmt.Errorf("source %s has no files, refusing to delete everything under %s, use --allow-empty-source if this is intended", source.RemotePath(sourcePrefix), r.RemotePath(targetPrefix))
+	}
+
+	if opts.Delete && opts.MaxDelete > 0 && len(report.Extra) > opts.MaxDelete {
+		return report.Divergent(), fmt.Errorf("%w: %d objects would be deleted, more than --max-delete %d, nothing was copied or deleted", ErrTooManyDeletes, len(report.Extra), opts.MaxDelete)
+	}
+	// the plan is checked before anything is copied or deleted
+	plan := newPlanHash(r.pricing(opts.Pricing), opts.Operations)
+	for _, rel := range report.Missing {
+		plan.Copy(r.RemotePath(path.Join(targetPrefix, rel)), report.sourceSizes[rel], 0, "no target object")
+	}
+	for _, rel := range report.Changed {
+		plan.Copy(r.RemotePath(path.Join(targetPrefix, rel)), report.sourceSizes[rel], report.targetSizes[rel], changeReason("target object", !opts.SizeOnly))
+	}
+	if opts.Delete {
+		for _, rel := range report.Extra {
+			plan.Delete(r.RemotePath(path.Join(targetPrefix, rel)), report.targetSizes[rel], "no source object")
+		}
+	}
+	planSum := plan.Sum()
+	log.Printf("Plan: %d operations, hash %s\n", plan.Len(), planSum)
+	if opts.DryRun {
+		plan.Estimate().Log()
+	}
+	if opts.ExpectPlan != "" && planSum != opts.ExpectPlan {
+		return report.Divergent(), fmt.Errorf("%w: expected %s, got %s", ErrPlanDrift, opts.ExpectPlan, planSum)
+	}
+
+	var wg sync.WaitGroup
+	var failures failureStats
+	var copied atomic.Int64
+	var stopErr error
+	semaphore := make(chan struct{}, opts.Concurrency)
+	copyCount := 0
+	for _, rel := range append(report.Missing, report.Changed...) {
+		if stopErr = stopped(); stopErr != nil {
+			break
+		}
+		wg.Add(1)
+		copyCount++
+		semaphore <- struct{}{}
+
+		go func(sourceKey, targetKey string, size int64) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			sourceURL, targetURL := source.RemotePath(sourceKey), r.RemotePath(targetKey)
+			emit(ProgressEvent{Type: "upload_start", Path: sourceURL, Target: targetURL, Size: size})
+			startTime := time.Now()
+			var err error
+			if preserve {
+				err = r.CopyObjectWithAttributes(ctx, source, sourceKey, targetKey, opts.DryRun)
+			} else {
+				err = r.CopyObject(ctx, source.bucket, sourceKey, targetKey, size, opts.DryRun)
+			}
+			if err != nil {
+				log.Printf("copy failed %s: %s\n", targetURL, ErrorDetail(err))
+				failures.Add(err)
+				emit(failedEvent("upload_failed", sourceURL, targetURL, size, err))
+				return
+			}
+			copied.Add(1)
+			emit(ProgressEvent{Type: "upload_done", Path: sourceURL, Target: targetURL, Size: size, Duration: time.Since(startTime)})
+			r.heartbeat()
+		}(path.Join(sourcePrefix, rel), path.Join(targetPrefix, rel), report.sourceSizes[rel])
+	}
+	wg.Wait()
+	log.Printf("%d files copied.\n", copyCount)
+	if stopErr != nil {
+		if errors.Is(stopErr, ErrMaxRuntime) {
+			log.Printf("Max runtime of %s reached, stopped scheduling new transfers.\n", opts.MaxRuntime)
+		} else {
+			log.Printf("Interrupted, stopped scheduling new transfers.\n")
+		}
+		logStopSummary("copy", int(copied.Load()), copyCount, failures.Total(), 0, opts.Delete)
+		failures.Log()
+		return report.Divergent(), stopErr
+	}
+
+	for _, rel := range holdKeys {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(key string, status types.ObjectLockLegalHoldStatus) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			if err := r.SetLegalHold(ctx, key, status, opts.DryRun); err != nil {
+				log.Printf("legal hold failed %s: %v\n", r.RemotePath(key), err)
+				failures.Add(err)
+			}
+		}(path.Join(targetPrefix, rel), report.HoldMismatch[rel])
+	}
+	wg.Wait()
+
+	deleteCount := 0
+	if opts.Delete && len(report.Extra) > 0 {
+		keys := make([]string, len(report.Extra))
+		for i, rel := range report.Extra {
+			keys[i] = path.Join(targetPrefix, rel)
+		}
+		failed, _ := r.DeleteObjects(ctx, keys, opts.DryRun)
+		for _, key := range keys {
+			if err := failed[key]; err != nil {
+				log.Printf("delete failed %s: %s\n", r.RemotePath(key), ErrorDetail(err))
+				failures.Add(err)
+				emit(failedEvent("delete_failed", "", r.RemotePath(key), 0, err))
+				continue
+			}
+			emit(ProgressEvent{Type: "delete_done", Target: r.RemotePath(key)})
+		}
+		deleteCount = len(keys) - len(failed)
+		log.Printf("%d files deleted.\n", deleteCount)
+	}
+
+	emit(ProgressEvent{Type: "sync_done", Path: source.RemotePath(sourcePrefix), Target: r.RemotePath(targetPrefix), Uploads: copyCount, Deletes: deleteCount, PlanHash: planSum})
+	failures.Log()
+	if total := failures.Total(); total > 0 {
+		return report.Divergent(), fmt.Errorf("%d %w", total, ErrPartialFailure)
+	}
+	return report.Divergent(), nil
+}
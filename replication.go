@@ -0,0 +1,116 @@
+package r2sync
+
+import (
+	"context"
+	"log"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type replicationTask struct {
+	key     string
+	size    int64 // size of the local file the object was written from
+	deleted bool
+}
+
+// replicationQueue mirrors changes of the primary target into a secondary bucket with server-side
+// copies processed by background workers, so replication doesn't cost upload bandwidth
+type replicationQueue struct {
+	ctx        context.Context
+	source     *R2Client
+	sourcePath string
+	target     Mirror
+	dryRun     bool
+	emit       func(ProgressEvent) // reports copies as uploads and deletes
+
+	tasks   chan replicationTask
+	wg      sync.WaitGroup
+	copied  atomic.Int64
+	deleted atomic.Int64
+	failed  atomic.Int64
+}
+
+func newReplicationQueue(ctx context.Context, source *R2Client, sourcePath string, target Mirror, workers int, dryRun bool, emit func(ProgressEvent)) *replicationQueue {
+	q := &replicationQueue{
+		ctx:        ctx,
+		source:     source,
+		sourcePath: sourcePath,
+		target:     target,
+		dryRun:     dryRun,
+		emit:       emit,
+		tasks:      make(chan replicationTask, 1000),
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue schedules replication of a primary key that was uploaded from a file of size bytes or
+// deleted
+func (q *replicationQueue) Enqueue(key string, size int64, deleted bool) {
+	q.tasks <- replicationTask{key: key, size: size, deleted: deleted}
+}
+
+// copySize returns the size of the object of task when its file is too large for a single copy,
+// which costs a HEAD request, and 0 for smaller files, which are copied in one request
+func (q *replicationQueue) copySize(task replicationTask) (int64, error) {
+	if task.size <= maxCopySize || q.dryRun {
+		return 0, nil
+	}
+	// a chunked or compressed file is written to objects of other sizes
+	head, err := q.source.client.HeadObject(q.ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(q.source.bucket),
+		Key:    aws.String(task.key),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return aws.ToInt64(head.ContentLength), nil
+}
+
+func (q *replicationQueue) work() {
+	defer q.wg.Done()
+	for task := range q.tasks {
+		targetKey := path.Join(q.target.RemotePath, RelativeKey(task.key, q.sourcePath))
+		sourceURL, targetURL := q.source.RemotePath(task.key), q.target.Client.RemotePath(targetKey)
+		if task.deleted {
+			if err := q.target.Client.DeleteObject(q.ctx, targetKey, q.dryRun); err != nil {
+				q.failed.Add(1)
+				log.Printf("replicate delete failed %s: %s\n", targetURL, ErrorDetail(err))
+				q.emit(failedEvent("delete_failed", "", targetURL, 0, err))
+				continue
+			}
+			q.deleted.Add(1)
+			q.emit(ProgressEvent{Type: "delete_done", Target: targetURL})
+		} else {
+			startTime := time.Now()
+			size, err := q.copySize(task)
+			if err == nil {
+				err = q.target.Client.CopyObject(q.ctx, q.source.bucket, task.key, targetKey, size, q.dryRun)
+			}
+			if err != nil {
+				q.failed.Add(1)
+				log.Printf("replicate copy failed %s: %s\n", targetURL, ErrorDetail(err))
+				q.emit(failedEvent("upload_failed", sourceURL, targetURL, 0, err))
+				continue
+			}
+			q.copied.Add(1)
+			q.emit(ProgressEvent{Type: "upload_done", Path: sourceURL, Target: targetURL, Duration: time.Since(startTime)})
+		}
+		q.source.heartbeat()
+	}
+}
+
+// Close waits for queued tasks to drain and logs a summary
+func (q *replicationQueue) Close() {
+	close(q.tasks)
+	q.wg.Wait()
+	log.Printf("%d files replicated, %d deleted, %d failed: %s\n", q.copied.Load(), q.deleted.Load(), q.failed.Load(), q.target.Client.RemotePath(q.target.RemotePath))
+}
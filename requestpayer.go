@@ -0,0 +1,34 @@
+package r2sync
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// RequestPayer returns an S3Option that adds x-amz-request-payer to every request, which
+// requester-pays buckets require for GET, LIST and as copy source. Buckets that are not
+// requester-pays ignore the header.
+func RequestPayer(value string) (S3Option, error) {
+	switch value {
+	case "":
+		return func(*s3.Options) {}, nil
+	case "requester":
+	default:
+		return nil, fmt.Errorf("invalid request payer: %s (valid value: requester)", value)
+	}
+	header := middleware.BuildMiddlewareFunc("RequestPayer", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+		if req, ok := in.Request.(*smithyhttp.Request); ok {
+			req.Header.Set("X-Amz-Request-Payer", value)
+		}
+		return next.HandleBuild(ctx, in)
+	})
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Build.Add(header, middleware.After)
+		})
+	}, nil
+}
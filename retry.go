@@ -0,0 +1,85 @@
+package r2sync
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// RetryPolicy controls how failed requests are retried
+type RetryPolicy struct {
+	MaxAttempts int           // attempts per request including the first, 1 disables retries
+	BaseDelay   time.Duration // delay before the first retry, doubled on every further retry
+	MaxDelay    time.Duration // upper bound of the delay between retries
+	Retryable   []ErrorClass  // error classes that are retried
+}
+
+// ParseErrorClasses parses a comma separated list of error classes
+func ParseErrorClasses(value string) ([]ErrorClass, error) {
+	var classes []ErrorClass
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, c := range errorClasses {
+			known = known || string(c.class) == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown error class: %s", name)
+		}
+		classes = append(classes, ErrorClass(name))
+	}
+	return classes, nil
+}
+
+// BackoffDelay returns an exponential delay with full jitter
+func (p RetryPolicy) BackoffDelay(attempt int, err error) (time.Duration, error) {
+	delay := p.MaxDelay
+	if attempt < 32 {
+		delay = min(p.BaseDelay<<(attempt-1), p.MaxDelay)
+	}
+	if delay <= 0 {
+		return 0, nil
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1), nil
+}
+
+func (p RetryPolicy) IsErrorRetryable(err error) aws.Ternary {
+	return aws.BoolTernary(slices.Contains(p.Retryable, classifyError(err)))
+}
+
+// apply replaces the sdk default retryer of an s3 client
+func (p RetryPolicy) Apply(o *s3.Options) {
+	o.Retryer = retry.NewStandard(func(so *retry.StandardOptions) {
+		so.MaxAttempts = p.MaxAttempts
+		so.MaxBackoff = p.MaxDelay
+		so.Backoff = p
+		so.Retryables = []retry.IsErrorRetryable{p}
+	})
+}
+
+// retryable reports whether an upload that still failed with err after the retries of its
+// requests may succeed when Sync attempts it once more after the other transfers, by the error
+// classes of the retry policy. Stalled transfers are re-queued by Sync instead.
+func (r *R2Client) retryable(err error) bool {
+	if errors.Is(err, errStalled) {
+		return false
+	}
+	if r.retry != nil {
+		return r.retry.MaxAttempts > 1 && r.retry.IsErrorRetryable(err) == aws.TrueTernary
+	}
+	switch classifyError(err) {
+	case classThrottling, classNetwork, classServer:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package r2sync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metaRun is the metadata key uploads record the id of their run in
+const metaRun = "r2sync-run"
+
+// maxRunRecords is the number of run records kept, older ones are removed when a run is saved
+const maxRunRecords = 100
+
+// RunChange is an object or local file changed by a run
+type RunChange struct {
+	Type   string `json:"type"` // upload, delete or download
+	Path   string `json:"path,omitempty"`
+	Target string `json:"target,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// RunRecord lists what a run changed, collected from its progress events and saved locally, so
+// the changes of a bad run can be listed afterwards
+type RunRecord struct {
+	mu      sync.Mutex
+	ID      string      `json:"id"` // sorts by start time
+	Start   time.Time   `json:"start"`
+	End     time.Time   `json:"end"`
+	Source  string      `json:"source"`
+	Target  string      `json:"target"`
+	Changes []RunChange `json:"changes"`
+}
+
+// NewRunID returns a unique id of a run that sorts by start time to the millisecond, like 20261016T170307.482Z-3fa9c1
+func NewRunID() string {
+	var random [3]byte
+	rand.Read(random[:])
+	return time.Now().UTC().Format("20060102T150405.000Z") + "-" + hex.EncodeToString(random[:])
+}
+
+// NewRunRecord starts the record of a run syncing source to target
+func NewRunRecord(source, target string) *RunRecord {
+	return &RunRecord{ID: NewRunID(), Start: time.Now(), Source: source, Target: target, Changes: []RunChange{}}
+}
+
+// Track records the change of a progress event, other events are ignored
+func (r *RunRecord) Track(event ProgressEvent) {
+	var change RunChange
+	switch event.Type {
+	case "upload_done":
+		change = RunChange{Type: "upload", Path: event.Path, Target: event.Target, Size: event.Size}
+	case "download_done":
+		change = RunChange{Type: "download", Path: event.Path, Target: event.Target, Size: event.Size}
+	case "delete_done":
+		change = RunChange{Type: "delete", Path: event.Path, Target: event.Target}
+	default:
+		return
+	}
+	r.mu.Lock()
+	r.Changes = append(r.Changes, change)
+	r.mu.Unlock()
+}
+
+// DefaultRunDir returns the directory of run records in the user cache directory
+func DefaultRunDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "r2sync", "runs")
+}
+
+// Save ends the run and writes its record to dir, removing the oldest records beyond the newest 100
+func (r *RunRecord) Save(dir string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.End = time.Now()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, r.ID+".json"), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	ids, err := runIDs(dir)
+	if err != nil {
+		return err
+	}
+	for len(ids) > maxRunRecords {
+		os.Remove(filepath.Join(dir, ids[0]+".json"))
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// runIDs returns the ids of the records in dir, oldest first
+func runIDs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// LoadRunRecord reads the record of run id from dir
+func LoadRunRecord(dir, id string) (*RunRecord, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("invalid run id %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no record of run %s in %s", id, dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record RunRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid run record %s: %v", id, err)
+	}
+	return &record, nil
+}
+
+// LastRunRecord reads the newest record in dir whose source or target starts with url, or of any
+// run when url is empty
+func LastRunRecord(dir, url string) (*RunRecord, error) {
+	ids, err := runIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		record, err := LoadRunRecord(dir, ids[i])
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(record.Source, url) || strings.HasPrefix(record.Target, url) {
+			return record, nil
+		}
+	}
+	if url != "" {
+		return nil, fmt.Errorf("no recorded run of %s in %s", url, dir)
+	}
+	return nil, fmt.Errorf("no recorded run in %s", dir)
+}
@@ -0,0 +1,31 @@
+package r2sync
+
+import "sort"
+
+// queuedTransfer is an upload held back until the walk is over so it can be interleaved
+type queuedTransfer struct {
+	target *syncTarget
+	key    string
+	run    func()
+}
+
+// interleaveShards sorts transfers by key, splits them into n contiguous key ranges and returns
+// them taking one from every range in turn, so concurrent requests are spread over distant parts
+// of the key space instead of hitting one narrow range
+func interleaveShards(transfers []queuedTransfer, n int) []queuedTransfer {
+	sort.SliceStable(transfers, func(i, j int) bool { return transfers[i].key < transfers[j].key })
+	n = min(n, len(transfers))
+	if n <= 1 {
+		return transfers
+	}
+	size := (len(transfers) + n - 1) / n
+	result := make([]queuedTransfer, 0, len(transfers))
+	for i := 0; i < size; i++ {
+		for shard := 0; shard < n; shard++ {
+			if index := shard*size + i; index < len(transfers) {
+				result = append(result, transfers[index])
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,37 @@
+package r2sync
+
+import (
+	"errors"
+	"log"
+)
+
+// ErrInterrupted is returned by Sync when it stopped scheduling new transfers because of an interrupt
+var ErrInterrupted = errors.New("interrupted")
+
+// logStopSummary logs how much of its work a sync that stopped early got done, notStarted counts the
+// operations that were dropped before they started
+func logStopSummary(operation string, completed, started, failed, notStarted int, deleteSkipped bool) {
+	log.Printf("Summary: %d of %d started %ss completed, %d failed or were aborted.\n", completed, started, operation, failed)
+	if notStarted > 0 {
+		log.Printf("%d %ss were not started and are left for the next run.\n", notStarted, operation)
+	}
+	if operation == "delete" {
+		// every file was compared before the delete phase
+		return
+	}
+	if deleteSkipped {
+		log.Println("Files not compared yet and the skipped delete phase are left for the next run.")
+	} else {
+		log.Println("Files not compared yet are left for the next run.")
+	}
+}
+
+// Draining reports whether the drain channel was closed
+func Draining(drain <-chan struct{}) bool {
+	select {
+	case <-drain:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,51 @@
+package r2sync
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultSmallObjectThreshold is the size up to which uploads take the small-object path
+const defaultSmallObjectThreshold = 1 << 20
+
+// smallObjectBuffers are reused to read small files in one go
+var smallObjectBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// readSmallObject reads a small file into a pooled buffer. The SDK hashes and sends the
+// in-memory body without seeking the file again; release returns the buffer to the pool.
+func readSmallObject(r io.Reader, size int64) (body *bytes.Reader, release func(), err error) {
+	buf := smallObjectBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	buf.Grow(int(size))
+	if _, err := buf.ReadFrom(r); err != nil {
+		smallObjectBuffers.Put(buf)
+		return nil, nil, err
+	}
+	return bytes.NewReader(buf.Bytes()), func() { smallObjectBuffers.Put(buf) }, nil
+}
+
+// withoutContinue never sends Expect: 100-continue, which costs a round trip per request
+func withoutContinue(o *s3.Options) {
+	o.ContinueHeaderThresholdBytes = -1
+}
+
+// ParseSize parses a byte size with an optional K, M, G or T suffix (powers of 1024), e.g. 512K
+func ParseSize(s string) (int64, error) {
+	units := map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	upper := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	number := strings.TrimRight(upper, "KMGT")
+	unit, ok := units[upper[len(number):]]
+	n, err := strconv.ParseInt(number, 10, 64)
+	if !ok || err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
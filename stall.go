@@ -0,0 +1,138 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// errStalled aborts a request whose body moved no bytes for the stall timeout
+var errStalled = errors.New("transfer stalled")
+
+// maxStallRequeues is how often a stalled upload is re-queued before it fails
+const maxStallRequeues = 3
+
+// stallWatch cancels a request when its request or response body moves no bytes for timeout. The
+// time between sending the request body and receiving the response headers is not watched, since
+// the backend may take a while to process a large upload.
+type stallWatch struct {
+	timeout  time.Duration
+	ctx      context.Context
+	last     atomic.Int64 // unix nanoseconds of the last progress, 0 while not watching
+	cancel   context.CancelCauseFunc
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+func newStallWatch(ctx context.Context, timeout time.Duration) (*stallWatch, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	w := &stallWatch{timeout: timeout, ctx: ctx, cancel: cancel, stopped: make(chan struct{})}
+	go w.run()
+	return w, ctx
+}
+
+func (w *stallWatch) run() {
+	ticker := time.NewTicker(max(w.timeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopped:
+			return
+		case now := <-ticker.C:
+			if last := w.last.Load(); last != 0 && now.Sub(time.Unix(0, last)) > w.timeout {
+				w.cancel(errStalled)
+				return
+			}
+		}
+	}
+}
+
+func (w *stallWatch) touch() {
+	w.last.Store(time.Now().UnixNano())
+}
+
+func (w *stallWatch) pause() {
+	w.last.Store(0)
+}
+
+func (w *stallWatch) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopped)
+		w.cancel(nil)
+	})
+}
+
+// stallBody reports the progress of a request or response body to its watch
+type stallBody struct {
+	body  io.ReadCloser
+	watch *stallWatch
+	// stops the watch when closed, set for response bodies
+	last bool
+}
+
+func (b *stallBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.watch.touch()
+	}
+	if err == io.EOF {
+		b.watch.pause()
+	}
+	return n, b.watch.wrap(err)
+}
+
+func (b *stallBody) Close() error {
+	err := b.body.Close()
+	if b.last {
+		b.watch.stop()
+	}
+	return err
+}
+
+// wrap reports a stall instead of the cancellation it caused
+func (w *stallWatch) wrap(err error) error {
+	if err != nil && err != io.EOF && errors.Is(context.Cause(w.ctx), errStalled) {
+		return fmt.Errorf("%w: no bytes moved for %s", errStalled, w.timeout)
+	}
+	return err
+}
+
+type stallHTTPClient struct {
+	next    s3.HTTPClient
+	timeout time.Duration
+}
+
+func (c stallHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	watch, ctx := newStallWatch(req.Context(), c.timeout)
+	req = req.WithContext(ctx)
+	if req.Body != nil && req.Body != http.NoBody {
+		watch.touch()
+		req.Body = &stallBody{body: req.Body, watch: watch}
+	}
+	resp, err := c.next.Do(req)
+	if err != nil {
+		watch.stop()
+		return nil, watch.wrap(err)
+	}
+	watch.touch()
+	resp.Body = &stallBody{body: resp.Body, watch: watch, last: true}
+	return resp, nil
+}
+
+// WithStallTimeout aborts requests of the client whose body moves no bytes for timeout
+func WithStallTimeout(timeout time.Duration) S3Option {
+	return func(o *s3.Options) {
+		next := o.HTTPClient
+		if next == nil {
+			next = http.DefaultClient
+		}
+		o.HTTPClient = stallHTTPClient{next: next, timeout: timeout}
+	}
+}
@@ -0,0 +1,141 @@
+package r2sync
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// sizeBuckets are the upper bounds of the transfer size histogram
+var sizeBuckets = []struct {
+	label string
+	limit int64
+}{
+	{"< 64 KB", 64 << 10},
+	{"< 1 MB", 1 << 20},
+	{"< 16 MB", 16 << 20},
+	{"< 128 MB", 128 << 20},
+	{"< 1 GB", 1 << 30},
+	{">= 1 GB", 1<<63 - 1},
+}
+
+// transferStats collects sizes and durations of successful transfers
+type transferStats struct {
+	mu        sync.Mutex
+	sizes     []int64
+	durations []time.Duration
+}
+
+func (s *transferStats) Add(size int64, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sizes = append(s.sizes, size)
+	s.durations = append(s.durations, duration)
+}
+
+// Count returns the number of successful transfers
+func (s *transferStats) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.durations)
+}
+
+// percentile returns the p-th percentile (0-100) of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p + 99) / 100
+	return sorted[max(i-1, 0)]
+}
+
+// Log prints transfer time percentiles and a size histogram with the share of the total transfer
+// time spent in each size range, showing whether time goes to many small files or a few large ones
+func (s *transferStats) Log() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sizes) == 0 {
+		return
+	}
+
+	sorted := slices.Clone(s.durations)
+	slices.Sort(sorted)
+	log.Printf("Transfer time: p50 %s, p95 %s, p99 %s, max %s\n",
+		percentile(sorted, 50).Round(time.Millisecond), percentile(sorted, 95).Round(time.Millisecond),
+		percentile(sorted, 99).Round(time.Millisecond), sorted[len(sorted)-1].Round(time.Millisecond))
+
+	counts := make([]int, len(sizeBuckets))
+	bytes := make([]int64, len(sizeBuckets))
+	durations := make([]time.Duration, len(sizeBuckets))
+	var totalDuration time.Duration
+	for i, size := range s.sizes {
+		bucket := 0
+		for size >= sizeBuckets[bucket].limit {
+			bucket++
+		}
+		counts[bucket]++
+		bytes[bucket] += size
+		durations[bucket] += s.durations[i]
+		totalDuration += s.durations[i]
+	}
+	log.Println("Transfer sizes:")
+	for i, bucket := range sizeBuckets {
+		if counts[i] == 0 {
+			continue
+		}
+		share := 0.0
+		if totalDuration > 0 {
+			share = float64(durations[i]) / float64(totalDuration) * 100
+		}
+		log.Printf("  %-9s %6d files, %10s, %5.1f%% of transfer time\n", bucket.label, counts[i], formatSize(bytes[i]), share)
+	}
+}
+
+// failureStats counts failed transfers by ErrorClass
+type failureStats struct {
+	mu     sync.Mutex
+	counts map[ErrorClass]int
+}
+
+func (s *failureStats) Add(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[ErrorClass]int)
+	}
+	s.counts[classifyError(err)]++
+}
+
+// Total returns the number of failures
+func (s *failureStats) Total() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, count := range s.counts {
+		total += count
+	}
+	return total
+}
+
+// Log prints failure counts grouped by class with a hint to the likely fix
+func (s *failureStats) Log() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.counts) == 0 {
+		return
+	}
+	log.Println("Failures:")
+	for _, c := range errorClasses {
+		count := s.counts[c.class]
+		if count == 0 {
+			continue
+		}
+		line := fmt.Sprintf("  %-13s %6d", c.class, count)
+		if c.hint != "" {
+			line += " (" + c.hint + ")"
+		}
+		log.Println(line)
+	}
+}
@@ -0,0 +1,1134 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Mirror is an additional target that receives the same uploads as the primary target
+type Mirror struct {
+	Client     *R2Client
+	RemotePath string
+}
+
+// SyncOptions controls how Sync compares and transfers files
+type SyncOptions struct {
+	Delete          bool     // delete remote files that don't exist locally
+	DryRun          bool     // only display the operations to be performed
+	Recursive       bool     // synchronize subdirectories
+	Concurrency     int      // number of concurrent upload/delete operations, 0 derives it from the plan
+	SizeOnly        bool     // only use file size to determine if files are the same
+	IgnoreExisting  bool     // only transfer files missing on the other side, never update existing ones
+	Existing        bool     // only update files that exist on the other side, never add new ones
+	ExcludePatterns []string // file or directory patterns to skip
+	Filters         []Filter // exclude and include patterns checked after ExcludePatterns, the last match wins
+	ExcludeHidden   bool     // skip dotfiles and dot-directories below the local path
+	IncludeMIME     []string // when set, only files whose detected MIME type matches one of these patterns
+	ExcludeMIME     []string // files whose detected MIME type matches one of these patterns are skipped
+	Mirrors         []Mirror // additional targets uploaded to concurrently
+	ReplicateTo     []Mirror // secondary targets receiving server-side copies of primary changes
+	MaxRuntime      time.Duration
+	CheckpointFile  string          // records confirmed files when the sync stops early
+	Drain           <-chan struct{} // closed to stop scheduling new transfers
+
+	// compares modification times instead of sizes and checksums: files are only transferred when
+	// they are newer than the other side by more than ClockSkew, which tolerates clocks that differ
+	Update    bool
+	ClockSkew time.Duration
+
+	// abort incomplete multipart uploads under the target prefixes older than this before syncing
+	AbortMultipartOlderThan time.Duration
+
+	// receives structured progress events, called concurrently from transfer goroutines
+	Progress func(ProgressEvent)
+
+	// receives every planned operation before it runs, also with DryRun
+	Operations func(Operation)
+
+	// rates the EstimatedCost of operations and the cost estimate of dry runs are computed with, nil
+	// uses S3Pricing for s3:// targets and R2Pricing for others
+	Pricing *Pricing
+
+	// holds back new transfers while paused
+	Pause *PauseGate
+
+	// holds back new transfers outside these daily hours, nil allows transfers at any time
+	Window *TransferWindow
+
+	// failure ratio of recent requests that pauses transfers until the backend recovers, 0 disables
+	BreakerThreshold float64
+
+	// connections opened to every target before the first transfer
+	Prewarm int
+
+	// bundles small files into tar objects, nil uploads every file as its own object
+	Pack *PackOptions
+
+	// files larger than this are uploaded as chunk objects plus a manifest, 0 disables
+	ChunkSize int64
+
+	// store per-directory rollups remotely and skip subtrees whose rollup is unchanged
+	DirManifests bool
+
+	// compare against a Merkle tree manifest stored as one object instead of listing the target
+	TreeManifest bool
+
+	// measure the upload rate before deriving the default concurrency
+	ProbeBandwidth bool
+
+	// splits the uploads into this many key ranges and interleaves them, 0 or 1 uploads in walk order
+	Shards int
+
+	// runs transfers one at a time in sorted order, so identical runs log identical operations
+	Deterministic bool
+
+	// fails the sync with ErrPlanDrift when the plan hash differs. Without DryRun a dry run checks
+	// the plan before anything changes, a drift between the two runs is reported after the sync.
+	ExpectPlan string
+
+	// renames relative paths before they become keys, nil keeps the local layout
+	Transform *KeyTransform
+
+	// logs why each file was skipped, skip reasons are always sent as file_skipped progress events
+	Verbose bool
+
+	// allows Delete when the source has no files, which otherwise is refused as a likely wrong path
+	// or unmounted volume
+	AllowEmptySource bool
+
+	// with Delete, fails with ErrTooManyDeletes before deleting anything when more than this many
+	// objects or local files would be deleted, 0 doesn't limit
+	MaxDelete int
+
+	// part size of multipart uploads by other tools, tried before common defaults when a multipart
+	// ETag is compared with a local file, 0 only tries the defaults
+	PartSize int64
+
+	// read instead of listing the primary target, nil lists it
+	Inventory *Inventory
+
+	// listings of the targets reused while fresh, nil lists them on every run
+	ListingCache *ListingCache
+
+	// records the newest synced file of an append-only source here and only uploads files with a
+	// later modification time, or the same one and a later name, without listing the targets. Files
+	// changed or added with an older modification time are not found.
+	HighWaterFile string
+
+	// re-lists the targets after the uploads and fails with ErrListingMismatch before deleting
+	// when an uploaded key is missing or has an unexpected size
+	VerifyListing bool
+}
+
+// ErrListingMismatch is returned by Sync with VerifyListing when uploaded objects are missing from
+// the listing of the target or have an unexpected size
+var ErrListingMismatch = errors.New("uploaded objects don't match the listing")
+
+// ErrTooManyDeletes is returned by Sync, Pull and Replicate when more than MaxDelete objects or
+// local files would be deleted
+var ErrTooManyDeletes = errors.New("too many deletes")
+
+// ErrPartialFailure is returned by Sync and Pull when some uploads, downloads or deletes failed
+// while the others completed, the failures are logged grouped by error class
+var ErrPartialFailure = errors.New("operations failed")
+
+// verifyAttempts is how often the target is listed before missing uploads fail the verification
+const verifyAttempts = 3
+
+// syncTarget is a remote location receiving the local tree
+type syncTarget struct {
+	client      *R2Client
+	remotePath  string
+	remoteFiles map[string]FileInfo
+	chunks      map[string][]string // chunk keys by the key of their manifest
+	unchanged   map[string]bool     // directories whose rollup matches, not listed or compared
+	listed      string              // prefix whose listing may be cached, "" when not listed
+	changed     []string            // directories whose rollup is stored after the sync
+	tree        *treeManifest       // stored tree manifest, nil before the first tree sync
+	uploadCount int
+	deleteCount int
+
+	mu       sync.Mutex
+	uploaded map[string]int64 // size of every uploaded key, -1 when only its existence is verified
+}
+
+// recordUpload remembers uploaded keys for VerifyListing
+func (t *syncTarget) recordUpload(keys []string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.uploaded == nil {
+		t.uploaded = make(map[string]int64)
+	}
+	for _, key := range keys {
+		t.uploaded[key] = size
+	}
+}
+
+// verifyListing lists prefix and returns the uploaded keys that are missing or have another size.
+// Missing keys are listed again after a short delay, in case the backend lists new objects late.
+func (t *syncTarget) verifyListing(ctx context.Context, prefix string) ([]string, error) {
+	var mismatched []string
+	for attempt := 0; attempt < verifyAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Second << (attempt - 1)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		listed, err := t.client.ListObjects(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		mismatched = mismatched[:0]
+		for key, size := range t.uploaded {
+			object, exists := listed[key]
+			switch {
+			case !exists:
+				mismatched = append(mismatched, fmt.Sprintf("%s: missing", t.client.RemotePath(key)))
+			case size >= 0 && object.Size != size:
+				mismatched = append(mismatched, fmt.Sprintf("%s: size %d, expected %d", t.client.RemotePath(key), object.Size, size))
+			}
+		}
+		if len(mismatched) == 0 {
+			return nil, nil
+		}
+	}
+	sort.Strings(mismatched)
+	return mismatched, nil
+}
+
+// singleFileKey returns the key a single file source is synced to. The target is the full key
+// unless it is empty or ends with / like a prefix, then the file name is appended.
+func singleFileKey(localPath, remotePath string) string {
+	if remotePath == "" || strings.HasSuffix(remotePath, "/") {
+		return remotePath + path.Base(localPath)
+	}
+	return remotePath
+}
+
+// requeuedUpload is an upload that failed with a transient error and runs again once the other
+// transfers are done
+type requeuedUpload struct {
+	run func()
+	err error // the failure reported when it doesn't run again
+}
+
+// filterReason returns why a file or directory is filtered out of the sync, "" when it isn't
+func filterReason(localPath, fullpath string, dir bool, opts SyncOptions) string {
+	switch {
+	case shouldExclude(fullpath, opts.ExcludePatterns), filteredOut(fullpath, dir, opts.Filters):
+		return "excluded"
+	case opts.ExcludeHidden && isHidden(localPath, fullpath):
+		return "hidden"
+	}
+	return ""
+}
+
+// compareReason describes why a file matching the remote copy is skipped
+func compareReason(remote string, hashed, checkpointed bool) string {
+	switch {
+	case hashed:
+		return "size and md5 match the " + remote
+	case checkpointed:
+		return "size matches the " + remote + ", content confirmed by the checkpoint"
+	default:
+		return "size matches the " + remote
+	}
+}
+
+// changeReason explains an upload or download of a file whose size, or md5 when hashed, differs
+// from the copy the remote describes
+func changeReason(remote string, hashed bool) string {
+	if hashed {
+		return "size or md5 differs from the " + remote
+	}
+	return "size differs from the " + remote
+}
+
+// listingPrefix returns the prefix listed for a target, the directory below it holding every local
+// file and a trailing slash so keys of sibling prefixes like path2/ aren't listed
+func listingPrefix(remotePath, subdir string) string {
+	prefix := remotePath
+	if subdir != "" {
+		prefix = path.Join(remotePath, subdir)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// commonLocalDir returns the deepest directory below localPath that contains every file, "" when
+// localPath itself has files or more than one entry
+func commonLocalDir(localPath string) string {
+	dir := ""
+	for {
+		entries, err := os.ReadDir(path.Join(localPath, dir))
+		if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+			return dir
+		}
+		dir = path.Join(dir, entries[0].Name())
+	}
+}
+
+// Sync uploads the local tree to remotePath and every mirror. The tree is walked and hashed once
+// and each file is compared against every target separately.
+//
+// When MaxRuntime is reached or Drain is closed no new transfers are scheduled, in-flight ones
+// finish, the delete phase is skipped, a checkpoint is written and ErrMaxRuntime or ErrInterrupted
+// is returned. The next run skips hashing files the checkpoint confirmed unless their size or
+// modification time changed. Closing Drain during the delete phase lets in-flight delete batches
+// finish and deletes nothing else. Cancelling ctx aborts in-flight transfers.
+func (r *R2Client) Sync(ctx context.Context, localPath, remotePath string, opts SyncOptions) error {
+	if opts.ExpectPlan != "" && !opts.DryRun {
+		// a dry run checks the plan first, so nothing is transferred or deleted when it drifted
+		log.Println("Checking the plan with a dry run ...")
+		check := opts
+		// the checkpoint is left for the real run, a completed dry run would remove it
+		check.DryRun, check.Progress, check.Operations, check.CheckpointFile = true, nil, nil, ""
+		if err := r.Sync(ctx, localPath, remotePath, check); err != nil {
+			return err
+		}
+		log.Println("Plan matches the expected plan, running it ...")
+	}
+	var deadline time.Time
+	if opts.MaxRuntime > 0 {
+		deadline = time.Now().Add(opts.MaxRuntime)
+	}
+	single := false
+	if info, err := os.Stat(localPath); err == nil && info.Mode().IsRegular() {
+		// a single file is compared against exactly one key instead of a listing of the prefix
+		single = true
+		remotePath = singleFileKey(localPath, remotePath)
+		opts.Pack, opts.DirManifests, opts.TreeManifest = nil, false, false
+		opts.Mirrors, opts.ReplicateTo = slices.Clone(opts.Mirrors), slices.Clone(opts.ReplicateTo)
+		for i, mirror := range opts.Mirrors {
+			opts.Mirrors[i].RemotePath = singleFileKey(localPath, mirror.RemotePath)
+		}
+		for i, mirror := range opts.ReplicateTo {
+			opts.ReplicateTo[i].RemotePath = singleFileKey(localPath, mirror.RemotePath)
+		}
+	}
+	var progress *checkpoint
+	if opts.CheckpointFile != "" {
+		progress = loadCheckpoint(opts.CheckpointFile, localPath, r.RemotePath(remotePath))
+	}
+	var mark *highWaterMark
+	if opts.HighWaterFile != "" {
+		mark = loadHighWaterMark(opts.HighWaterFile, localPath, r.RemotePath(remotePath))
+	}
+	var journal *deleteJournal
+	journalDone := false
+	if opts.Delete && opts.CheckpointFile != "" && !opts.DryRun {
+		var err error
+		if journal, err = openDeleteJournal(deleteJournalPath(opts.CheckpointFile)); err != nil {
+			return fmt.Errorf("failed to open delete journal: %v", err)
+		}
+		// kept for the next run unless every delete went through
+		defer func() {
+			if err := journal.Close(journalDone); err != nil {
+				log.Printf("failed to close delete journal %s: %v\n", journal.path, err)
+			}
+		}()
+	}
+	var stopErr error
+	emit := func(event ProgressEvent) {
+		if opts.Progress != nil {
+			event.Time = time.Now()
+			opts.Progress(event)
+		}
+	}
+	emit(ProgressEvent{Type: "sync_start", Path: localPath, Target: r.RemotePath(remotePath)})
+	skipped := func(fullpath, target, reason string) {
+		if opts.Verbose {
+			if target != "" {
+				log.Printf("skipped %s -> %s: %s\n", fullpath, target, reason)
+			} else {
+				log.Printf("skipped %s: %s\n", fullpath, reason)
+			}
+		}
+		emit(ProgressEvent{Type: "file_skipped", Path: fullpath, Target: target, Reason: reason})
+	}
+
+	targets := []*syncTarget{{client: r, remotePath: remotePath}}
+	for _, mirror := range opts.Mirrors {
+		targets = append(targets, &syncTarget{client: mirror.Client, remotePath: mirror.RemotePath})
+	}
+	for _, target := range targets {
+		if opts.AbortMultipartOlderThan <= 0 {
+			break
+		}
+		abortCount, err := target.client.AbortStaleMultipartUploads(ctx, target.remotePath, opts.AbortMultipartOlderThan, opts.DryRun)
+		if err != nil {
+			return fmt.Errorf("failed to list incomplete multipart uploads: %v", err)
+		}
+		if abortCount > 0 {
+			log.Printf("%d incomplete multipart uploads aborted: %s\n", abortCount, target.client.RemotePath(target.remotePath))
+		}
+	}
+	var rollups map[string]*dirRollup
+	if opts.DirManifests || opts.TreeManifest {
+		var err error
+		if rollups, err = localRollups(r.IOLimit, localPath, opts); err != nil {
+			return fmt.Errorf("failed to summarize local directories: %v", err)
+		}
+	}
+	if opts.Deterministic {
+		opts.Concurrency = 1
+		opts.Shards = 0
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = r.autoConcurrency(ctx, localPath, remotePath, opts, rollups)
+	}
+	// without deletes only the keys of local files are compared, so a source whose files are all
+	// inside one subdirectory only lists the matching subprefix
+	narrowDir := ""
+	if !opts.Delete && opts.Transform == nil && !single && opts.Recursive {
+		narrowDir = commonLocalDir(localPath)
+	}
+	for _, target := range targets {
+		var remoteFiles map[string]FileInfo
+		var err error
+		if opts.TreeManifest {
+			log.Printf("Getting tree manifest: %s ...\n", target.client.RemotePath(treeManifestKey(target.remotePath)))
+			if target.tree, err = target.client.loadTreeManifest(ctx, target.remotePath); err != nil {
+				return fmt.Errorf("failed to get tree manifest: %v", err)
+			}
+		}
+		if mark != nil {
+			if mark.ModTime.IsZero() {
+				log.Printf("No high-water mark yet, uploading every file: %s\n", target.client.RemotePath(target.remotePath))
+			} else {
+				log.Printf("Skipping remote file list, uploading files after %s (%s): %s\n", mark.Name, mark.ModTime.Local().Format("2006-01-02 15:04:05"), target.client.RemotePath(target.remotePath))
+			}
+			remoteFiles = make(map[string]FileInfo)
+		} else if target.tree != nil {
+			target.unchanged, target.changed = target.tree.Compare(rollups)
+			log.Printf("%d of %d directories changed.\n", len(target.changed), len(rollups))
+			remoteFiles = target.tree.RemoteFiles(target.remotePath, rollups, target.changed)
+		} else if opts.DirManifests {
+			log.Printf("Comparing directory manifests: %s ...\n", target.client.RemotePath(target.remotePath))
+			target.unchanged, target.changed, err = target.client.compareDirManifests(ctx, target.remotePath, rollups, opts.Concurrency)
+			if err != nil {
+				return fmt.Errorf("failed to compare directory manifests: %v", err)
+			}
+			log.Printf("%d of %d directories changed.\n", len(target.changed), len(rollups))
+			remoteFiles, err = target.client.listChangedDirs(ctx, target.remotePath, rollups, target.changed)
+		} else {
+			prefix := target.remotePath
+			if !single {
+				prefix = listingPrefix(target.remotePath, narrowDir)
+			}
+			if opts.Inventory != nil && target == targets[0] {
+				log.Printf("Reading remote file list from inventory: %s ...\n", opts.Inventory.Client.RemotePath(opts.Inventory.Key))
+				remoteFiles, err = opts.Inventory.Load(ctx, target.client.bucket, prefix)
+			} else {
+				log.Printf("Getting remote file list: %s ...\n", target.client.RemotePath(prefix))
+				remoteFiles, err = opts.ListingCache.listObjects(ctx, target.client, prefix)
+				target.listed = prefix
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get remote file list: %v", err)
+		}
+		delete(remoteFiles, treeManifestKey(target.remotePath))
+		if single {
+			// the listing also returns keys the file's key is a prefix of
+			for key := range remoteFiles {
+				if key != target.remotePath {
+					delete(remoteFiles, key)
+				}
+			}
+		}
+		if opts.Pack != nil {
+			for key := range remoteFiles {
+				if isPackKey(target.remotePath, key) {
+					delete(remoteFiles, key)
+				}
+			}
+		}
+		target.remoteFiles = remoteFiles
+		target.chunks = make(map[string][]string)
+		for key := range remoteFiles {
+			if i := strings.Index(key, chunkSuffix+"/"); i >= 0 {
+				target.chunks[key[:i]] = append(target.chunks[key[:i]], key)
+			}
+		}
+	}
+
+	for _, target := range targets {
+		if opts.Prewarm > 0 && !opts.DryRun {
+			target.client.Prewarm(ctx, opts.Prewarm)
+		}
+	}
+
+	queues := make([]*replicationQueue, 0, len(opts.ReplicateTo))
+	for _, mirror := range opts.ReplicateTo {
+		if err := mirror.Client.CheckCopySource(r); err != nil {
+			return err
+		}
+		queues = append(queues, newReplicationQueue(ctx, r, remotePath, mirror, opts.Concurrency, opts.DryRun, emit))
+	}
+	replicate := func(client *R2Client, key string, size int64, deleted bool) {
+		if client != r {
+			return
+		}
+		for _, q := range queues {
+			q.Enqueue(key, size, deleted)
+		}
+	}
+
+	breakerCtx, stopBreaker := context.WithCancel(ctx)
+	defer stopBreaker()
+	breaker := newCircuitBreaker(breakerCtx, opts.BreakerThreshold, func(ctx context.Context) error {
+		for _, target := range targets {
+			if err := target.client.Probe(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	var stats transferStats
+	var packFiles []packFile
+	var failures failureStats
+	// the cached listings miss the changes of this run, also when it fails or stops early
+	defer func() {
+		for _, target := range targets {
+			if target.listed != "" && !opts.DryRun && (target.uploadCount > 0 || target.deleteCount > 0 || failures.Total() > 0) {
+				opts.ListingCache.invalidate(target.client, target.listed)
+			}
+		}
+	}()
+	var sharded []queuedTransfer
+	// uploads that failed with a transient error, attempted once more after all other transfers
+	var requeueMu sync.Mutex
+	var requeued []requeuedUpload
+	plan := newPlanHash(r.pricing(opts.Pricing), opts.Operations)
+	localCount := 0
+	// relative paths by the key path they were transformed to
+	transformed := make(map[string]string)
+	semaphore := make(chan struct{}, opts.Concurrency)
+	stopped := func() error {
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case Draining(opts.Drain):
+			return ErrInterrupted
+		case !deadline.IsZero() && time.Now().After(deadline):
+			return ErrMaxRuntime
+		}
+		return nil
+	}
+	err := filepath.Walk(localPath, func(fullpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		fullpath = NormalizePath(fullpath)
+		r.heartbeat()
+		if reason := filterReason(localPath, fullpath, info.IsDir(), opts); reason != "" {
+			skipped(fullpath, "", reason)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !opts.Recursive && !single && path.Dir(fullpath) != localPath {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if rollups != nil {
+				relDir, _ := filepath.Rel(localPath, fullpath)
+				if relDir = NormalizePath(relDir); relDir == "." {
+					relDir = ""
+				}
+				for _, target := range targets {
+					if !target.skipsDir(relDir) {
+						return nil
+					}
+				}
+				skipped(fullpath, "", "directory unchanged")
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if excluded, err := mimeExcluded(r.IOLimit, fullpath, opts); err != nil || excluded {
+			if excluded {
+				skipped(fullpath, "", "excluded by MIME type")
+			}
+			return err
+		}
+		opts.Pause.Wait(ctx, opts.Drain)
+		opts.Window.Wait(ctx, opts.Drain)
+		breaker.Wait(ctx, opts.Drain)
+		if stopErr = stopped(); stopErr != nil {
+			return filepath.SkipAll
+		}
+
+		relPath, _ := filepath.Rel(localPath, fullpath)
+		relPath = NormalizePath(relPath)
+		if mark != nil && !mark.Beyond(relPath, info) {
+			skipped(fullpath, "", "not after the high-water mark")
+			return nil
+		}
+		keyPath := relPath
+		if opts.Transform != nil && !single {
+			var ok bool
+			if keyPath, ok = opts.Transform.Apply(relPath); !ok {
+				skipped(fullpath, "", "no key left after --strip-components or --transform")
+				return nil
+			}
+			if other, exists := transformed[keyPath]; exists {
+				return fmt.Errorf("%s and %s are both transformed to %s", other, relPath, keyPath)
+			}
+			transformed[keyPath] = relPath
+		}
+		localCount++
+		if opts.Pack != nil && info.Size() <= opts.Pack.Threshold {
+			packFiles = append(packFiles, packFile{relPath: relPath, fullPath: fullpath, info: info})
+			for _, target := range targets {
+				delete(target.remoteFiles, path.Join(target.remotePath, keyPath))
+			}
+			return nil
+		}
+		checkpointed := progress.Done(relPath, info)
+
+		// calculated at most once and shared by all targets
+		etag := ""
+		// uploads of this file that must succeed before it is recorded in the checkpoint
+		pending := &atomic.Int32{}
+		pending.Add(1)
+		done := func() {
+			if pending.Add(-1) == 0 {
+				progress.Record(relPath, info)
+			}
+		}
+		for _, target := range targets {
+			if target.skipsDir(parentDir(relPath)) {
+				continue
+			}
+			remoteKey := path.Join(target.remotePath, keyPath)
+
+			needUpload := false
+			reason, change := "", ""
+			chunked := opts.ChunkSize > 0 && info.Size() > opts.ChunkSize
+			remoteInfo, exists := target.remoteFiles[remoteKey]
+			if opts.IgnoreExisting && exists {
+				reason = "object exists, --ignore-existing"
+			} else if opts.Existing && !exists {
+				reason = "no object, --existing"
+			} else if opts.Update && exists {
+				// the object was written when it was uploaded, so an unchanged file is older
+				needUpload = info.ModTime().After(remoteInfo.LastModified.Add(opts.ClockSkew))
+				reason = "file is not newer than the object, --update"
+				change = "file is newer than the object"
+			} else if chunked && exists {
+				// chunked copies are compared against the size and md5 in their manifest
+				compare := ""
+				if !opts.SizeOnly && !checkpointed {
+					if etag == "" {
+						etag, err = calcETag(r.IOLimit, fullpath)
+						if err != nil {
+							return err
+						}
+					}
+					compare = etag
+				}
+				needUpload, err = target.client.ChunkedChanged(ctx, remoteKey, info, compare)
+				reason = compareReason("chunk manifest", compare != "", checkpointed)
+				change = changeReason("chunk manifest", compare != "")
+				if err != nil {
+					log.Printf("failed to read chunk manifest %s: %s\n", target.client.RemotePath(remoteKey), ErrorDetail(err))
+				}
+			} else if !exists {
+				needUpload = true
+				change = "no object"
+				if mark != nil {
+					change = "after the high-water mark"
+				}
+			} else if target.client.Compression != "" {
+				// compressed copies are compared against the original size and md5 in their metadata
+				compare := ""
+				if !opts.SizeOnly && !checkpointed {
+					if etag == "" {
+						etag, err = calcETag(r.IOLimit, fullpath)
+						if err != nil {
+							return err
+						}
+					}
+					compare = etag
+				}
+				needUpload, err = target.client.CompressedChanged(ctx, remoteKey, info, compare)
+				reason = compareReason("compressed object", compare != "", checkpointed)
+				change = changeReason("compressed object", compare != "")
+				if err != nil {
+					log.Printf("failed to read metadata %s: %s\n", target.client.RemotePath(remoteKey), ErrorDetail(err))
+				}
+			} else {
+				reason = compareReason("object", !opts.SizeOnly && !checkpointed, checkpointed)
+				change = "size differs from the object"
+				if opts.SizeOnly || checkpointed {
+					needUpload = info.Size() != remoteInfo.Size
+				} else {
+					if etag == "" {
+						etag, err = calcETag(r.IOLimit, fullpath)
+						if err != nil {
+							return err
+						}
+					}
+					needUpload = info.Size() != remoteInfo.Size
+					if !needUpload {
+						matched, err := target.client.etagMatches(ctx, remoteKey, fullpath, info.Size(), etag, remoteInfo.ETag, opts.PartSize)
+						if err != nil {
+							return err
+						}
+						needUpload = !matched
+						change = "md5 differs from the object"
+					}
+				}
+			}
+			if needUpload {
+				chunkSize := int64(0)
+				if chunked {
+					chunkSize = opts.ChunkSize
+				}
+				plan.Upload(target.client.RemotePath(remoteKey), info.Size(), remoteInfo.Size, chunkSize, change)
+				if target.client.Meter != nil {
+					target.client.Meter.Plan(info.Size())
+				}
+				wg.Add(1)
+				target.uploadCount++
+				pending.Add(1)
+
+				var upload func(client *R2Client, localPath, remoteKey string, size int64, chunked, lastPass bool)
+				upload = func(client *R2Client, localPath, remoteKey string, size int64, chunked, lastPass bool) {
+					defer wg.Done()
+					defer func() { <-semaphore }()
+
+					fullKey := client.RemotePath(remoteKey)
+					log.Printf("uploading %s -> %s ...\n", localPath, fullKey)
+					emit(ProgressEvent{Type: "upload_start", Path: localPath, Target: fullKey, Size: size})
+					startTime := time.Now()
+					written := []string{remoteKey}
+					var err error
+					for requeues := 0; ; requeues++ {
+						if chunked {
+							written, err = client.UploadChunked(ctx, localPath, remoteKey, opts.ChunkSize, opts.DryRun)
+						} else {
+							err = client.UploadFile(ctx, localPath, remoteKey, opts.DryRun)
+						}
+						if !errors.Is(err, errStalled) || requeues == maxStallRequeues || ctx.Err() != nil {
+							break
+						}
+						backoff := time.Second << requeues
+						log.Printf("upload stalled %s, re-queued in %s\n", fullKey, backoff)
+						// the worker is free for other transfers during the backoff
+						<-semaphore
+						select {
+						case <-time.After(backoff):
+						case <-ctx.Done():
+						}
+						semaphore <- struct{}{}
+					}
+					breaker.Record(err)
+					if err != nil && !lastPass && client.retryable(err) && ctx.Err() == nil {
+						log.Printf("upload failed %s, attempted again after the other transfers: %s\n", fullKey, ErrorDetail(err))
+						requeueMu.Lock()
+						requeued = append(requeued, requeuedUpload{err: err, run: func() {
+							upload(client, localPath, remoteKey, size, chunked, true)
+						}})
+						requeueMu.Unlock()
+					} else if err != nil {
+						log.Printf("upload failed %s: %s\n", fullKey, ErrorDetail(err))
+						failures.Add(err)
+						emit(failedEvent("upload_failed", localPath, fullKey, size, err))
+					} else {
+						duration := time.Since(startTime)
+						stats.Add(size, duration)
+						emit(ProgressEvent{Type: "upload_done", Path: localPath, Target: fullKey, Size: size, Duration: duration})
+						for _, key := range written {
+							replicate(client, key, size, false)
+						}
+						if opts.VerifyListing {
+							// chunk and compressed objects differ in size from the local file
+							expected := size
+							if chunked || client.Compression != "" {
+								expected = -1
+							}
+							target.recordUpload(written, expected)
+						}
+						done()
+					}
+					r.heartbeat()
+				}
+				client, size := target.client, info.Size()
+				run := func() { upload(client, fullpath, remoteKey, size, chunked, false) }
+				if opts.Shards > 1 {
+					sharded = append(sharded, queuedTransfer{target: target, key: remoteKey, run: run})
+				} else {
+					semaphore <- struct{}{}
+					go run()
+				}
+			} else {
+				skipped(fullpath, target.client.RemotePath(remoteKey), reason)
+			}
+
+			delete(target.remoteFiles, remoteKey)
+			if chunked {
+				for _, key := range target.chunks[remoteKey] {
+					delete(target.remoteFiles, key)
+				}
+			}
+		}
+		done()
+
+		return nil
+	})
+
+	if len(sharded) > 0 {
+		log.Printf("Uploading %d files interleaved across %d key ranges ...\n", len(sharded), min(opts.Shards, len(sharded)))
+	}
+	for i, transfer := range interleaveShards(sharded, opts.Shards) {
+		if err == nil && stopErr == nil {
+			opts.Pause.Wait(ctx, opts.Drain)
+			opts.Window.Wait(ctx, opts.Drain)
+			breaker.Wait(ctx, opts.Drain)
+			stopErr = stopped()
+		}
+		if err != nil || stopErr != nil {
+			// dropped before it started, so the checkpoint doesn't record the file
+			transfer.target.uploadCount--
+			wg.Done()
+			continue
+		}
+		if i%100 == 0 {
+			r.heartbeat()
+		}
+		semaphore <- struct{}{}
+		go transfer.run()
+	}
+
+	if err != nil {
+		wg.Wait()
+		for _, q := range queues {
+			q.Close()
+		}
+		for _, upload := range requeued {
+			failures.Add(upload.err)
+		}
+		failures.Log()
+		return fmt.Errorf("upload failed: %v", err)
+	}
+
+	wg.Wait()
+	if len(requeued) > 0 && stopErr == nil && ctx.Err() == nil && !Draining(opts.Drain) {
+		log.Printf("Retrying %d failed uploads ...\n", len(requeued))
+		for _, upload := range requeued {
+			wg.Add(1)
+			semaphore <- struct{}{}
+			go upload.run()
+		}
+		wg.Wait()
+	} else {
+		for _, upload := range requeued {
+			failures.Add(upload.err)
+		}
+	}
+	for _, target := range targets {
+		if len(targets) == 1 {
+			log.Printf("%d files uploaded.\n", target.uploadCount)
+		} else {
+			log.Printf("%d files uploaded to %s.\n", target.uploadCount, target.client.RemotePath(target.remotePath))
+		}
+	}
+	if !opts.DryRun && !opts.Deterministic {
+		stats.Log()
+	}
+
+	if stopErr != nil {
+		for _, q := range queues {
+			q.Close()
+		}
+		if errors.Is(stopErr, ErrMaxRuntime) {
+			log.Printf("Max runtime of %s reached, stopped scheduling new transfers.\n", opts.MaxRuntime)
+		} else {
+			log.Printf("Interrupted, stopped scheduling new transfers.\n")
+		}
+		started := 0
+		for _, target := range targets {
+			started += target.uploadCount
+		}
+		logStopSummary("upload", stats.Count(), started, failures.Total(), 0, opts.Delete)
+		if progress != nil {
+			if err := progress.Save(); err != nil {
+				log.Printf("failed to save checkpoint %s: %v\n", progress.path, err)
+			} else {
+				log.Printf("Checkpoint saved: %s\n", progress.path)
+			}
+		}
+		failures.Log()
+		return stopErr
+	}
+	if progress != nil {
+		progress.Remove()
+	}
+
+	if rollups != nil {
+		// unchanged subtrees are not walked
+		localCount = int(rollups[""].Count)
+	}
+	if opts.Delete && localCount == 0 && !opts.AllowEmptySource {
+		for _, q := range queues {
+			q.Close()
+		}
+		return fmt.Errorf("source %s has no files, refusing to delete everything under %s, use --allow-empty-source if this is intended", localPath, r.RemotePath(remotePath))
+	}
+
+	for _, target := range targets {
+		if !opts.VerifyListing || opts.DryRun || len(target.uploaded) == 0 {
+			continue
+		}
+		prefix := target.remotePath
+		if !single {
+			prefix = listingPrefix(target.remotePath, narrowDir)
+		}
+		log.Printf("Verifying %d uploads: %s ...\n", len(target.uploaded), target.client.RemotePath(prefix))
+		mismatched, err := target.verifyListing(ctx, prefix)
+		if err != nil {
+			err = fmt.Errorf("failed to list %s for verification: %v", target.client.RemotePath(prefix), err)
+		} else if len(mismatched) > 0 {
+			for _, line := range mismatched {
+				log.Printf("verify failed %s\n", line)
+			}
+			err = fmt.Errorf("%w: %d of %d uploads under %s, nothing was deleted", ErrListingMismatch, len(mismatched), len(target.uploaded), target.client.RemotePath(prefix))
+		}
+		if err != nil {
+			for _, q := range queues {
+				q.Close()
+			}
+			failures.Log()
+			return err
+		}
+	}
+
+	for _, target := range targets {
+		if opts.Pack == nil {
+			break
+		}
+		packed, err := target.client.SyncPack(ctx, target.remotePath, packFiles, *opts.Pack, opts.SizeOnly, opts.Delete, opts.DryRun)
+		if err != nil {
+			log.Printf("pack failed %s: %s\n", target.client.RemotePath(target.remotePath), ErrorDetail(err))
+			failures.Add(err)
+			continue
+		}
+		target.uploadCount += packed
+		if packed > 0 {
+			plan.Pack(target.client.RemotePath(target.remotePath), packed)
+		}
+		log.Printf("%d small files packed: %s\n", packed, target.client.RemotePath(packKey(target.remotePath, "")))
+	}
+
+	if opts.Delete && opts.MaxDelete > 0 {
+		pending := 0
+		for _, target := range targets {
+			pending += len(target.remoteFiles)
+		}
+		if pending > opts.MaxDelete {
+			for _, q := range queues {
+				q.Close()
+			}
+			failures.Log()
+			return fmt.Errorf("%w: %d objects would be deleted, more than --max-delete %d, nothing was deleted", ErrTooManyDeletes, pending, opts.MaxDelete)
+		}
+	}
+	var deleteStopped bool
+	for _, target := range targets {
+		if !opts.Delete || len(target.remoteFiles) == 0 {
+			continue
+		}
+		log.Printf("Starting file deletion: %s ...\n", target.client.RemotePath(target.remotePath))
+		deleteCount := 0
+		var protectedCount, deleted atomic.Int64
+
+		remoteKeys := make([]string, 0, len(target.remoteFiles))
+		for remoteKey := range target.remoteFiles {
+			remoteKeys = append(remoteKeys, remoteKey)
+		}
+		sort.Strings(remoteKeys)
+		// one DeleteObjects request per batch of keys
+		deleteBatch := func(client *R2Client, keys []string, objects []FileInfo) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			defer r.heartbeat()
+			log.Printf("deleting %d files: %s ...\n", len(keys), client.RemotePath(target.remotePath))
+			fullKeys := make([]string, len(keys))
+			for i, key := range keys {
+				fullKeys[i] = client.RemotePath(key)
+			}
+			failed := make(map[string]error)
+			if err := journal.Begin(fullKeys, objects); err != nil {
+				for _, key := range keys {
+					failed[key] = err
+				}
+			} else {
+				failed, err = client.DeleteObjects(ctx, keys, opts.DryRun)
+				breaker.Record(err)
+			}
+			for i, key := range keys {
+				fullKey := fullKeys[i]
+				if err := failed[key]; err != nil {
+					var protected *RetentionProtectedError
+					if errors.As(err, &protected) {
+						protectedCount.Add(1)
+						log.Printf("delete skipped: %v\n", protected)
+						continue
+					}
+					log.Printf("delete failed %s: %s\n", fullKey, ErrorDetail(err))
+					failures.Add(err)
+					emit(failedEvent("delete_failed", "", fullKey, 0, err))
+					continue
+				}
+				if err := journal.Done(fullKey, objects[i]); err != nil {
+					log.Printf("failed to journal delete %s: %v\n", fullKey, err)
+				}
+				deleted.Add(1)
+				emit(ProgressEvent{Type: "delete_done", Target: fullKey})
+				replicate(client, key, 0, true)
+			}
+		}
+		var batchKeys []string
+		var batchObjects []FileInfo
+		flush := func() {
+			if len(batchKeys) == 0 {
+				return
+			}
+			wg.Add(1)
+			semaphore <- struct{}{}
+			go deleteBatch(target.client, batchKeys, batchObjects)
+			batchKeys, batchObjects = nil, nil
+		}
+		notStarted := 0
+		for i, remoteKey := range remoteKeys {
+			breaker.Wait(ctx, opts.Drain)
+			if ctx.Err() != nil || Draining(opts.Drain) {
+				notStarted = len(remoteKeys) - i
+				break
+			}
+			object := target.remoteFiles[remoteKey]
+			if !journal.Allow(target.client.RemotePath(remoteKey), object) {
+				log.Printf("delete skipped: %s was re-created after an earlier run deleted it\n", target.client.RemotePath(remoteKey))
+				continue
+			}
+			plan.Delete(target.client.RemotePath(remoteKey), object.Size, "no local file")
+			deleteCount++
+			batchKeys = append(batchKeys, remoteKey)
+			batchObjects = append(batchObjects, object)
+			if len(batchKeys) == maxDeleteBatch {
+				flush()
+			}
+		}
+		if ctx.Err() != nil || Draining(opts.Drain) {
+			// nothing new is deleted after an interrupt, the batch being collected is dropped
+			deleteCount -= len(batchKeys)
+			notStarted += len(batchKeys)
+		} else {
+			flush()
+		}
+
+		wg.Wait()
+		target.deleteCount = deleteCount - int(protectedCount.Load())
+		log.Printf("%d files deleted.\n", target.deleteCount)
+		if n := protectedCount.Load(); n > 0 {
+			log.Printf("%d files retention-protected, not deleted.\n", n)
+		}
+		if ctx.Err() != nil || Draining(opts.Drain) {
+			log.Printf("Interrupted, stopped scheduling new deletes.\n")
+			logStopSummary("delete", int(deleted.Load()), deleteCount, failures.Total(), notStarted, false)
+			deleteStopped = true
+			break
+		}
+	}
+
+	journalDone = failures.Total() == 0 && ctx.Err() == nil && !Draining(opts.Drain)
+	for _, q := range queues {
+		q.Close()
+	}
+	if deleteStopped {
+		failures.Log()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return ErrInterrupted
+	}
+
+	for _, target := range targets {
+		// a failed transfer leaves its directory changed, so the next run compares it again
+		if !opts.DirManifests || opts.DryRun || failures.Total() > 0 {
+			break
+		}
+		if err := target.client.putDirManifests(ctx, target.remotePath, rollups, target.changed, opts.Concurrency); err != nil {
+			log.Printf("failed to store directory manifests %s: %s\n", target.client.RemotePath(target.remotePath), ErrorDetail(err))
+		}
+	}
+
+	for _, target := range targets {
+		if !opts.TreeManifest || opts.DryRun || failures.Total() > 0 {
+			break
+		}
+		if target.tree != nil && len(target.changed) == 0 {
+			continue
+		}
+		tree, err := nextTreeManifest(r.IOLimit, target.tree, localPath, rollups, target.unchanged)
+		if err == nil {
+			err = target.client.putTreeManifest(ctx, target.remotePath, tree)
+		}
+		if err != nil {
+			log.Printf("failed to store tree manifest %s: %s\n", target.client.RemotePath(target.remotePath), ErrorDetail(err))
+		}
+	}
+
+	// a failed upload keeps the previous mark, so the next run uploads the file again
+	if mark != nil && !opts.DryRun && failures.Total() == 0 {
+		if err := mark.Save(); err != nil {
+			log.Printf("failed to save high-water mark %s: %v\n", mark.path, err)
+		}
+	}
+
+	planSum := plan.Sum()
+	log.Printf("Plan: %d operations, hash %s\n", plan.Len(), planSum)
+	if opts.DryRun {
+		plan.Estimate().Log()
+	}
+	done := ProgressEvent{Type: "sync_done", Path: localPath, Target: r.RemotePath(remotePath), PlanHash: planSum}
+	for _, target := range targets {
+		done.Uploads += target.uploadCount
+		done.Deletes += target.deleteCount
+	}
+	emit(done)
+	failures.Log()
+	if opts.ExpectPlan != "" && planSum != opts.ExpectPlan {
+		return fmt.Errorf("%w: expected %s, got %s", ErrPlanDrift, opts.ExpectPlan, planSum)
+	}
+	if total := failures.Total(); total > 0 {
+		return fmt.Errorf("%d %w", total, ErrPartialFailure)
+	}
+	log.Println("Sync completed.")
+	return nil
+}
@@ -0,0 +1,210 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// writeFiles creates files relative to dir with their contents
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		localPath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(localPath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFiles returns the files below dir with their contents, relative to dir with slashes
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(localPath)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, localPath)
+		files[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSyncKeysRoundTrip(t *testing.T) {
+	files := map[string]string{
+		"a#b":              "hash",
+		"a%2Fb":            "escaped slash",
+		"100%.txt":         "percent",
+		"my file.txt":      "space",
+		"照片/旅行 2024.jpg":   "unicode",
+		"dir/notes#1.txt":  "nested hash",
+		"dir/a&b <c>.html": "xml characters",
+	}
+	if runtime.GOOS != "windows" {
+		// ? is not allowed in Windows file names
+		files["report?a=1"] = "query"
+		files["dir/why?.txt"] = "question mark"
+	}
+	f, client := newFakeS3(t)
+	ctx := context.Background()
+	source := t.TempDir()
+	writeFiles(t, source, files)
+
+	if err := client.Sync(ctx, source, "site", SyncOptions{Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if got, ok := f.get("site/" + name); !ok || got != data {
+			t.Errorf("object site/%s = %q, %v, want %q", name, got, ok, data)
+		}
+	}
+
+	// an unchanged tree lists every key back and uploads nothing
+	var uploads int
+	opts := SyncOptions{Recursive: true, Progress: func(event ProgressEvent) {
+		if event.Type == "upload_done" {
+			uploads++
+		}
+	}}
+	if err := client.Sync(ctx, source, "site", opts); err != nil {
+		t.Fatal(err)
+	}
+	if uploads != 0 {
+		t.Errorf("second sync uploaded %d files, want 0", uploads)
+	}
+
+	target := t.TempDir()
+	if err := client.Pull(ctx, "site", target, SyncOptions{Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFiles(t, target); !reflect.DeepEqual(got, files) {
+		t.Errorf("pulled files = %v, want %v", got, files)
+	}
+
+	if _, err := client.Replicate(ctx, client, "site", "copy", true, false, false, SyncOptions{Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if got, ok := f.get("copy/" + name); !ok || got != data {
+			t.Errorf("copied object copy/%s = %q, %v, want %q", name, got, ok, data)
+		}
+	}
+}
+
+func TestSyncDelete(t *testing.T) {
+	f, client := newFakeS3(t)
+	ctx := context.Background()
+	source := t.TempDir()
+	writeFiles(t, source, map[string]string{"keep.txt": "keep", "dir/new.txt": "new"})
+	f.put("site/keep.txt", "keep")
+	f.put("site/old.txt", "old")
+	f.put("site/dir/old.txt", "old")
+	f.put("site2/sibling.txt", "sibling prefix")
+	f.put("other.txt", "outside")
+
+	opts := SyncOptions{Recursive: true, Delete: true, DryRun: true}
+	if err := client.Sync(ctx, source, "site", opts); err != nil {
+		t.Fatal(err)
+	}
+	if f.deletes != 0 {
+		t.Errorf("dry run deleted %d objects", f.deletes)
+	}
+
+	opts.DryRun = false
+	if err := client.Sync(ctx, source, "site", opts); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"other.txt", "site/dir/new.txt", "site/keep.txt", "site2/sibling.txt"}
+	if got := f.keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after delete = %v, want %v", got, want)
+	}
+	if f.deletes != 2 {
+		t.Errorf("%d objects deleted, want 2", f.deletes)
+	}
+}
+
+func TestSyncMaxDelete(t *testing.T) {
+	f, client := newFakeS3(t)
+	ctx := context.Background()
+	source := t.TempDir()
+	writeFiles(t, source, map[string]string{"a.txt": "a"})
+	for _, key := range []string{"site/x", "site/y", "site/z"} {
+		f.put(key, "orphan")
+	}
+
+	opts := SyncOptions{Recursive: true, Delete: true, MaxDelete: 2}
+	if err := client.Sync(ctx, source, "site", opts); !errors.Is(err, ErrTooManyDeletes) {
+		t.Fatalf("Sync deleting 3 objects with MaxDelete 2 = %v, want ErrTooManyDeletes", err)
+	}
+	if f.deletes != 0 {
+		t.Errorf("Sync over MaxDelete deleted %d objects", f.deletes)
+	}
+
+	opts.MaxDelete = 3
+	if err := client.Sync(ctx, source, "site", opts); err != nil {
+		t.Fatal(err)
+	}
+	if f.deletes != 3 {
+		t.Errorf("%d objects deleted, want 3", f.deletes)
+	}
+}
+
+func TestSyncEmptySource(t *testing.T) {
+	f, client := newFakeS3(t)
+	ctx := context.Background()
+	f.put("site/a.txt", "a")
+
+	opts := SyncOptions{Recursive: true, Delete: true}
+	if err := client.Sync(ctx, t.TempDir(), "site", opts); err == nil {
+		t.Error("Sync from an empty source with Delete succeeded, want an error")
+	}
+	if _, ok := f.get("site/a.txt"); !ok {
+		t.Fatal("Sync from an empty source deleted the target")
+	}
+
+	opts.AllowEmptySource = true
+	if err := client.Sync(ctx, t.TempDir(), "site", opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.get("site/a.txt"); ok {
+		t.Error("Sync from an empty source with AllowEmptySource kept the target")
+	}
+}
+
+func TestReplicateEmptySource(t *testing.T) {
+	f, client := newFakeS3(t)
+	ctx := context.Background()
+	f.put("backup/a.txt", "a")
+
+	opts := SyncOptions{Recursive: true, Delete: true}
+	if _, err := client.Replicate(ctx, client, "typo", "backup", true, false, false, opts); err == nil {
+		t.Error("Replicate from an empty source with Delete succeeded, want an error")
+	}
+	if _, ok := f.get("backup/a.txt"); !ok {
+		t.Fatal("Replicate from an empty source deleted the target")
+	}
+
+	opts.AllowEmptySource = true
+	if _, err := client.Replicate(ctx, client, "typo", "backup", true, false, false, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.get("backup/a.txt"); ok {
+		t.Error("Replicate from an empty source with AllowEmptySource kept the target")
+	}
+}
@@ -0,0 +1,170 @@
+// Package r2sync syncs local directories with Cloudflare R2 and other S3 compatible buckets. It is
+// the engine of the r2sync command, which is a thin wrapper around it.
+package r2sync
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Syncer syncs local directories with the prefix of a remote url like r2://bucket/path. Syncers of
+// different prefixes of a bucket can share one client and its connection pool, see WithClient.
+type Syncer struct {
+	client     *R2Client
+	remotePath string
+	opts       SyncOptions
+	clientOpts []func(*s3.Options)
+	settings   []ClientOption // of the client NewSyncer creates
+}
+
+// Option configures a Syncer
+type Option func(*Syncer)
+
+// WithSyncOptions replaces the sync options, options given after it are applied on top
+func WithSyncOptions(opts SyncOptions) Option {
+	return func(s *Syncer) {
+		s.opts = opts
+	}
+}
+
+// WithDelete deletes remote files that don't exist locally, or local files without an object on Pull
+func WithDelete(deleteExtra bool) Option {
+	return func(s *Syncer) {
+		s.opts.Delete = deleteExtra
+	}
+}
+
+// WithDryRun only logs the operations that would be performed
+func WithDryRun(dryRun bool) Option {
+	return func(s *Syncer) {
+		s.opts.DryRun = dryRun
+	}
+}
+
+// WithRecursive syncs subdirectories, which a new Syncer does by default
+func WithRecursive(recursive bool) Option {
+	return func(s *Syncer) {
+		s.opts.Recursive = recursive
+	}
+}
+
+// WithConcurrency sets the number of concurrent transfers, 0 derives it from the plan
+func WithConcurrency(n int) Option {
+	return func(s *Syncer) {
+		s.opts.Concurrency = n
+	}
+}
+
+// WithSizeOnly compares files by size only instead of size and MD5
+func WithSizeOnly(sizeOnly bool) Option {
+	return func(s *Syncer) {
+		s.opts.SizeOnly = sizeOnly
+	}
+}
+
+// WithExclude skips files and directories matching any of the patterns unless a later WithInclude
+// pattern matches them
+func WithExclude(patterns ...string) Option {
+	return func(s *Syncer) {
+		for _, pattern := range patterns {
+			s.opts.Filters = append(s.opts.Filters, Filter{Pattern: NormalizePath(pattern)})
+		}
+	}
+}
+
+// WithInclude syncs files matching any of the patterns that an earlier WithExclude pattern skips
+func WithInclude(patterns ...string) Option {
+	return func(s *Syncer) {
+		for _, pattern := range patterns {
+			s.opts.Filters = append(s.opts.Filters, Filter{Pattern: NormalizePath(pattern), Include: true})
+		}
+	}
+}
+
+// WithProgress sends structured progress events to fn, which is called concurrently
+func WithProgress(fn func(ProgressEvent)) Option {
+	return func(s *Syncer) {
+		s.opts.Progress = fn
+	}
+}
+
+// WithOperations sends every planned operation to fn before it runs, e.g. to estimate the cost of
+// a dry run
+func WithOperations(fn func(Operation)) Option {
+	return func(s *Syncer) {
+		s.opts.Operations = fn
+	}
+}
+
+// WithClientOptions applies s3 client options like WithEndpoint or WithRequestLimit to the client
+func WithClientOptions(optFns ...func(*s3.Options)) Option {
+	return func(s *Syncer) {
+		s.clientOpts = append(s.clientOpts, optFns...)
+	}
+}
+
+// WithClientSettings applies client options like WithStrictKeys, WithDownloadTempDir or
+// WithHeartbeat to the client, so Syncers in one process can use different settings
+func WithClientSettings(options ...ClientOption) Option {
+	return func(s *Syncer) {
+		s.settings = append(s.settings, options...)
+	}
+}
+
+// WithClient syncs through an existing client of the url's bucket instead of creating one, e.g.
+// the Client of another Syncer. An R2Client is safe for concurrent use, so syncs of different
+// prefixes may run at the same time and share its connections. Client options can't be combined
+// with it.
+func WithClient(client *R2Client) Option {
+	return func(s *Syncer) {
+		s.client = client
+	}
+}
+
+// NewSyncer returns a Syncer for remoteURL. Client options given as query parameters of the url are
+// applied like on the command line unless WithClient shares an existing client, r2:// urls read the
+// R2_* environment variables of WithR2Env, and subdirectories are synced unless WithRecursive(false)
+// is given.
+func NewSyncer(remoteURL string, options ...Option) (*Syncer, error) {
+	scheme, bucket, remotePath, err := ParseRemoteURL(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+	s := &Syncer{remotePath: remotePath, opts: SyncOptions{Recursive: true}}
+	for _, option := range options {
+		option(s)
+	}
+	if s.client != nil {
+		if s.client.scheme != scheme || s.client.bucket != bucket {
+			return nil, fmt.Errorf("client of %s can't sync %s", s.client.RemotePath(""), remoteURL)
+		}
+		if _, query, _ := splitURLOptions(NormalizePath(remoteURL)); len(query) > 0 || len(s.clientOpts) > 0 || len(s.settings) > 0 {
+			return nil, fmt.Errorf("client options of %s can't be combined with a shared client", remoteURL)
+		}
+		return s, nil
+	}
+	clientOptions := append([]ClientOption{WithR2Env(), WithS3Options(s.clientOpts...)}, s.settings...)
+	s.client, err = NewClient(context.Background(), append(clientOptions, WithRemoteURL(remoteURL))...)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Client returns the client of the remote url, e.g. to list or delete objects directly
+func (s *Syncer) Client() *R2Client {
+	return s.client
+}
+
+// Sync uploads the files under localPath that differ from their objects, localPath may also be a
+// single file
+func (s *Syncer) Sync(ctx context.Context, localPath string) error {
+	return s.client.Sync(ctx, localPath, s.remotePath, s.opts)
+}
+
+// Pull downloads the objects under the remote prefix that differ from their files under localDir
+func (s *Syncer) Pull(ctx context.Context, localDir string) error {
+	return s.client.Pull(ctx, s.remotePath, localDir, s.opts)
+}
@@ -0,0 +1,135 @@
+package r2sync
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// KeyTransform renames relative paths before they become keys, so the local layout doesn't have
+// to match the bucket layout. Leading components are stripped first, then every rule is applied
+// in order.
+type KeyTransform struct {
+	strip int
+	rules []transformRule
+}
+
+// transformRule is a sed-style s/regex/replacement/flags substitution
+type transformRule struct {
+	re     *regexp.Regexp
+	repl   string // in regexp.Expand syntax
+	global bool
+}
+
+// parseTransformRule parses s/regex/replacement/[g]. Any delimiter may follow the s, escaped
+// delimiters are literal, \1 to \9 and & refer to the groups and the whole match like in sed.
+func parseTransformRule(spec string) (transformRule, error) {
+	if len(spec) < 2 || spec[0] != 's' {
+		return transformRule{}, fmt.Errorf("invalid transform %q, expected s/regex/replacement/", spec)
+	}
+	delim := spec[1]
+	var parts []string
+	var part strings.Builder
+	for i := 2; i < len(spec); i++ {
+		switch {
+		case spec[i] == '\\' && i+1 < len(spec) && spec[i+1] == delim:
+			part.WriteByte(delim)
+			i++
+		case spec[i] == '\\' && i+1 < len(spec):
+			part.WriteString(spec[i : i+2])
+			i++
+		case spec[i] == delim:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(spec[i])
+		}
+	}
+	parts = append(parts, part.String())
+	if len(parts) != 3 {
+		return transformRule{}, fmt.Errorf("invalid transform %q, expected s/regex/replacement/", spec)
+	}
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return transformRule{}, fmt.Errorf("invalid transform %q: %v", spec, err)
+	}
+	rule := transformRule{re: re, repl: sedReplacement(parts[1])}
+	for _, flag := range parts[2] {
+		if flag != 'g' {
+			return transformRule{}, fmt.Errorf("invalid transform %q, unknown flag %q", spec, flag)
+		}
+		rule.global = true
+	}
+	return rule, nil
+}
+
+// sedReplacement converts a sed replacement to regexp.Expand syntax
+func sedReplacement(repl string) string {
+	var b strings.Builder
+	for i := 0; i < len(repl); i++ {
+		switch c := repl[i]; {
+		case c == '\\' && i+1 < len(repl) && repl[i+1] >= '0' && repl[i+1] <= '9':
+			b.WriteString("${" + string(repl[i+1]) + "}")
+			i++
+		case c == '\\' && i+1 < len(repl):
+			b.WriteByte(repl[i+1])
+			i++
+		case c == '&':
+			b.WriteString("${0}")
+		case c == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (rule transformRule) apply(s string) string {
+	if rule.global {
+		return rule.re.ReplaceAllString(s, rule.repl)
+	}
+	match := rule.re.FindStringSubmatchIndex(s)
+	if match == nil {
+		return s
+	}
+	expanded := rule.re.ExpandString(nil, rule.repl, s, match)
+	return s[:match[0]] + string(expanded) + s[match[1]:]
+}
+
+// ParseKeyTransform returns nil when neither rules nor stripping are configured
+func ParseKeyTransform(specs []string, strip int) (*KeyTransform, error) {
+	if strip < 0 {
+		return nil, fmt.Errorf("invalid strip components %d, must not be negative", strip)
+	}
+	if len(specs) == 0 && strip == 0 {
+		return nil, nil
+	}
+	transform := &KeyTransform{strip: strip}
+	for _, spec := range specs {
+		rule, err := parseTransformRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		transform.rules = append(transform.rules, rule)
+	}
+	return transform, nil
+}
+
+// Apply returns the renamed relative path, false when the file is skipped because it has no more
+// components than are stripped or the rules leave nothing
+func (t *KeyTransform) Apply(relPath string) (string, bool) {
+	if t == nil {
+		return relPath, true
+	}
+	parts := strings.Split(relPath, "/")
+	if len(parts) <= t.strip {
+		return "", false
+	}
+	relPath = strings.Join(parts[t.strip:], "/")
+	for _, rule := range t.rules {
+		relPath = rule.apply(relPath)
+	}
+	relPath = strings.Trim(relPath, "/")
+	return relPath, relPath != ""
+}
@@ -0,0 +1,183 @@
+package r2sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+const treeManifestName = ".r2sync-tree.json.zst"
+
+// treeManifest is a Merkle tree of the synced tree stored as one object. Directories carry the
+// rollup hash of their subtree and the files directly inside, so changed directories are
+// compared against it without listing the bucket.
+type treeManifest struct {
+	Version int                 `json:"version"`
+	Dirs    map[string]*treeDir `json:"dirs"` // by path relative to the remote prefix, "" is the root
+}
+
+type treeDir struct {
+	Hash  string              `json:"hash"`
+	Files map[string]treeFile `json:"files"`
+}
+
+type treeFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	ETag    string    `json:"etag"`
+}
+
+func treeManifestKey(remotePath string) string {
+	return path.Join(remotePath, treeManifestName)
+}
+
+// loadTreeManifest returns the stored tree manifest, nil if there is none
+func (r *R2Client) loadTreeManifest(ctx context.Context, remotePath string) (*treeManifest, error) {
+	resp, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(treeManifestKey(remotePath)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	decoder, err := zstd.NewReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	manifest := &treeManifest{}
+	if err := json.NewDecoder(decoder).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("invalid tree manifest %s: %v", r.RemotePath(treeManifestKey(remotePath)), err)
+	}
+	return manifest, nil
+}
+
+// Compare walks the local rollups top-down and returns the directories whose subtree hash matches
+// the manifest and the directories that changed, children of unchanged directories are not visited
+func (m *treeManifest) Compare(rollups map[string]*dirRollup) (unchanged map[string]bool, changed []string) {
+	unchanged = make(map[string]bool)
+	level := []string{""}
+	for len(level) > 0 {
+		var next []string
+		for _, relDir := range level {
+			local := rollups[relDir]
+			if remote, ok := m.Dirs[relDir]; ok && remote.Hash == local.Hash {
+				unchanged[relDir] = true
+				continue
+			}
+			changed = append(changed, relDir)
+			for _, name := range local.dirs {
+				next = append(next, path.Join(relDir, name))
+			}
+		}
+		level = next
+	}
+	return unchanged, changed
+}
+
+// RemoteFiles returns the objects the manifest records directly inside the changed directories and
+// in directories that no longer exist locally
+func (m *treeManifest) RemoteFiles(remotePath string, rollups map[string]*dirRollup, changed []string) map[string]FileInfo {
+	result := make(map[string]FileInfo)
+	add := func(relDir string) {
+		dir, ok := m.Dirs[relDir]
+		if !ok {
+			return
+		}
+		for name, file := range dir.Files {
+			key := path.Join(remotePath, relDir, name)
+			result[key] = FileInfo{Path: key, Size: file.Size, LastModified: file.ModTime, ETag: file.ETag}
+		}
+	}
+	for _, relDir := range changed {
+		add(relDir)
+	}
+	for relDir := range m.Dirs {
+		// an unchanged parent would have matched without it, so its local parent changed
+		if _, local := rollups[relDir]; !local {
+			add(relDir)
+		}
+	}
+	return result
+}
+
+// nextTreeManifest builds the manifest of the local tree after a sync. Unchanged subtrees are
+// copied from the previous manifest, files of changed directories reuse the recorded ETag when
+// their size and modification time match and are hashed otherwise.
+func nextTreeManifest(limit *IOLimiter, previous *treeManifest, localPath string, rollups map[string]*dirRollup, unchanged map[string]bool) (*treeManifest, error) {
+	next := &treeManifest{Version: 1, Dirs: make(map[string]*treeDir, len(rollups))}
+	for relDir, rollup := range rollups {
+		if skipsDir(unchanged, relDir) && previous != nil {
+			if dir, ok := previous.Dirs[relDir]; ok {
+				next.Dirs[relDir] = dir
+				continue
+			}
+		}
+		dir := &treeDir{Hash: rollup.Hash, Files: make(map[string]treeFile, len(rollup.files))}
+		var old *treeDir
+		if previous != nil {
+			old = previous.Dirs[relDir]
+		}
+		for _, file := range rollup.files {
+			entry := treeFile{Size: file.size, ModTime: file.modTime}
+			if recorded, ok := old.file(file.name); ok && recorded.Size == file.size && recorded.ModTime.Equal(file.modTime) {
+				entry.ETag = recorded.ETag
+			} else {
+				etag, err := calcETag(limit, path.Join(localPath, relDir, file.name))
+				if err != nil {
+					return nil, err
+				}
+				entry.ETag = etag
+			}
+			dir.Files[file.name] = entry
+		}
+		next.Dirs[relDir] = dir
+	}
+	return next, nil
+}
+
+func (d *treeDir) file(name string) (treeFile, bool) {
+	if d == nil {
+		return treeFile{}, false
+	}
+	file, ok := d.Files[name]
+	return file, ok
+}
+
+// putTreeManifest stores the zstd compressed manifest
+func (r *R2Client) putTreeManifest(ctx context.Context, remotePath string, manifest *treeManifest) error {
+	var buf bytes.Buffer
+	encoder, err := zstd.NewWriter(&buf)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(encoder).Encode(manifest); err != nil {
+		encoder.Close()
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	_, err = r.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(r.bucket),
+		Key:           aws.String(treeManifestKey(remotePath)),
+		Body:          bytes.NewReader(buf.Bytes()),
+		ContentLength: aws.Int64(int64(buf.Len())),
+		ContentType:   aws.String("application/zstd"),
+	})
+	return err
+}
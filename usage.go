@@ -0,0 +1,190 @@
+package r2sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// UsageCount is the number of objects and bytes of a group of objects
+type UsageCount struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (c *UsageCount) add(size int64) {
+	c.Objects++
+	c.Bytes += size
+}
+
+// UsageSnapshot is the object count and size under a prefix at one point in time, grouped by
+// prefix and storage class. Snapshots are saved as JSON to compare later ones against.
+type UsageSnapshot struct {
+	Time           time.Time             `json:"time"`
+	URL            string                `json:"url"`
+	Depth          int                   `json:"depth"`
+	Total          UsageCount            `json:"total"`
+	Prefixes       map[string]UsageCount `json:"prefixes"` // by the first Depth path segments, "" for objects above them
+	StorageClasses map[string]UsageCount `json:"storage_classes"`
+}
+
+// usagePrefix returns the first depth path segments of key below prefix with a trailing slash, ""
+// for keys with fewer segments
+func usagePrefix(key, prefix string, depth int) string {
+	segments := strings.Split(strings.TrimPrefix(key, prefix), "/")
+	if len(segments) <= depth {
+		return ""
+	}
+	return strings.Join(segments[:depth], "/") + "/"
+}
+
+// Usage lists the objects under prefix and counts them by their first depth path segments and
+// their storage class. Listing a large bucket takes one request per 1000 objects.
+func (r *R2Client) Usage(ctx context.Context, prefix string, depth int) (*UsageSnapshot, error) {
+	snapshot := &UsageSnapshot{
+		Time:           time.Now().UTC(),
+		URL:            r.RemotePath(prefix),
+		Depth:          depth,
+		Prefixes:       make(map[string]UsageCount),
+		StorageClasses: make(map[string]UsageCount),
+	}
+	paginator := s3.NewListObjectsV2Paginator(r.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(r.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			size := aws.ToInt64(object.Size)
+			snapshot.Total.add(size)
+			group := usagePrefix(aws.ToString(object.Key), prefix, depth)
+			count := snapshot.Prefixes[group]
+			count.add(size)
+			snapshot.Prefixes[group] = count
+			class := string(object.StorageClass)
+			if class == "" {
+				class = "STANDARD"
+			}
+			count = snapshot.StorageClasses[class]
+			count.add(size)
+			snapshot.StorageClasses[class] = count
+		}
+		r.heartbeat()
+	}
+	return snapshot, nil
+}
+
+// LoadUsageSnapshot reads a snapshot saved by Save
+func LoadUsageSnapshot(path string) (*UsageSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot UsageSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid usage snapshot %s: %v", path, err)
+	}
+	return &snapshot, nil
+}
+
+// Save writes the snapshot as JSON to path
+func (s *UsageSnapshot) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// formatGrowth formats the change from previous to current like "+12 objects, +1.50 MB"
+func formatGrowth(current, previous UsageCount) string {
+	objects := current.Objects - previous.Objects
+	bytes := current.Bytes - previous.Bytes
+	sign := "+"
+	if bytes < 0 {
+		sign, bytes = "-", -bytes
+	}
+	return fmt.Sprintf("%+d objects, %s%s", objects, sign, formatSize(bytes))
+}
+
+// Report writes the snapshot to w, the top prefixes by size first. With a previous snapshot of the
+// same url, the growth since then is shown next to every group.
+func (s *UsageSnapshot) Report(w io.Writer, previous *UsageSnapshot, top int) {
+	// growth is shown when the previous snapshot has the same groups
+	line := func(label string, count UsageCount, before map[string]UsageCount, key string) {
+		fmt.Fprintf(w, "  %12d objects  %10s  %s", count.Objects, formatSize(count.Bytes), label)
+		if before != nil {
+			fmt.Fprintf(w, "  (%s)", formatGrowth(count, before[key]))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%s: %d objects, %s\n", s.URL, s.Total.Objects, formatSize(s.Total.Bytes))
+	if previous != nil {
+		elapsed := s.Time.Sub(previous.Time).Round(time.Minute)
+		fmt.Fprintf(w, "Growth since %s (%s): %s\n", previous.Time.Local().Format("2006-01-02 15:04:05"), elapsed, formatGrowth(s.Total, previous.Total))
+	}
+	var beforePrefixes, beforeClasses map[string]UsageCount
+	if previous != nil {
+		beforeClasses = previous.StorageClasses
+		if previous.Depth == s.Depth {
+			beforePrefixes = previous.Prefixes
+		}
+	}
+
+	prefixes := make([]string, 0, len(s.Prefixes))
+	for prefix := range s.Prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	// prefixes deleted since the previous snapshot are listed as empty
+	for prefix := range beforePrefixes {
+		if _, ok := s.Prefixes[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		a, b := s.Prefixes[prefixes[i]], s.Prefixes[prefixes[j]]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return prefixes[i] < prefixes[j]
+	})
+	fmt.Fprintln(w, "By prefix:")
+	var rest UsageCount
+	for i, prefix := range prefixes {
+		count := s.Prefixes[prefix]
+		if top > 0 && i >= top {
+			rest.Objects += count.Objects
+			rest.Bytes += count.Bytes
+			continue
+		}
+		label := prefix
+		if label == "" {
+			label = fmt.Sprintf("(files above depth %d)", s.Depth)
+		}
+		line(label, count, beforePrefixes, prefix)
+	}
+	if top > 0 && len(prefixes) > top {
+		fmt.Fprintf(w, "  %12d objects  %10s  (%d other prefixes)\n", rest.Objects, formatSize(rest.Bytes), len(prefixes)-top)
+	}
+
+	classes := make([]string, 0, len(s.StorageClasses))
+	for class := range s.StorageClasses {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	fmt.Fprintln(w, "By storage class:")
+	for _, class := range classes {
+		line(class, s.StorageClasses[class], beforeClasses, class)
+	}
+}
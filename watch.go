@@ -0,0 +1,213 @@
+package r2sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchMaxDelay is how many debounce windows a change waits at most while further events keep
+// arriving, so a file written continuously is still uploaded
+const watchMaxDelay = 10
+
+// Watch monitors the local tree after an initial sync and pushes changes as they happen, until
+// ctx is done or opts.Drain is closed. Events are collected until none arrived for debounce, then
+// changed files are synced one by one and created directories as a whole, and with opts.Delete
+// the objects of removed files and directories are deleted from every target.
+func (r *R2Client) Watch(ctx context.Context, localPath, remotePath string, opts SyncOptions, debounce time.Duration) error {
+	if info, err := os.Stat(localPath); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", localPath)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %v", localPath, err)
+	}
+	defer watcher.Close()
+	if err := watchTree(watcher, localPath, localPath, opts); err != nil {
+		return fmt.Errorf("failed to watch %s: %v", localPath, err)
+	}
+	// the state of a full run doesn't apply to the syncs of single changes
+	opts.CheckpointFile, opts.HighWaterFile, opts.ExpectPlan = "", "", ""
+	opts.ListingCache, opts.Inventory = nil, nil
+	opts.MaxRuntime, opts.AbortMultipartOlderThan = 0, 0
+	opts.ProbeBandwidth, opts.Prewarm = false, 0
+	log.Printf("Watching for changes: %s ...\n", localPath)
+
+	pending := make(map[string]bool) // relative paths with events since the last flush
+	var first time.Time              // of the oldest pending event
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	heartbeat := time.NewTicker(10 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-opts.Drain:
+			return ErrInterrupted
+		case <-heartbeat.C:
+			r.heartbeat()
+		case err := <-watcher.Errors:
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// events were lost, only a full sync catches up
+				log.Printf("Too many changes to watch, syncing the whole tree ...\n")
+				clear(pending)
+				if err := r.Sync(ctx, localPath, remotePath, opts); err != nil {
+					log.Printf("sync failed: %v\n", err)
+				}
+				continue
+			}
+			log.Printf("watch failed: %v\n", err)
+		case event := <-watcher.Events:
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			fullpath := NormalizePath(event.Name)
+			relPath, err := filepath.Rel(localPath, fullpath)
+			if err != nil || relPath == "." {
+				continue
+			}
+			relPath = NormalizePath(relPath)
+			if !opts.Recursive && strings.Contains(relPath, "/") {
+				continue
+			}
+			if info, err := os.Stat(fullpath); err == nil && info.IsDir() && event.Has(fsnotify.Create) && opts.Recursive {
+				if err := watchTree(watcher, localPath, fullpath, opts); err != nil {
+					log.Printf("failed to watch %s: %v\n", fullpath, err)
+				}
+			}
+			if len(pending) == 0 {
+				first = time.Now()
+			}
+			pending[relPath] = true
+			// the window restarts with every event, up to the longest delay of the oldest change
+			timer.Reset(min(debounce, time.Until(first.Add(watchMaxDelay*debounce))))
+		case <-timer.C:
+			changes := make([]string, 0, len(pending))
+			for relPath := range pending {
+				changes = append(changes, relPath)
+			}
+			clear(pending)
+			r.syncChanges(ctx, localPath, remotePath, changes, opts)
+		}
+	}
+}
+
+// watchTree adds watches for dir and, unless filtered out, its subdirectories
+func watchTree(watcher *fsnotify.Watcher, localPath, dir string, opts SyncOptions) error {
+	return filepath.WalkDir(dir, func(fullpath string, entry os.DirEntry, err error) error {
+		if err != nil {
+			// removed again before it was watched
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		fullpath = NormalizePath(fullpath)
+		if fullpath != localPath && (!opts.Recursive || filterReason(localPath, fullpath, true, opts) != "") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(fullpath)
+	})
+}
+
+// syncChanges uploads the changed files and created directories among the relative paths, and with
+// opts.Delete deletes the objects of those that no longer exist
+func (r *R2Client) syncChanges(ctx context.Context, localPath, remotePath string, changes []string, opts SyncOptions) {
+	// a directory is synced as a whole, which includes the changes inside it
+	sort.Strings(changes)
+	var covered string
+	for _, relPath := range changes {
+		if covered != "" && strings.HasPrefix(relPath, covered+"/") {
+			continue
+		}
+		fullpath := path.Join(localPath, relPath)
+		info, err := os.Stat(fullpath)
+		switch {
+		case err != nil:
+			if opts.Delete && errors.Is(err, os.ErrNotExist) && filterReason(localPath, fullpath, false, opts) == "" {
+				r.deleteRemoved(ctx, remotePath, relPath, opts)
+			}
+			continue
+		case filterReason(localPath, fullpath, info.IsDir(), opts) != "":
+			continue
+		case info.IsDir() && !opts.Recursive:
+			continue
+		case info.IsDir():
+			covered = relPath
+		case !info.Mode().IsRegular():
+			continue
+		}
+		// mirrors receive the change at the same relative path, and a directory created empty
+		// replaces everything under its key prefix
+		changeOpts := opts
+		changeOpts.AllowEmptySource = true
+		changeOpts.Mirrors = make([]Mirror, len(opts.Mirrors))
+		for i, mirror := range opts.Mirrors {
+			changeOpts.Mirrors[i] = Mirror{Client: mirror.Client, RemotePath: path.Join(mirror.RemotePath, relPath)}
+		}
+		changeOpts.ReplicateTo = make([]Mirror, len(opts.ReplicateTo))
+		for i, mirror := range opts.ReplicateTo {
+			changeOpts.ReplicateTo[i] = Mirror{Client: mirror.Client, RemotePath: path.Join(mirror.RemotePath, relPath)}
+		}
+		target := path.Join(remotePath, relPath)
+		if info.IsDir() {
+			target += "/"
+			for i := range changeOpts.Mirrors {
+				changeOpts.Mirrors[i].RemotePath += "/"
+			}
+			for i := range changeOpts.ReplicateTo {
+				changeOpts.ReplicateTo[i].RemotePath += "/"
+			}
+		}
+		if err := r.Sync(ctx, fullpath, target, changeOpts); err != nil {
+			log.Printf("sync failed %s: %v\n", fullpath, err)
+		}
+	}
+}
+
+// deleteRemoved deletes the object of a removed file, or the objects under a removed directory,
+// from the target and every mirror
+func (r *R2Client) deleteRemoved(ctx context.Context, remotePath, relPath string, opts SyncOptions) {
+	targets := append([]Mirror{{Client: r, RemotePath: remotePath}}, opts.Mirrors...)
+	targets = append(targets, opts.ReplicateTo...)
+	for _, target := range targets {
+		key := path.Join(target.RemotePath, relPath)
+		objects, err := target.Client.ListObjects(ctx, key)
+		if err != nil {
+			log.Printf("delete failed %s: %s\n", target.Client.RemotePath(key), ErrorDetail(err))
+			continue
+		}
+		var keys []string
+		for objectKey := range objects {
+			if objectKey == key || strings.HasPrefix(objectKey, key+"/") {
+				keys = append(keys, objectKey)
+			}
+		}
+		sort.Strings(keys)
+		failed, _ := target.Client.DeleteObjects(ctx, keys, opts.DryRun)
+		for _, key := range keys {
+			if err := failed[key]; err != nil {
+				log.Printf("delete failed %s: %s\n", target.Client.RemotePath(key), ErrorDetail(err))
+				continue
+			}
+			if opts.Progress != nil {
+				opts.Progress(ProgressEvent{Time: time.Now(), Type: "delete_done", Target: target.Client.RemotePath(key)})
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package r2sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// TransferWindow restricts new transfers to a daily time range in local time, which may wrap
+// around midnight like 22:00-06:00
+type TransferWindow struct {
+	spec       string
+	start, end time.Duration // offsets from midnight
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseTransferWindow parses a window like 01:00-06:00
+func ParseTransferWindow(spec string) (*TransferWindow, error) {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid transfer window %q, expected HH:MM-HH:MM", spec)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("invalid transfer window %q, start and end are equal", spec)
+	}
+	return &TransferWindow{spec: spec, start: start, end: end}, nil
+}
+
+// until returns how long until the window opens, 0 inside the window
+func (w *TransferWindow) until(now time.Time) time.Duration {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	inside := offset >= w.start && offset < w.end
+	if w.start > w.end {
+		inside = offset >= w.start || offset < w.end
+	}
+	switch {
+	case inside:
+		return 0
+	case offset < w.start:
+		return w.start - offset
+	default:
+		return 24*time.Hour - offset + w.start
+	}
+}
+
+// Wait blocks outside the window until it opens, ctx is cancelled or drain is closed. Transfers
+// started inside the window are not affected when it closes.
+func (w *TransferWindow) Wait(ctx context.Context, drain <-chan struct{}) {
+	if w == nil {
+		return
+	}
+	for {
+		wait := w.until(time.Now())
+		if wait == 0 {
+			return
+		}
+		log.Printf("Outside transfer window %s, paused until %s.\n", w.spec, time.Now().Add(wait).Format("15:04"))
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			log.Printf("Transfer window %s opened, resuming.\n", w.spec)
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-drain:
+			timer.Stop()
+			return
+		}
+	}
+}